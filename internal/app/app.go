@@ -14,7 +14,7 @@ func (a *App) Start(ctx context.Context) error {
 	return a.mcpServer.Start(ctx)
 }
 
+// Stop makes all data durable and releases storage
 func (a *App) Stop() error {
-	// TODO: implement graceful shutdown
-	return nil
+	return a.mcpServer.Close()
 }
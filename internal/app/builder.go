@@ -1,20 +1,42 @@
 package app
 
 import (
+	"compress/gzip"
+	"encoding/hex"
 	"fmt"
+	"time"
 
 	mcpserver "github.com/hop-/cachydb/internal/mcp"
+	"github.com/hop-/cachydb/pkg/db"
 )
 
 type Builder struct {
-	dbName    string
-	rootDir   string
-	transport string
-	port      int
+	dbName           string
+	rootDir          string
+	walDir           string
+	transport        string
+	port             int
+	format           string
+	compressionLevel int
+	checksum         string
+	prettyJSON       bool
+	timeFormat       string
+	encryptionKey    string
+	syncInterval     time.Duration
+	tolerantLoad     bool
+	recovery         bool
+	queryTimeout     time.Duration
+	maxPending       int
+	backpressure     string
+	maxWALSize       int64
+	autoCreate       bool
 }
 
 func NewBuilder() *Builder {
-	return &Builder{}
+	return &Builder{
+		compressionLevel: gzip.DefaultCompression,
+		syncInterval:     db.StorageSyncInterval,
+	}
 }
 
 func (b *Builder) WithDBName(name string) *Builder {
@@ -27,6 +49,13 @@ func (b *Builder) WithRootDir(dir string) *Builder {
 	return b
 }
 
+// WithWALDir sets the directory of the WAL files; empty keeps them in the
+// root directory
+func (b *Builder) WithWALDir(dir string) *Builder {
+	b.walDir = dir
+	return b
+}
+
 func (b *Builder) WithTransport(transport string) *Builder {
 	b.transport = transport
 	return b
@@ -37,12 +66,145 @@ func (b *Builder) WithPort(port int) *Builder {
 	return b
 }
 
+// WithFormat sets the default storage format, "binary" or "json"
+func (b *Builder) WithFormat(format string) *Builder {
+	b.format = format
+	return b
+}
+
+// WithCompression sets the gzip level for binary collections; when enabled
+// is false, documents are stored uncompressed regardless of level
+func (b *Builder) WithCompression(enabled bool, level int) *Builder {
+	if !enabled {
+		level = gzip.NoCompression
+	}
+	b.compressionLevel = level
+	return b
+}
+
+// WithChecksum sets the checksum of binary collection entries, "crc32" or "sha256"
+func (b *Builder) WithChecksum(checksum string) *Builder {
+	b.checksum = checksum
+	return b
+}
+
+// WithPrettyJSON indents the JSON files written, for debugging
+func (b *Builder) WithPrettyJSON(pretty bool) *Builder {
+	b.prettyJSON = pretty
+	return b
+}
+
+// WithTimeFormat sets the Go time layout that times in documents are stored
+// with; RFC 3339 if empty
+func (b *Builder) WithTimeFormat(layout string) *Builder {
+	b.timeFormat = layout
+	return b
+}
+
+// WithEncryptionKey sets the hex-encoded AES key (16, 24 or 32 bytes) used
+// for schema fields marked encrypted
+func (b *Builder) WithEncryptionKey(hexKey string) *Builder {
+	b.encryptionKey = hexKey
+	return b
+}
+
+// WithSyncInterval sets how often dirty data is saved and the WAL
+// checkpointed in the background; 0 only does it on shutdown
+func (b *Builder) WithSyncInterval(interval time.Duration) *Builder {
+	b.syncInterval = interval
+	return b
+}
+
+// WithTolerantLoad makes startup skip collections that fail to load instead
+// of failing
+func (b *Builder) WithTolerantLoad(tolerant bool) *Builder {
+	b.tolerantLoad = tolerant
+	return b
+}
+
+// WithChecksumRecovery makes startup recover documents failing their checksum
+// from older copies in the data file instead of failing
+func (b *Builder) WithChecksumRecovery(recovery bool) *Builder {
+	b.recovery = recovery
+	return b
+}
+
+// WithQueryTimeout limits how long a find or aggregate call may run; 0 means
+// no limit
+func (b *Builder) WithQueryTimeout(timeout time.Duration) *Builder {
+	b.queryTimeout = timeout
+	return b
+}
+
+// WithMaxPendingWrites bounds the writes waiting for a checkpoint; over the
+// limit, writes block or fail depending on mode, "block" or "error". A limit
+// of 0 disables backpressure.
+func (b *Builder) WithMaxPendingWrites(limit int, mode string) *Builder {
+	b.maxPending = limit
+	b.backpressure = mode
+	return b
+}
+
+// WithMaxWALSize checkpoints and truncates the WAL once it holds more than
+// size bytes; 0 disables the limit
+func (b *Builder) WithMaxWALSize(size int64) *Builder {
+	b.maxWALSize = size
+	return b
+}
+
+// WithAutoCreateCollections makes inserts into a missing collection create
+// it, without a schema, instead of failing
+func (b *Builder) WithAutoCreateCollections(enabled bool) *Builder {
+	b.autoCreate = enabled
+	return b
+}
+
 func (b *Builder) Build() (*App, error) {
 	httpAddr := fmt.Sprintf(":%d", b.port)
-	mcpServer, err := mcpserver.NewServer(b.dbName, b.rootDir, b.transport, httpAddr)
+
+	storageOpts := []db.StorageOption{
+		db.WithCompressionLevel(b.compressionLevel),
+		db.WithSyncInterval(b.syncInterval),
+		db.WithWALDir(b.walDir),
+	}
+	if b.tolerantLoad {
+		storageOpts = append(storageOpts, db.WithTolerantLoad())
+	}
+	if b.recovery {
+		storageOpts = append(storageOpts, db.WithChecksumRecovery())
+	}
+	if b.checksum != "" {
+		storageOpts = append(storageOpts, db.WithChecksum(db.ChecksumAlgorithm(b.checksum)))
+	}
+	if b.prettyJSON {
+		storageOpts = append(storageOpts, db.WithPrettyJSON())
+	}
+	if b.timeFormat != "" {
+		storageOpts = append(storageOpts, db.WithTimeFormat(b.timeFormat))
+	}
+	if b.maxPending != 0 {
+		storageOpts = append(storageOpts, db.WithMaxPendingWrites(b.maxPending, db.BackpressureMode(b.backpressure)))
+	}
+	if b.maxWALSize != 0 {
+		storageOpts = append(storageOpts, db.WithMaxWALSize(b.maxWALSize))
+	}
+	if b.encryptionKey != "" {
+		key, err := hex.DecodeString(b.encryptionKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key, expected hex: %w", err)
+		}
+		storageOpts = append(storageOpts, db.WithEncryptionKey(key))
+	}
+	if b.format != "" {
+		storageOpts = append(storageOpts, db.WithFormat(db.StorageFormat(b.format)))
+	}
+
+	mcpServer, err := mcpserver.NewServer(b.dbName, b.rootDir, b.transport, httpAddr, storageOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create MCP server: %w", err)
 	}
+	mcpServer.SetQueryTimeout(b.queryTimeout)
+	mcpServer.SetAutoCreateCollections(b.autoCreate)
 
 	return &App{mcpServer: mcpServer}, nil
 }
@@ -37,12 +37,102 @@ func setAllFlagsToCmd(cmd *cobra.Command) {
 		config.GetConfig().RootDir,
 		"root directory for application data and configurations",
 	)
+	cmd.Flags().StringVar(
+		&generalWALDir,
+		"wal-dir",
+		config.GetConfig().WALDir,
+		"directory of the write-ahead log files, the root directory if empty",
+	)
 	cmd.Flags().StringVarP(
 		&generalTransport,
 		"transport", "t",
 		"",
 		"transport type: stdio or http",
 	)
+	cmd.Flags().StringVar(
+		&generalFormat,
+		"format",
+		config.GetConfig().Format,
+		"storage format for collections without their own: binary or json",
+	)
+	cmd.Flags().BoolVar(
+		&generalCompress,
+		"compression",
+		config.GetConfig().Compression,
+		"compress documents in binary collections",
+	)
+	cmd.Flags().IntVar(
+		&generalCompLevel,
+		"compression-level",
+		config.GetConfig().CompLevel,
+		"gzip level for binary collections: -2 (Huffman only) to 9 (best), -1 for the default",
+	)
+	cmd.Flags().StringVar(
+		&generalChecksum,
+		"checksum",
+		config.GetConfig().Checksum,
+		"checksum of binary collection entries: crc32 (fast) or sha256 (stronger)",
+	)
+	cmd.Flags().BoolVar(
+		&generalPretty,
+		"pretty-json",
+		config.GetConfig().PrettyJSON,
+		"indent JSON files (metadata, indexes, JSON collections) for debugging",
+	)
+	cmd.Flags().StringVar(
+		&generalTimeFmt,
+		"time-format",
+		config.GetConfig().TimeFormat,
+		"Go time layout that times in documents are stored with, RFC 3339 if empty",
+	)
+	cmd.Flags().DurationVar(
+		&generalSyncEvery,
+		"sync-interval",
+		config.GetConfig().SyncInterval,
+		"how often dirty data is saved and the WAL checkpointed, 0 to only do it on shutdown",
+	)
+	cmd.Flags().BoolVar(
+		&generalTolerant,
+		"tolerant-load",
+		config.GetConfig().TolerantLoad,
+		"start even if some collections fail to load, leaving them unavailable",
+	)
+	cmd.Flags().BoolVar(
+		&generalRecovery,
+		"checksum-recovery",
+		config.GetConfig().Recovery,
+		"recover documents failing their checksum from older copies in the data file",
+	)
+	cmd.Flags().DurationVar(
+		&generalQueryLimit,
+		"query-timeout",
+		config.GetConfig().QueryTimeout,
+		"how long a find or aggregate call may run before failing, 0 for no limit",
+	)
+	cmd.Flags().IntVar(
+		&generalMaxPending,
+		"max-pending-writes",
+		config.GetConfig().MaxPending,
+		"writes allowed to wait for a checkpoint before backpressure applies, 0 for no limit",
+	)
+	cmd.Flags().StringVar(
+		&generalBackpress,
+		"backpressure",
+		config.GetConfig().Backpressure,
+		"what writes over --max-pending-writes do: block until a checkpoint or error",
+	)
+	cmd.Flags().Int64Var(
+		&generalMaxWAL,
+		"max-wal-size",
+		config.GetConfig().MaxWALSize,
+		"WAL size in bytes that triggers a checkpoint and truncation, 0 for no limit",
+	)
+	cmd.Flags().BoolVar(
+		&generalAutoCreate,
+		"auto-create-collections",
+		config.GetConfig().AutoCreate,
+		"create a missing collection, without a schema, on the first insert into it",
+	)
 }
 
 func executeApp() {
@@ -52,27 +142,41 @@ func executeApp() {
 		return
 	}
 
-	ctx := context.Background()
-	err = application.Start(ctx)
-	if err != nil {
-		// TODO: handle error appropriately
-		return
-	}
-
 	defer func() {
 		err := application.Stop()
 		if err != nil {
 			// TODO: handle error appropriately
 		}
 	}()
+
+	ctx := context.Background()
+	err = application.Start(ctx)
+	if err != nil {
+		// TODO: handle error appropriately
+		return
+	}
 }
 
 func buildApp() (*app.App, error) {
 	builder := app.NewBuilder().
 		WithDBName(config.GetConfig().DBName).
 		WithRootDir(generalRootDir).
+		WithWALDir(generalWALDir).
 		WithTransport(generalTransport).
-		WithPort(generalServerPort)
+		WithPort(generalServerPort).
+		WithFormat(generalFormat).
+		WithCompression(generalCompress, generalCompLevel).
+		WithChecksum(generalChecksum).
+		WithPrettyJSON(generalPretty).
+		WithTimeFormat(generalTimeFmt).
+		WithEncryptionKey(config.GetConfig().EncryptKey).
+		WithSyncInterval(generalSyncEvery).
+		WithTolerantLoad(generalTolerant).
+		WithChecksumRecovery(generalRecovery).
+		WithQueryTimeout(generalQueryLimit).
+		WithMaxPendingWrites(generalMaxPending, generalBackpress).
+		WithMaxWALSize(generalMaxWAL).
+		WithAutoCreateCollections(generalAutoCreate)
 
 	return builder.Build()
 }
@@ -27,7 +27,7 @@ func init() {
 }
 
 func runList(cmd *cobra.Command, args []string) error {
-	storage, err := db.NewStorageManager(generalRootDir)
+	storage, err := db.NewStorageManager(generalRootDir, db.WithWALDir(generalWALDir))
 	if err != nil {
 		return fmt.Errorf("failed to create storage manager: %w", err)
 	}
@@ -56,7 +56,7 @@ func runList(cmd *cobra.Command, args []string) error {
 					for _, collName := range collections {
 						coll, err := database.GetCollection(collName)
 						if err == nil {
-							docCount := len(coll.Documents)
+							docCount := coll.Count()
 							fmt.Printf("    └─ %s (%d documents)\n", collName, docCount)
 						}
 					}
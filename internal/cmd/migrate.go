@@ -71,7 +71,7 @@ func runMigrate(cmd *cobra.Command, args []string) error {
 	}
 
 	// Create storage manager
-	storage, err := db.NewStorageManager(generalRootDir)
+	storage, err := db.NewStorageManager(generalRootDir, db.WithWALDir(generalWALDir))
 	if err != nil {
 		return fmt.Errorf("failed to create storage manager: %w", err)
 	}
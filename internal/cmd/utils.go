@@ -21,6 +21,12 @@ func init() {
 		config.GetConfig().RootDir,
 		"root directory for application data and configurations",
 	)
+	utilsCmd.PersistentFlags().StringVar(
+		&generalWALDir,
+		"wal-dir",
+		config.GetConfig().WALDir,
+		"directory of the write-ahead log files, the root directory if empty",
+	)
 
 	rootCmd.AddCommand(utilsCmd)
 }
@@ -1,9 +1,26 @@
 package cmd
 
+import "time"
+
 var (
 	Version           = "" // This will be set during build time using -ldflags "-X github.com/hop-/cachydb/internal/cmd.Version=$(git describe --tags --always)"
 	defaultVersion    = "v0.0.0-dev"
 	generalRootDir    string
+	generalWALDir     string
 	generalServerPort int
 	generalTransport  string
+	generalFormat     string
+	generalCompress   bool
+	generalCompLevel  int
+	generalChecksum   string
+	generalPretty     bool
+	generalTimeFmt    string
+	generalSyncEvery  time.Duration
+	generalTolerant   bool
+	generalRecovery   bool
+	generalQueryLimit time.Duration
+	generalMaxPending int
+	generalBackpress  string
+	generalMaxWAL     int64
+	generalAutoCreate bool
 )
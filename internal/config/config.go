@@ -3,16 +3,34 @@ package config
 import (
 	"os"
 	"path"
+	"time"
 
 	"github.com/kelseyhightower/envconfig"
 )
 
 type Config struct {
-	Port        int    `env:"PORT" default:"7601"`
-	RootDir     string `env:"ROOT_DIR" default:""`
+	Port        int    `envconfig:"PORT" default:"7601"`
+	RootDir     string `envconfig:"ROOT_DIR" default:""`
 	RootDirName string `default:".cachydb"`
-	DBName      string `env:"DB_NAME" default:"main"`
-	Transport   string `env:"TRANSPORT" default:"stdio"`
+	WALDir      string `envconfig:"WAL_DIR" default:""` // WAL file directory, the root directory if empty
+	DBName      string `envconfig:"DB_NAME" default:"main"`
+	Transport   string `envconfig:"TRANSPORT" default:"stdio"`
+	Format      string `envconfig:"FORMAT" default:"binary"`
+	Compression bool   `envconfig:"COMPRESSION" default:"true"`
+	CompLevel   int    `envconfig:"COMPRESSION_LEVEL" default:"-1"` // gzip level, -2 (Huffman only) to 9 (best)
+	Checksum    string `envconfig:"CHECKSUM" default:"crc32"`       // binary entry checksum, crc32 or sha256
+	EncryptKey  string `envconfig:"ENCRYPTION_KEY" default:""`      // hex AES key for encrypted schema fields
+	PrettyJSON  bool   `envconfig:"PRETTY_JSON" default:"false"`    // indent JSON files for debugging
+	TimeFormat  string `envconfig:"TIME_FORMAT" default:""`         // Go layout of stored times, RFC 3339 if empty
+
+	SyncInterval time.Duration `envconfig:"SYNC_INTERVAL" default:"5s"`              // 0 disables periodic checkpoints
+	TolerantLoad bool          `envconfig:"TOLERANT_LOAD" default:"false"`           // skip collections that fail to load
+	Recovery     bool          `envconfig:"CHECKSUM_RECOVERY" default:"false"`       // recover corrupt documents from older copies
+	QueryTimeout time.Duration `envconfig:"QUERY_TIMEOUT" default:"30s"`             // per-call limit for find and aggregate, 0 for none
+	MaxPending   int           `envconfig:"MAX_PENDING_WRITES" default:"0"`          // writes allowed before a checkpoint, 0 for no limit
+	Backpressure string        `envconfig:"BACKPRESSURE" default:"block"`            // what writes over the limit do, block or error
+	MaxWALSize   int64         `envconfig:"MAX_WAL_SIZE" default:"0"`                // WAL bytes that trigger a checkpoint, 0 for no limit
+	AutoCreate   bool          `envconfig:"AUTO_CREATE_COLLECTIONS" default:"false"` // inserts create missing collections
 }
 
 var cfg Config
@@ -0,0 +1,176 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/hop-/cachydb/pkg/db"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// Error codes reported in structured tool errors
+const (
+	errCodeInvalidArgument  = "invalid_argument"
+	errCodeNotFound         = "not_found"
+	errCodeAlreadyExists    = "already_exists"
+	errCodeSchemaValidation = "schema_validation"
+	errCodeReadOnly         = "read_only"
+	errCodeUnavailable      = "unavailable"
+	errCodeTimeout          = "timeout"
+	errCodeBusy             = "busy"
+	errCodeFailed           = "failed"
+)
+
+// argumentError reports a missing or malformed tool argument
+type argumentError struct {
+	Argument string
+	Message  string
+}
+
+func (e *argumentError) Error() string {
+	return fmt.Sprintf("invalid argument '%s': %s", e.Argument, e.Message)
+}
+
+// invalidArgument returns an argumentError for the named argument
+func invalidArgument(argument, format string, args ...any) error {
+	return &argumentError{Argument: argument, Message: fmt.Sprintf(format, args...)}
+}
+
+// addTool registers a tool like mcp.AddTool, checking that required
+// arguments are set and reporting handler errors as structured tool errors.
+// Integer arguments in untyped values decode as int64 (see db.DecodeJSON).
+func addTool[In any](server *mcp.Server, tool *mcp.Tool, handler mcp.ToolHandlerFor[In, map[string]interface{}]) {
+	mcp.AddTool(server, tool, func(
+		ctx context.Context,
+		req *mcp.CallToolRequest,
+		input In,
+	) (*mcp.CallToolResult, map[string]interface{}, error) {
+		// The SDK decodes untyped numbers as float64; decode the arguments
+		// again so integers keep their exact value
+		if len(req.Params.Arguments) > 0 {
+			var exact In
+			if err := db.DecodeJSON(req.Params.Arguments, &exact); err == nil {
+				input = exact
+			}
+		}
+
+		if err := checkRequired(input); err != nil {
+			return toolError(err)
+		}
+
+		result, output, err := handler(ctx, req, input)
+		if err != nil {
+			return toolError(err)
+		}
+
+		// The SDK round-trips structured output through float64 when
+		// validating it; encode the text content ourselves to keep integers exact
+		if result == nil {
+			text, err := json.Marshal(output)
+			if err != nil {
+				return toolError(fmt.Errorf("failed to marshal result: %w", err))
+			}
+			result = &mcp.CallToolResult{
+				Content: []mcp.Content{&mcp.TextContent{Text: string(text)}},
+			}
+		}
+		return result, output, nil
+	})
+}
+
+// checkRequired returns an argumentError for the first required argument
+// left empty. Arguments are required when their json tag lacks omitempty,
+// which is also how they are marked required in the tool's input schema.
+func checkRequired(input any) error {
+	v := reflect.ValueOf(input)
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" || name == "-" || strings.Contains(opts, "omitempty") {
+			continue
+		}
+
+		value := v.Field(i)
+		switch value.Kind() {
+		case reflect.String:
+			if value.Len() == 0 {
+				return invalidArgument(name, "is required")
+			}
+		case reflect.Map, reflect.Slice:
+			if value.IsNil() {
+				return invalidArgument(name, "is required")
+			}
+		}
+	}
+
+	return nil
+}
+
+// toolError converts err to a tool result with IsError set. The text content
+// holds the message; the structured content holds an error object with a
+// machine-readable code and, where available, the offending argument or the
+// schema violations.
+func toolError(err error) (*mcp.CallToolResult, map[string]interface{}, error) {
+	result := &mcp.CallToolResult{
+		IsError: true,
+		Content: []mcp.Content{&mcp.TextContent{Text: err.Error()}},
+	}
+
+	return result, map[string]interface{}{
+		"success": false,
+		"error":   errorDetails(err),
+	}, nil
+}
+
+// errorDetails returns the error object of structured errors
+func errorDetails(err error) map[string]interface{} {
+	details := map[string]interface{}{
+		"code":    errorCode(err),
+		"message": err.Error(),
+	}
+
+	var argErr *argumentError
+	if errors.As(err, &argErr) {
+		details["argument"] = argErr.Argument
+	}
+
+	var validationErr *db.ValidationError
+	if errors.As(err, &validationErr) {
+		details["violations"] = validationErr.Violations
+	}
+
+	return details
+}
+
+// errorCode classifies an error for structured tool errors
+func errorCode(err error) string {
+	var argErr *argumentError
+	switch {
+	case errors.As(err, &argErr), errors.Is(err, db.ErrReservedField), errors.Is(err, db.ErrInvalidQuery), errors.Is(err, db.ErrInvalidUpdate):
+		return errCodeInvalidArgument
+	case errors.Is(err, db.ErrUnavailable): // before the load errors it wraps
+		return errCodeUnavailable
+	case errors.Is(err, db.ErrNotFound):
+		return errCodeNotFound
+	case errors.Is(err, db.ErrAlreadyExists):
+		return errCodeAlreadyExists
+	case errors.Is(err, db.ErrSchemaValidation):
+		return errCodeSchemaValidation
+	case errors.Is(err, db.ErrReadOnly):
+		return errCodeReadOnly
+	case errors.Is(err, db.ErrBusy):
+		return errCodeBusy
+	case errors.Is(err, context.DeadlineExceeded):
+		return errCodeTimeout
+	default:
+		return errCodeFailed
+	}
+}
@@ -0,0 +1,120 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+const (
+	collectionsURI       = "cachydb://collections"
+	collectionSchemaURI  = "cachydb://collections/{name}/schema"
+	collectionURIPrefix  = collectionsURI + "/"
+	schemaURISuffix      = "/schema"
+	resourceJSONMIMEType = "application/json"
+)
+
+// registerResources registers the read-only resources describing the
+// current database. They are read from live state on every request.
+func (s *Server) registerResources(server *mcp.Server) {
+	server.AddResource(&mcp.Resource{
+		URI:         collectionsURI,
+		Name:        "collections",
+		Description: "Collections of the current database with document and index counts",
+		MIMEType:    resourceJSONMIMEType,
+	}, s.collectionsResource)
+
+	server.AddResourceTemplate(&mcp.ResourceTemplate{
+		URITemplate: collectionSchemaURI,
+		Name:        "collection_schema",
+		Description: "Schema of a collection in the current database (null when it has none)",
+		MIMEType:    resourceJSONMIMEType,
+	}, s.collectionSchemaResource)
+}
+
+// CollectionCatalogEntry describes a collection in the collections resource
+type CollectionCatalogEntry struct {
+	Name          string `json:"name"`
+	DocumentCount int    `json:"document_count"`
+	IndexCount    int    `json:"index_count"`
+	HasSchema     bool   `json:"has_schema"`
+}
+
+func (s *Server) collectionsResource(
+	ctx context.Context,
+	req *mcp.ReadResourceRequest,
+) (*mcp.ReadResourceResult, error) {
+	database, err := s.getDatabase("")
+	if err != nil {
+		return nil, err
+	}
+
+	collections := make([]CollectionCatalogEntry, 0)
+	for _, name := range database.ListCollections() {
+		coll, err := database.GetCollection(name)
+		if err != nil {
+			return nil, err
+		}
+		collections = append(collections, CollectionCatalogEntry{
+			Name:          name,
+			DocumentCount: coll.Count(),
+			IndexCount:    len(coll.ListIndexes()),
+			HasSchema:     coll.GetSchema() != nil,
+		})
+	}
+
+	return jsonResource(req.Params.URI, map[string]interface{}{
+		"database":    database.Name,
+		"collections": collections,
+	})
+}
+
+func (s *Server) collectionSchemaResource(
+	ctx context.Context,
+	req *mcp.ReadResourceRequest,
+) (*mcp.ReadResourceResult, error) {
+	uri := req.Params.URI
+	name, ok := strings.CutPrefix(uri, collectionURIPrefix)
+	if ok {
+		name, ok = strings.CutSuffix(name, schemaURISuffix)
+	}
+	if !ok || name == "" {
+		return nil, mcp.ResourceNotFoundError(uri)
+	}
+	name, err := url.PathUnescape(name)
+	if err != nil {
+		return nil, mcp.ResourceNotFoundError(uri)
+	}
+
+	database, err := s.getDatabase("")
+	if err != nil {
+		return nil, err
+	}
+
+	coll, err := database.GetCollection(name)
+	if err != nil {
+		return nil, mcp.ResourceNotFoundError(uri)
+	}
+
+	return jsonResource(uri, coll.GetSchema())
+}
+
+// jsonResource returns a resource result holding value encoded as JSON
+func jsonResource(uri string, value any) (*mcp.ReadResourceResult, error) {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal resource: %w", err)
+	}
+
+	return &mcp.ReadResourceResult{
+		Contents: []*mcp.ResourceContents{{
+			URI:      uri,
+			MIMEType: resourceJSONMIMEType,
+			Text:     string(data),
+		}},
+	}, nil
+}
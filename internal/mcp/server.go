@@ -2,6 +2,7 @@ package mcpserver
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -19,11 +20,13 @@ type Server struct {
 	defaultDBName string
 	transport     string
 	httpAddr      string
+	queryTimeout  time.Duration
+	autoCreate    bool // inserts create missing collections
 }
 
-// NewServer creates a new MCP server
-func NewServer(defaultDBName, rootDir, transport, httpAddr string) (*Server, error) {
-	storage, err := db.NewStorageManager(rootDir)
+// NewServer creates a new MCP server. opts configure its storage manager.
+func NewServer(defaultDBName, rootDir, transport, httpAddr string, opts ...db.StorageOption) (*Server, error) {
+	storage, err := db.NewStorageManager(rootDir, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create storage manager: %w", err)
 	}
@@ -33,6 +36,20 @@ func NewServer(defaultDBName, rootDir, transport, httpAddr string) (*Server, err
 	if err != nil {
 		return nil, fmt.Errorf("failed to load databases: %w", err)
 	}
+	for _, loadErr := range storage.LoadErrors() {
+		log.Printf("Collection unavailable: %v\n", loadErr)
+	}
+	for _, rec := range storage.RecoveredDocuments() {
+		log.Printf("Recovered document '%s' in '%s/%s' from the copy at offset %d (entry at offset %d is corrupt)\n",
+			rec.DocumentID, rec.Database, rec.Collection, rec.Offset, rec.CorruptOffset)
+	}
+	if summary := storage.LastReplay(); summary != nil && summary.Replayed > 0 {
+		log.Printf("Replayed %d WAL entries in %s, up to offset %d written at %s\n",
+			summary.Replayed, summary.Duration, summary.LastOffset, summary.LastTimestamp.Format(time.RFC3339))
+	}
+	if summary := storage.LastReplay(); summary != nil && summary.Dropped > 0 {
+		log.Printf("Dropped %d WAL entries for unavailable collections\n", summary.Dropped)
+	}
 
 	// Start background storage syncer
 	storage.StartBackgroundSync(dbManager)
@@ -59,13 +76,28 @@ func NewServer(defaultDBName, rootDir, transport, httpAddr string) (*Server, err
 		Version: "1.0.0",
 	}, nil)
 
-	// Register all tools
+	// Register all tools and resources
 	s.registerTools(mcpServer)
+	s.registerResources(mcpServer)
 
 	s.server = mcpServer
 	return s, nil
 }
 
+// SetQueryTimeout limits how long a find_documents or aggregate call may run;
+// 0 means no limit
+func (s *Server) SetQueryTimeout(timeout time.Duration) {
+	s.queryTimeout = timeout
+}
+
+// SetAutoCreateCollections makes insert_document and the inserts of
+// batch_write create a missing collection, without a schema, instead of
+// failing. It is off by default, so a mistyped name doesn't create a
+// collection.
+func (s *Server) SetAutoCreateCollections(enabled bool) {
+	s.autoCreate = enabled
+}
+
 // Start starts the MCP server using the configured transport.
 func (s *Server) Start(ctx context.Context) error {
 	switch s.transport {
@@ -76,6 +108,18 @@ func (s *Server) Start(ctx context.Context) error {
 	}
 }
 
+// Close flushes and checkpoints all pending data and closes storage
+func (s *Server) Close() error {
+	flushErr := s.storage.Flush(true)
+	if err := s.storage.Close(); err != nil {
+		return fmt.Errorf("failed to close storage: %w", err)
+	}
+	if flushErr != nil {
+		return fmt.Errorf("failed to flush storage: %w", flushErr)
+	}
+	return nil
+}
+
 // startStdio starts the MCP server using the stdio transport.
 func (s *Server) startStdio(ctx context.Context) error {
 	return s.server.Run(ctx, &mcp.StdioTransport{})
@@ -90,6 +134,8 @@ func (s *Server) startHTTP(ctx context.Context) error {
 
 	mux := http.NewServeMux()
 	mux.Handle("/mcp", handler)
+	mux.HandleFunc("/find", s.findStreamHandler)
+	mux.HandleFunc("/healthz", s.healthzHandler)
 
 	httpServer := &http.Server{
 		Addr:    s.httpAddr,
@@ -109,6 +155,8 @@ func (s *Server) startHTTP(ctx context.Context) error {
 		addr = "localhost" + addr
 	}
 	log.Printf("CachyDB MCP server listening on http://%s/mcp (Streamable HTTP transport)\n", addr)
+	log.Printf("Streaming queries accepted at http://%s/find (NDJSON)\n", addr)
+	log.Printf("Health checks served at http://%s/healthz\n", addr)
 
 	if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		return fmt.Errorf("HTTP server error: %w", err)
@@ -119,68 +167,123 @@ func (s *Server) startHTTP(ctx context.Context) error {
 // registerTools registers all MCP tools
 func (s *Server) registerTools(server *mcp.Server) {
 	// Database management tools
-	mcp.AddTool(server, &mcp.Tool{
+	addTool(server, &mcp.Tool{
 		Name:        "create_database",
 		Description: "Create a new database",
 	}, s.createDatabaseTool)
 
-	mcp.AddTool(server, &mcp.Tool{
+	addTool(server, &mcp.Tool{
 		Name:        "list_databases",
 		Description: "List all databases",
 	}, s.listDatabasesTool)
 
-	mcp.AddTool(server, &mcp.Tool{
+	addTool(server, &mcp.Tool{
 		Name:        "delete_database",
 		Description: "Delete a database",
 	}, s.deleteDatabaseTool)
 
-	mcp.AddTool(server, &mcp.Tool{
+	addTool(server, &mcp.Tool{
 		Name:        "use_database",
 		Description: "Switch default database for subsequent operations",
 	}, s.useDatabaseTool)
 
-	mcp.AddTool(server, &mcp.Tool{
+	addTool(server, &mcp.Tool{
 		Name:        "current_database",
 		Description: "Get the current default database name",
 	}, s.currentDatabaseTool)
 
+	addTool(server, &mcp.Tool{
+		Name:        "database_stats",
+		Description: "Get document counts, index counts, and memory/disk sizes for a database and its collections",
+	}, s.databaseStatsTool)
+
+	addTool(server, &mcp.Tool{
+		Name:        "health",
+		Description: "Check that databases are loaded, the WAL is writable and the background sync is running",
+	}, s.healthTool)
+
 	// Collection management tools
-	mcp.AddTool(server, &mcp.Tool{
+	addTool(server, &mcp.Tool{
 		Name:        "create_collection",
 		Description: "Create a new collection with optional schema",
 	}, s.createCollectionTool)
 
-	mcp.AddTool(server, &mcp.Tool{
+	addTool(server, &mcp.Tool{
+		Name:        "set_query_limits",
+		Description: "Set the default and maximum number of documents find_documents returns for a collection",
+	}, s.setQueryLimitsTool)
+
+	addTool(server, &mcp.Tool{
+		Name:        "set_id_key",
+		Description: "Set the fields a collection derives the IDs of inserted documents from, so re-inserting the same record is rejected as a duplicate",
+	}, s.setIDKeyTool)
+
+	addTool(server, &mcp.Tool{
+		Name:        "validate_collection",
+		Description: "Check every document of a collection against its schema and list the ones that fail, e.g. after writes made with skip_validation",
+	}, s.validateCollectionTool)
+
+	addTool(server, &mcp.Tool{
 		Name:        "list_collections",
 		Description: "List all collections in a database",
 	}, s.listCollectionsTool)
 
 	// Document management tools
-	mcp.AddTool(server, &mcp.Tool{
+	addTool(server, &mcp.Tool{
 		Name:        "insert_document",
 		Description: "Insert a document into a collection",
 	}, s.insertDocumentTool)
 
-	mcp.AddTool(server, &mcp.Tool{
+	addTool(server, &mcp.Tool{
+		Name:        "get_document",
+		Description: "Get a single document by ID",
+	}, s.getDocumentTool)
+
+	addTool(server, &mcp.Tool{
 		Name:        "find_documents",
 		Description: "Find documents in a collection",
 	}, s.findDocumentsTool)
 
-	mcp.AddTool(server, &mcp.Tool{
+	addTool(server, &mcp.Tool{
+		Name:        "explain_query",
+		Description: "Show how find_documents would run a query: the index used, if any, and how many documents it examines",
+	}, s.explainQueryTool)
+
+	addTool(server, &mcp.Tool{
+		Name:        "aggregate",
+		Description: "Run an aggregation pipeline ($match, $group, $sort, $limit, $lookup, $project) over a collection",
+	}, s.aggregateTool)
+
+	addTool(server, &mcp.Tool{
 		Name:        "update_document",
 		Description: "Update a document by ID",
 	}, s.updateDocumentTool)
 
-	mcp.AddTool(server, &mcp.Tool{
+	addTool(server, &mcp.Tool{
 		Name:        "delete_document",
 		Description: "Delete a document by ID",
 	}, s.deleteDocumentTool)
 
+	addTool(server, &mcp.Tool{
+		Name:        "batch_write",
+		Description: "Apply an ordered list of insert, update and delete operations atomically; if any fails, none are applied",
+	}, s.batchWriteTool)
+
 	// Index management tools
-	mcp.AddTool(server, &mcp.Tool{
+	addTool(server, &mcp.Tool{
 		Name:        "create_index",
 		Description: "Create an index on a collection field",
 	}, s.createIndexTool)
+
+	addTool(server, &mcp.Tool{
+		Name:        "list_indexes",
+		Description: "List the indexes of a collection with their fields and types",
+	}, s.listIndexesTool)
+
+	addTool(server, &mcp.Tool{
+		Name:        "drop_index",
+		Description: "Drop an index from a collection",
+	}, s.dropIndexTool)
 }
 
 // Tool input/output types
@@ -202,30 +305,68 @@ type UseDatabaseInput struct {
 
 type CurrentDatabaseInput struct{}
 
+type DatabaseStatsInput struct {
+	Database string `json:"database,omitempty" jsonschema:"Database name (optional, defaults to configured database)"`
+}
+
+type HealthInput struct{}
+
 // Collection management inputs
 type CreateCollectionInput struct {
-	Database string                 `json:"database,omitempty" jsonschema:"Database name (optional, defaults to configured database)"`
-	Name     string                 `json:"name" jsonschema:"Name of the collection"`
-	Schema   map[string]interface{} `json:"schema,omitempty" jsonschema:"Optional schema definition with fields"`
+	Database     string                 `json:"database,omitempty" jsonschema:"Database name (optional, defaults to configured database)"`
+	Name         string                 `json:"name" jsonschema:"Name of the collection"`
+	Schema       map[string]interface{} `json:"schema,omitempty" jsonschema:"Optional schema definition with fields"`
+	Format       string                 `json:"format,omitempty" jsonschema:"Storage format for this collection: json or binary (optional, defaults to the server format)"`
+	DefaultLimit int                    `json:"default_limit,omitempty" jsonschema:"Limit applied to find_documents queries that set none (optional, 0 means unlimited)"`
+	MaxLimit     int                    `json:"max_limit,omitempty" jsonschema:"Largest limit a find_documents query may use (optional, 0 means unlimited)"`
+	IDKeyFields  []string               `json:"id_key_fields,omitempty" jsonschema:"Fields the IDs of documents inserted without one are derived from, as a SHA-256 hash (optional, defaults to random UUIDs)"`
+	Collation    string                 `json:"collation,omitempty" jsonschema:"How strings are ordered by sort and range filters: case_insensitive, or empty for byte-wise (optional)"`
+	Shards       int                    `json:"shards,omitempty" jsonschema:"Number of data files documents are spread across by a hash of their ID, binary format only (optional, fixed at creation, 0 or 1 means one)"`
+
+	InsertionOrder bool `json:"insertion_order,omitempty" jsonschema:"Track the order documents are inserted in, so find_documents without a sort returns them in that order (optional)"`
 }
 
 type InsertDocumentInput struct {
 	Database   string                 `json:"database,omitempty" jsonschema:"Database name (optional, defaults to configured database)"`
 	Collection string                 `json:"collection" jsonschema:"Name of the collection"`
-	Document   map[string]interface{} `json:"document" jsonschema:"Document data to insert"`
+	Document   map[string]interface{} `json:"document" jsonschema:"Document data to insert; a string _id sets the document ID"`
+
+	SkipValidation bool `json:"skip_validation,omitempty" jsonschema:"Insert without checking the schema (optional); validate_collection reports such documents if they don't match"`
+}
+
+type GetDocumentInput struct {
+	Database   string `json:"database,omitempty" jsonschema:"Database name (optional, defaults to configured database)"`
+	Collection string `json:"collection" jsonschema:"Name of the collection"`
+	ID         string `json:"id" jsonschema:"Document ID"`
 }
 
 type FindDocumentsInput struct {
 	Database   string                 `json:"database,omitempty" jsonschema:"Database name (optional, defaults to configured database)"`
 	Collection string                 `json:"collection" jsonschema:"Name of the collection"`
-	Query      map[string]interface{} `json:"query,omitempty" jsonschema:"Query filters, limit, and skip"`
+	Query      map[string]interface{} `json:"query,omitempty" jsonschema:"Query filters, $and/$or/$not conditions, sort, limit, and skip"`
+	Projection map[string]interface{} `json:"projection,omitempty" jsonschema:"Output fields: true/false to include/exclude, \"$field.path\" references, {\"$concat\": [...]} or {\"$literal\": value}"`
+	Stats      bool                   `json:"stats,omitempty" jsonschema:"Also return how the query ran: the index used, documents examined and returned, and elapsed nanoseconds (optional)"`
+}
+
+type ExplainQueryInput struct {
+	Database   string                 `json:"database,omitempty" jsonschema:"Database name (optional, defaults to configured database)"`
+	Collection string                 `json:"collection" jsonschema:"Name of the collection"`
+	Query      map[string]interface{} `json:"query,omitempty" jsonschema:"Query filters, sort, limit, and skip, as for find_documents"`
+}
+
+type AggregateInput struct {
+	Database   string              `json:"database,omitempty" jsonschema:"Database name (optional, defaults to configured database)"`
+	Collection string              `json:"collection" jsonschema:"Name of the collection"`
+	Pipeline   []db.AggregateStage `json:"pipeline" jsonschema:"Pipeline stages, each setting exactly one of $match, $group, $sort, $limit, $lookup, $project"`
 }
 
 type UpdateDocumentInput struct {
 	Database   string                 `json:"database,omitempty" jsonschema:"Database name (optional, defaults to configured database)"`
 	Collection string                 `json:"collection" jsonschema:"Name of the collection"`
 	ID         string                 `json:"id" jsonschema:"Document ID"`
-	Updates    map[string]interface{} `json:"updates" jsonschema:"Fields to update"`
+	Updates    map[string]interface{} `json:"updates" jsonschema:"Fields to replace, or update operators: {\"$set\": {\"address.city\": \"X\"}} sets nested fields by dot path, {\"$unset\": {\"field\": true}} removes fields, and $push, $addToSet and $pull change arrays"`
+
+	SkipValidation bool `json:"skip_validation,omitempty" jsonschema:"Update without checking the schema (optional); validate_collection reports such documents if they don't match"`
 }
 
 type DeleteDocumentInput struct {
@@ -234,6 +375,11 @@ type DeleteDocumentInput struct {
 	ID         string `json:"id" jsonschema:"Document ID"`
 }
 
+type BatchWriteInput struct {
+	Database   string       `json:"database,omitempty" jsonschema:"Database name (optional, defaults to configured database)"`
+	Operations []db.BatchOp `json:"operations" jsonschema:"Operations applied in order, each with op (insert, update or delete), collection, id, document (insert) or updates (update), and optionally skip_validation"`
+}
+
 type CreateIndexInput struct {
 	Database   string `json:"database,omitempty" jsonschema:"Database name (optional, defaults to configured database)"`
 	Collection string `json:"collection" jsonschema:"Name of the collection"`
@@ -241,6 +387,35 @@ type CreateIndexInput struct {
 	FieldName  string `json:"field_name" jsonschema:"Field to index"`
 }
 
+type ListIndexesInput struct {
+	Database   string `json:"database,omitempty" jsonschema:"Database name (optional, defaults to configured database)"`
+	Collection string `json:"collection" jsonschema:"Name of the collection"`
+}
+
+type DropIndexInput struct {
+	Database   string `json:"database,omitempty" jsonschema:"Database name (optional, defaults to configured database)"`
+	Collection string `json:"collection" jsonschema:"Name of the collection"`
+	IndexName  string `json:"index_name" jsonschema:"Name of the index to drop"`
+}
+
+type SetQueryLimitsInput struct {
+	Database     string `json:"database,omitempty" jsonschema:"Database name (optional, defaults to configured database)"`
+	Collection   string `json:"collection" jsonschema:"Name of the collection"`
+	DefaultLimit int    `json:"default_limit,omitempty" jsonschema:"Limit applied to find_documents queries that set none (0 means unlimited)"`
+	MaxLimit     int    `json:"max_limit,omitempty" jsonschema:"Largest limit a find_documents query may use (0 means unlimited)"`
+}
+
+type SetIDKeyInput struct {
+	Database   string   `json:"database,omitempty" jsonschema:"Database name (optional, defaults to configured database)"`
+	Collection string   `json:"collection" jsonschema:"Name of the collection"`
+	Fields     []string `json:"fields,omitempty" jsonschema:"Fields the IDs of documents inserted without one are derived from, as a SHA-256 hash (empty goes back to random UUIDs)"`
+}
+
+type ValidateCollectionInput struct {
+	Database   string `json:"database,omitempty" jsonschema:"Database name (optional, defaults to configured database)"`
+	Collection string `json:"collection" jsonschema:"Name of the collection"`
+}
+
 type ListCollectionsInput struct {
 	Database string `json:"database,omitempty" jsonschema:"Database name (optional, defaults to configured database)"`
 }
@@ -255,12 +430,184 @@ func (s *Server) getDatabase(dbName string) (*db.Database, error) {
 
 	database := s.dbManager.GetDatabase(dbName)
 	if database == nil {
-		return nil, fmt.Errorf("database '%s' not found", dbName)
+		return nil, fmt.Errorf("database '%s' %w", dbName, db.ErrNotFound)
 	}
 
 	return database, nil
 }
 
+// queryContext derives the context a query runs with from the request's,
+// applying the query timeout
+func (s *Server) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.queryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.queryTimeout)
+}
+
+// queryError reports a query that ran past the query timeout as such
+func (s *Server) queryError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("query exceeded the %s timeout: %w", s.queryTimeout, err)
+	}
+	return err
+}
+
+// intValue converts a decoded JSON number argument to an int
+func intValue(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case int64:
+		return int(v), true
+	case float64:
+		return int(v), true
+	}
+	return 0, false
+}
+
+// documentOutput converts a document to its output form, with "_id" and the
+// projection applied
+func documentOutput(doc *db.Document, projection db.Projection) (map[string]interface{}, error) {
+	docMap := make(map[string]interface{}, len(doc.Data)+1)
+	docMap["_id"] = doc.ID
+	for k, v := range doc.Data {
+		docMap[k] = v
+	}
+	if projection == nil {
+		return docMap, nil
+	}
+	return projection.Apply(docMap)
+}
+
+// Logical query operators, combining filters and other conditions
+const (
+	queryAnd = "$and" // a list of conditions that must all match
+	queryOr  = "$or"  // a list of conditions of which one must match
+	queryNot = "$not" // a condition that must not match
+)
+
+// parseQuery converts a query argument to a validated db.Query. Besides the
+// flat "filters" list, the query may set $and, $or and $not; all of them
+// must match.
+func parseQuery(raw map[string]interface{}) (*db.Query, error) {
+	query := &db.Query{}
+	if raw != nil {
+		if filters, ok := raw["filters"].([]interface{}); ok {
+			for i, f := range filters {
+				filterMap, ok := f.(map[string]interface{})
+				if !ok {
+					return nil, invalidArgument("query", "filter %d must be an object with field, operator and value", i)
+				}
+				query.Filters = append(query.Filters, parseFilter(filterMap))
+			}
+		}
+		var where []*db.Condition
+		for _, op := range []string{queryAnd, queryOr, queryNot} {
+			if value, ok := raw[op]; ok {
+				cond, err := parseCondition(map[string]interface{}{op: value}, "query")
+				if err != nil {
+					return nil, err
+				}
+				where = append(where, cond)
+			}
+		}
+		if len(where) == 1 {
+			query.Where = where[0]
+		} else if len(where) > 1 {
+			query.Where = &db.Condition{And: where}
+		}
+		if sortKeys, ok := raw["sort"].([]interface{}); ok {
+			for _, k := range sortKeys {
+				if keyMap, ok := k.(map[string]interface{}); ok {
+					key := db.SortField{}
+					if field, ok := keyMap["field"].(string); ok {
+						key.Field = field
+					}
+					if desc, ok := keyMap["desc"].(bool); ok {
+						key.Desc = desc
+					}
+					query.Sort = append(query.Sort, key)
+				}
+			}
+		}
+		if limit, ok := intValue(raw["limit"]); ok {
+			query.Limit = limit
+		}
+		if skip, ok := intValue(raw["skip"]); ok {
+			query.Skip = skip
+		}
+	}
+
+	if err := query.Validate(); err != nil {
+		return nil, invalidArgument("query", "%v", err)
+	}
+
+	return query, nil
+}
+
+// parseFilter converts a {field, operator, value} object to a filter
+func parseFilter(filterMap map[string]interface{}) db.QueryFilter {
+	filter := db.QueryFilter{}
+	if field, ok := filterMap["field"].(string); ok {
+		filter.Field = field
+	}
+	if op, ok := filterMap["operator"].(string); ok {
+		filter.Operator = op
+	}
+	if val, ok := filterMap["value"]; ok {
+		filter.Value = val
+	}
+	return filter
+}
+
+// parseCondition converts a query condition to a db.Condition: either a
+// filter object, or an object with a single $and or $or list of conditions
+// or a $not condition. path locates the condition in error messages.
+func parseCondition(raw interface{}, path string) (*db.Condition, error) {
+	condMap, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, invalidArgument("query", "%s must be an object", path)
+	}
+
+	op := ""
+	for _, key := range []string{queryAnd, queryOr, queryNot} {
+		if _, ok := condMap[key]; ok {
+			if op != "" || len(condMap) != 1 {
+				return nil, invalidArgument("query", "%s must set only one of a filter, $and, $or and $not", path)
+			}
+			op = key
+		}
+	}
+
+	switch op {
+	case "":
+		filter := parseFilter(condMap)
+		return &db.Condition{Filter: &filter}, nil
+	case queryNot:
+		not, err := parseCondition(condMap[op], path+"."+op)
+		if err != nil {
+			return nil, err
+		}
+		return &db.Condition{Not: not}, nil
+	}
+
+	list, ok := condMap[op].([]interface{})
+	if !ok {
+		return nil, invalidArgument("query", "%s.%s must be a list of conditions", path, op)
+	}
+	conditions := make([]*db.Condition, 0, len(list))
+	for i, item := range list {
+		cond, err := parseCondition(item, fmt.Sprintf("%s.%s[%d]", path, op, i))
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, cond)
+	}
+	if op == queryAnd {
+		return &db.Condition{And: conditions}, nil
+	}
+	return &db.Condition{Or: conditions}, nil
+}
+
 // Tool handlers
 
 // Database management handlers
@@ -300,8 +647,8 @@ func (s *Server) deleteDatabaseTool(
 	req *mcp.CallToolRequest,
 	input DeleteDatabaseInput,
 ) (*mcp.CallToolResult, map[string]interface{}, error) {
-	if !s.dbManager.DeleteDatabase(input.Name) {
-		return nil, nil, fmt.Errorf("database '%s' not found", input.Name)
+	if !s.dbManager.RemoveDatabase(input.Name) {
+		return nil, nil, fmt.Errorf("database '%s' %w", input.Name, db.ErrNotFound)
 	}
 
 	// Log to WAL (sync)
@@ -328,7 +675,7 @@ func (s *Server) useDatabaseTool(
 	// Check if database exists
 	database := s.dbManager.GetDatabase(input.Name)
 	if database == nil {
-		return nil, nil, fmt.Errorf("database '%s' not found", input.Name)
+		return nil, nil, fmt.Errorf("database '%s' %w", input.Name, db.ErrNotFound)
 	}
 
 	// Update default database
@@ -352,6 +699,39 @@ func (s *Server) currentDatabaseTool(
 	}, nil
 }
 
+func (s *Server) databaseStatsTool(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	input DatabaseStatsInput,
+) (*mcp.CallToolResult, map[string]interface{}, error) {
+	database, err := s.getDatabase(input.Database)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stats := s.storage.DatabaseStats(database)
+
+	return nil, map[string]interface{}{
+		"success":        true,
+		"stats":          stats,
+		"pending_writes": s.storage.PendingWrites(),
+	}, nil
+}
+
+func (s *Server) healthTool(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	input HealthInput,
+) (*mcp.CallToolResult, map[string]interface{}, error) {
+	status := s.storage.Health()
+
+	return nil, map[string]interface{}{
+		"success": true,
+		"healthy": status.Healthy,
+		"checks":  status.Checks,
+	}, nil
+}
+
 // Collection management handlers
 func (s *Server) createCollectionTool(
 	ctx context.Context,
@@ -378,20 +758,105 @@ func (s *Server) createCollectionTool(
 					if r, ok := fieldMap["required"].(bool); ok {
 						field.Required = r
 					}
+					if e, ok := fieldMap["encrypted"].(bool); ok {
+						field.Encrypted = e
+					}
+					if i, ok := fieldMap["index"].(bool); ok {
+						field.Index = i
+					}
+					if u, ok := fieldMap["unique"].(bool); ok {
+						field.Unique = u
+					}
 					schema.Fields[fieldName] = field
 				}
 			}
 		}
 	}
 
+	format := db.StorageFormat(input.Format)
+	switch format {
+	case "", db.FormatJSON, db.FormatBinary:
+	default:
+		return nil, nil, fmt.Errorf("invalid storage format '%s'", input.Format)
+	}
+
+	limits := db.QueryLimits{Default: input.DefaultLimit, Max: input.MaxLimit}
+	if err := limits.Validate(); err != nil {
+		return nil, nil, invalidArgument("default_limit", "%v", err)
+	}
+
+	var idKey *db.IDKey
+	if len(input.IDKeyFields) > 0 {
+		idKey = &db.IDKey{Fields: input.IDKeyFields}
+		if err := idKey.Validate(); err != nil {
+			return nil, nil, invalidArgument("id_key_fields", "%v", err)
+		}
+	}
+
+	collation := db.Collation(input.Collation)
+	if err := collation.Validate(); err != nil {
+		return nil, nil, invalidArgument("collation", "%v", err)
+	}
+	if input.Shards < 0 || input.Shards > db.MaxShards {
+		return nil, nil, invalidArgument("shards", "must be between 0 and %d", db.MaxShards)
+	}
+	if input.Shards > 1 && format == db.FormatJSON {
+		return nil, nil, invalidArgument("shards", "only binary collections can be sharded")
+	}
+
 	if err := database.CreateCollection(input.Name, schema); err != nil {
 		return nil, nil, err
 	}
 
+	coll, err := database.GetCollection(input.Name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if format != "" {
+		if err := coll.SetFormat(format); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := coll.SetQueryLimits(limits); err != nil {
+		return nil, nil, err
+	}
+	if err := coll.SetIDKey(idKey); err != nil {
+		return nil, nil, err
+	}
+	if err := coll.SetShards(input.Shards); err != nil {
+		return nil, nil, err
+	}
+	if err := coll.SetCollation(collation); err != nil {
+		return nil, nil, err
+	}
+	if err := coll.SetInsertionOrder(input.InsertionOrder); err != nil {
+		return nil, nil, err
+	}
+
 	// Log to WAL (sync) - storage save happens async in background
-	if err := s.storage.LogCreateCollection(database.Name, input.Name, schema); err != nil {
+	if err := s.storage.LogCreateCollection(database.Name, input.Name, schema, format, input.Shards); err != nil {
 		return nil, nil, fmt.Errorf("failed to log create collection: %w", err)
 	}
+	if limits != (db.QueryLimits{}) {
+		if err := s.storage.LogSetQueryLimits(database.Name, input.Name, limits); err != nil {
+			return nil, nil, fmt.Errorf("failed to log query limits: %w", err)
+		}
+	}
+	if idKey != nil {
+		if err := s.storage.LogSetIDKey(database.Name, input.Name, idKey); err != nil {
+			return nil, nil, fmt.Errorf("failed to log ID key: %w", err)
+		}
+	}
+	if collation != db.CollationBinary {
+		if err := s.storage.LogSetCollation(database.Name, input.Name, collation); err != nil {
+			return nil, nil, fmt.Errorf("failed to log collation: %w", err)
+		}
+	}
+	if input.InsertionOrder {
+		if err := s.storage.LogSetInsertionOrder(database.Name, input.Name, true); err != nil {
+			return nil, nil, fmt.Errorf("failed to log insertion order: %w", err)
+		}
+	}
 
 	return nil, map[string]interface{}{
 		"success": true,
@@ -399,6 +864,111 @@ func (s *Server) createCollectionTool(
 	}, nil
 }
 
+func (s *Server) setQueryLimitsTool(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	input SetQueryLimitsInput,
+) (*mcp.CallToolResult, map[string]interface{}, error) {
+	database, err := s.getDatabase(input.Database)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	coll, err := database.GetCollection(input.Collection)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	limits := db.QueryLimits{Default: input.DefaultLimit, Max: input.MaxLimit}
+	if err := limits.Validate(); err != nil {
+		return nil, nil, invalidArgument("default_limit", "%v", err)
+	}
+
+	if err := coll.SetQueryLimits(limits); err != nil {
+		return nil, nil, err
+	}
+
+	// Log to WAL (sync) - storage save happens async in background
+	if err := s.storage.LogSetQueryLimits(database.Name, input.Collection, limits); err != nil {
+		return nil, nil, fmt.Errorf("failed to log query limits: %w", err)
+	}
+
+	return nil, map[string]interface{}{
+		"success":       true,
+		"default_limit": limits.Default,
+		"max_limit":     limits.Max,
+	}, nil
+}
+
+func (s *Server) setIDKeyTool(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	input SetIDKeyInput,
+) (*mcp.CallToolResult, map[string]interface{}, error) {
+	database, err := s.getDatabase(input.Database)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	coll, err := database.GetCollection(input.Collection)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var key *db.IDKey
+	if len(input.Fields) > 0 {
+		key = &db.IDKey{Fields: input.Fields}
+		if err := key.Validate(); err != nil {
+			return nil, nil, invalidArgument("fields", "%v", err)
+		}
+	}
+
+	if err := coll.SetIDKey(key); err != nil {
+		return nil, nil, err
+	}
+
+	// Log to WAL (sync) - storage save happens async in background
+	if err := s.storage.LogSetIDKey(database.Name, input.Collection, key); err != nil {
+		return nil, nil, fmt.Errorf("failed to log ID key: %w", err)
+	}
+
+	fields := input.Fields
+	if fields == nil {
+		fields = []string{}
+	}
+	return nil, map[string]interface{}{
+		"success": true,
+		"fields":  fields,
+	}, nil
+}
+
+func (s *Server) validateCollectionTool(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	input ValidateCollectionInput,
+) (*mcp.CallToolResult, map[string]interface{}, error) {
+	database, err := s.getDatabase(input.Database)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	coll, err := database.GetCollection(input.Collection)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	invalid, err := coll.ValidateAllDetailed()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return nil, map[string]interface{}{
+		"success": true,
+		"valid":   len(invalid) == 0,
+		"invalid": invalid,
+	}, nil
+}
+
 func (s *Server) listCollectionsTool(
 	ctx context.Context,
 	req *mcp.CallToolRequest,
@@ -411,14 +981,42 @@ func (s *Server) listCollectionsTool(
 
 	collections := database.ListCollections()
 
-	return nil, map[string]interface{}{
+	result := map[string]interface{}{
 		"success":     true,
 		"collections": collections,
 		"database":    database.Name,
-	}, nil
+	}
+	if unavailable := database.UnavailableCollections(); len(unavailable) > 0 {
+		reasons := make(map[string]string, len(unavailable))
+		for name, err := range unavailable {
+			reasons[name] = err.Error()
+		}
+		result["unavailable"] = reasons
+	}
+
+	return nil, result, nil
 }
 
 // Document management handlers
+// collectionForInsert gets the collection a document is inserted into,
+// creating it if it is missing and auto-creation is on
+func (s *Server) collectionForInsert(database *db.Database, name string) (*db.Collection, error) {
+	if !s.autoCreate {
+		return database.GetCollection(name)
+	}
+
+	coll, created, err := database.GetOrCreateCollection(name, nil)
+	if err != nil {
+		return nil, err
+	}
+	if created {
+		if err := s.storage.LogCreateCollection(database.Name, name, nil, "", 0); err != nil {
+			return nil, fmt.Errorf("failed to log create collection: %w", err)
+		}
+	}
+	return coll, nil
+}
+
 func (s *Server) insertDocumentTool(
 	ctx context.Context,
 	req *mcp.CallToolRequest,
@@ -429,32 +1027,75 @@ func (s *Server) insertDocumentTool(
 		return nil, nil, err
 	}
 
-	coll, err := database.GetCollection(input.Collection)
+	doc, err := db.NewDocument(input.Document)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	doc := &db.Document{
-		Data: input.Document,
+	coll, err := s.collectionForInsert(database, input.Collection)
+	if err != nil {
+		return nil, nil, err
 	}
-	if id, ok := input.Document["_id"].(string); ok {
-		doc.ID = id
-		delete(input.Document, "_id")
+
+	// Wait while too many writes are pending (see db.WithMaxPendingWrites)
+	if err := s.storage.WaitForWriteCapacity(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	var opts []db.WriteOption
+	if input.SkipValidation {
+		opts = append(opts, db.SkipValidation())
 	}
 
-	if err := coll.Insert(doc); err != nil {
+	stored, err := coll.InsertReturning(doc, opts...)
+	if err != nil {
 		return nil, nil, err
 	}
 
 	// Log to WAL (sync) - storage save happens async in background
-	if err := s.storage.LogInsert(database.Name, input.Collection, doc); err != nil {
+	if err := s.storage.LogInsert(database.Name, input.Collection, stored); err != nil {
 		return nil, nil, fmt.Errorf("failed to log insert: %w", err)
 	}
 
 	return nil, map[string]interface{}{
-		"success": true,
-		"id":      doc.ID,
-		"message": fmt.Sprintf("Document inserted with ID: %s", doc.ID),
+		"success":  true,
+		"id":       stored.ID,
+		"document": stored,
+		"message":  fmt.Sprintf("Document inserted with ID: %s", stored.ID),
+	}, nil
+}
+
+// getDocumentTool fetches a document by ID. A missing document is reported
+// as a tool error with the not_found code.
+func (s *Server) getDocumentTool(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	input GetDocumentInput,
+) (*mcp.CallToolResult, map[string]interface{}, error) {
+	database, err := s.getDatabase(input.Database)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	coll, err := database.GetCollection(input.Collection)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	doc, err := coll.FindByID(input.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	docMap := make(map[string]interface{})
+	docMap["_id"] = doc.ID
+	for k, v := range doc.Data {
+		docMap[k] = v
+	}
+
+	return nil, map[string]interface{}{
+		"success":  true,
+		"document": docMap,
 	}, nil
 }
 
@@ -473,53 +1114,100 @@ func (s *Server) findDocumentsTool(
 		return nil, nil, err
 	}
 
-	query := &db.Query{}
-	if input.Query != nil {
-		if filters, ok := input.Query["filters"].([]interface{}); ok {
-			for _, f := range filters {
-				if filterMap, ok := f.(map[string]interface{}); ok {
-					filter := db.QueryFilter{}
-					if field, ok := filterMap["field"].(string); ok {
-						filter.Field = field
-					}
-					if op, ok := filterMap["operator"].(string); ok {
-						filter.Operator = op
-					}
-					if val, ok := filterMap["value"]; ok {
-						filter.Value = val
-					}
-					query.Filters = append(query.Filters, filter)
-				}
-			}
-		}
-		if limit, ok := input.Query["limit"].(float64); ok {
-			query.Limit = int(limit)
-		}
-		if skip, ok := input.Query["skip"].(float64); ok {
-			query.Skip = int(skip)
-		}
+	query, err := parseQuery(input.Query)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	projection := db.Projection(input.Projection)
+	if err := projection.Validate(); err != nil {
+		return nil, nil, invalidArgument("projection", "%v", err)
 	}
 
-	docs, err := coll.Find(query)
+	queryCtx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	docs, stats, err := coll.FindWithStatsContext(queryCtx, query)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, s.queryError(err)
 	}
 
 	// Convert documents to JSON for output
 	docsJSON := make([]interface{}, len(docs))
 	for i, doc := range docs {
-		docMap := make(map[string]interface{})
-		docMap["_id"] = doc.ID
-		for k, v := range doc.Data {
-			docMap[k] = v
+		if docsJSON[i], err = documentOutput(doc, projection); err != nil {
+			return nil, nil, err
 		}
-		docsJSON[i] = docMap
 	}
 
-	return nil, map[string]interface{}{
+	result := map[string]interface{}{
 		"success":   true,
 		"count":     len(docs),
 		"documents": docsJSON,
+	}
+	if limit := coll.EffectiveLimit(query.Limit); limit > 0 {
+		result["limit"] = limit
+	}
+	if input.Stats {
+		result["stats"] = stats
+	}
+
+	return nil, result, nil
+}
+
+func (s *Server) explainQueryTool(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	input ExplainQueryInput,
+) (*mcp.CallToolResult, map[string]interface{}, error) {
+	database, err := s.getDatabase(input.Database)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	coll, err := database.GetCollection(input.Collection)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	query, err := parseQuery(input.Query)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	plan, err := coll.Explain(query)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return nil, map[string]interface{}{
+		"success": true,
+		"plan":    plan,
+	}, nil
+}
+
+func (s *Server) aggregateTool(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	input AggregateInput,
+) (*mcp.CallToolResult, map[string]interface{}, error) {
+	database, err := s.getDatabase(input.Database)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	queryCtx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	rows, err := database.AggregateContext(queryCtx, input.Collection, input.Pipeline)
+	if err != nil {
+		return nil, nil, s.queryError(err)
+	}
+
+	return nil, map[string]interface{}{
+		"success": true,
+		"count":   len(rows),
+		"results": rows,
 	}, nil
 }
 
@@ -538,7 +1226,17 @@ func (s *Server) updateDocumentTool(
 		return nil, nil, err
 	}
 
-	if err := coll.Update(input.ID, input.Updates); err != nil {
+	// Wait while too many writes are pending (see db.WithMaxPendingWrites)
+	if err := s.storage.WaitForWriteCapacity(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	var opts []db.WriteOption
+	if input.SkipValidation {
+		opts = append(opts, db.SkipValidation())
+	}
+
+	if err := coll.Update(input.ID, input.Updates, opts...); err != nil {
 		return nil, nil, err
 	}
 
@@ -574,6 +1272,11 @@ func (s *Server) deleteDocumentTool(
 		return nil, nil, err
 	}
 
+	// Wait while too many writes are pending (see db.WithMaxPendingWrites)
+	if err := s.storage.WaitForWriteCapacity(ctx); err != nil {
+		return nil, nil, err
+	}
+
 	if err := coll.Delete(input.ID); err != nil {
 		return nil, nil, err
 	}
@@ -589,6 +1292,51 @@ func (s *Server) deleteDocumentTool(
 	}, nil
 }
 
+func (s *Server) batchWriteTool(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	input BatchWriteInput,
+) (*mcp.CallToolResult, map[string]interface{}, error) {
+	database, err := s.getDatabase(input.Database)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Missing collections are created even if the batch is then rolled back
+	if s.autoCreate {
+		for _, op := range input.Operations {
+			if op.Op != db.BatchInsert {
+				continue
+			}
+			if _, err := s.collectionForInsert(database, op.Collection); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	// Wait while too many writes are pending (see db.WithMaxPendingWrites)
+	if err := s.storage.WaitForWriteCapacity(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	results, err := database.ApplyBatch(input.Operations)
+	if err != nil {
+		return nil, nil, fmt.Errorf("batch rolled back: %w", err)
+	}
+
+	// Log to WAL (sync) - storage save happens async in background
+	if err := s.storage.LogBatch(database.Name, results); err != nil {
+		return nil, nil, err
+	}
+
+	return nil, map[string]interface{}{
+		"success": true,
+		"count":   len(results),
+		"results": results,
+		"summary": db.NewWriteResult(results),
+	}, nil
+}
+
 func (s *Server) createIndexTool(
 	ctx context.Context,
 	req *mcp.CallToolRequest,
@@ -618,3 +1366,57 @@ func (s *Server) createIndexTool(
 		"message": fmt.Sprintf("Index '%s' created on field '%s'", input.IndexName, input.FieldName),
 	}, nil
 }
+
+func (s *Server) listIndexesTool(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	input ListIndexesInput,
+) (*mcp.CallToolResult, map[string]interface{}, error) {
+	database, err := s.getDatabase(input.Database)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	coll, err := database.GetCollection(input.Collection)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	indexes := coll.ListIndexes()
+
+	return nil, map[string]interface{}{
+		"success": true,
+		"count":   len(indexes),
+		"indexes": indexes,
+	}, nil
+}
+
+func (s *Server) dropIndexTool(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	input DropIndexInput,
+) (*mcp.CallToolResult, map[string]interface{}, error) {
+	database, err := s.getDatabase(input.Database)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	coll, err := database.GetCollection(input.Collection)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := coll.DropIndex(input.IndexName); err != nil {
+		return nil, nil, err
+	}
+
+	// Log to WAL (sync) - storage save happens async in background
+	if err := s.storage.LogDropIndex(database.Name, input.Collection, input.IndexName); err != nil {
+		return nil, nil, fmt.Errorf("failed to log drop index: %w", err)
+	}
+
+	return nil, map[string]interface{}{
+		"success": true,
+		"message": fmt.Sprintf("Index '%s' dropped", input.IndexName),
+	}, nil
+}
@@ -0,0 +1,159 @@
+package mcpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/hop-/cachydb/pkg/db"
+)
+
+const (
+	// ndjsonContentType is the content type of streamed query results: one
+	// JSON object per line (newline-delimited JSON)
+	ndjsonContentType = "application/x-ndjson"
+
+	// streamFlushEvery is how many result lines are written between flushes
+	// to the client
+	streamFlushEvery = 100
+
+	// maxStreamRequestSize bounds the request body of streamed queries
+	maxStreamRequestSize = 1 << 20
+)
+
+// findStreamHandler serves POST /find, which runs a find_documents query and
+// streams the results as NDJSON instead of building them into one response.
+// The request body holds the find_documents arguments. Each line of the
+// response is one of:
+//
+//	{"document": {...}}                    a matching document
+//	{"done": true, "count": n}             the end of the results, with
+//	                                       "stats" when requested
+//	{"error": {"code": ..., "message": ...}} a failure after streaming began
+//
+// A response without a final done or error line was cut short. Errors
+// before the first line are returned as a JSON error object with a matching
+// HTTP status instead.
+func (s *Server) findStreamHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeHTTPError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxStreamRequestSize))
+	if err != nil {
+		writeHTTPError(w, http.StatusBadRequest, fmt.Errorf("failed to read request: %w", err))
+		return
+	}
+
+	var input FindDocumentsInput
+	if err := db.DecodeJSON(body, &input); err != nil {
+		writeHTTPError(w, http.StatusBadRequest, invalidArgument("body", "%v", err))
+		return
+	}
+	if err := checkRequired(input); err != nil {
+		writeHTTPError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	database, err := s.getDatabase(input.Database)
+	if err != nil {
+		writeHTTPError(w, httpStatus(err), err)
+		return
+	}
+	coll, err := database.GetCollection(input.Collection)
+	if err != nil {
+		writeHTTPError(w, httpStatus(err), err)
+		return
+	}
+	query, err := parseQuery(input.Query)
+	if err != nil {
+		writeHTTPError(w, httpStatus(err), err)
+		return
+	}
+	projection := db.Projection(input.Projection)
+	if err := projection.Validate(); err != nil {
+		writeHTTPError(w, http.StatusBadRequest, invalidArgument("projection", "%v", err))
+		return
+	}
+
+	queryCtx, cancel := s.queryContext(r.Context())
+	defer cancel()
+
+	enc := json.NewEncoder(w) // Encode ends each value with a newline
+	flusher, _ := w.(http.Flusher)
+	started := false
+	start := func() {
+		if !started {
+			w.Header().Set("Content-Type", ndjsonContentType)
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+	}
+
+	count := 0
+	stats := &db.QueryStats{}
+	for doc, err := range coll.FindIterWithStats(queryCtx, query, stats) {
+		var out map[string]interface{}
+		if err == nil {
+			out, err = documentOutput(doc, projection)
+		}
+		if err != nil {
+			err = s.queryError(err)
+			if !started {
+				writeHTTPError(w, httpStatus(err), err)
+				return
+			}
+			enc.Encode(map[string]interface{}{"error": errorDetails(err)}) //nolint:errcheck
+			return
+		}
+
+		start()
+		if err := enc.Encode(map[string]interface{}{"document": out}); err != nil {
+			return // the client went away
+		}
+		count++
+		if flusher != nil && count%streamFlushEvery == 0 {
+			flusher.Flush()
+		}
+	}
+
+	start()
+	done := map[string]interface{}{"done": true, "count": count}
+	if input.Stats {
+		done["stats"] = stats
+	}
+	enc.Encode(done) //nolint:errcheck
+}
+
+// writeHTTPError writes err as a JSON error object with the given status
+func writeHTTPError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{ //nolint:errcheck
+		"success": false,
+		"error":   errorDetails(err),
+	})
+}
+
+// httpStatus maps an error to the HTTP status of its error code
+func httpStatus(err error) int {
+	switch errorCode(err) {
+	case errCodeInvalidArgument, errCodeSchemaValidation:
+		return http.StatusBadRequest
+	case errCodeNotFound:
+		return http.StatusNotFound
+	case errCodeAlreadyExists:
+		return http.StatusConflict
+	case errCodeReadOnly:
+		return http.StatusForbidden
+	case errCodeUnavailable, errCodeBusy:
+		return http.StatusServiceUnavailable
+	case errCodeTimeout:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
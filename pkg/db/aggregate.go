@@ -0,0 +1,345 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// AggregateStage is a single stage of an aggregation pipeline.
+// Exactly one of the fields must be set.
+type AggregateStage struct {
+	Match   []QueryFilter `json:"$match,omitempty"`
+	Group   *GroupStage   `json:"$group,omitempty"`
+	Sort    []SortField   `json:"$sort,omitempty"`
+	Limit   int           `json:"$limit,omitempty"`
+	Lookup  *LookupStage  `json:"$lookup,omitempty"`
+	Project Projection    `json:"$project,omitempty"`
+}
+
+// LookupStage embeds the document from another collection whose ForeignField
+// equals the row's LocalField. The embedded field is null when the local
+// field is missing or null, or when no document matches.
+type LookupStage struct {
+	From         string `json:"from"`                    // Collection to look up in (same database)
+	LocalField   string `json:"local_field"`             // Field of the row holding the reference
+	ForeignField string `json:"foreign_field,omitempty"` // Indexed field of the target collection; defaults to "_id"
+	As           string `json:"as"`                      // Field the referenced document is stored under
+}
+
+// collectionResolver resolves collection names for $lookup stages
+type collectionResolver func(name string) (*Collection, error)
+
+// GroupStage groups rows by a field and computes accumulators per group.
+// Each output row has the group key under "_id" and one field per accumulator.
+type GroupStage struct {
+	By           string                 `json:"by"` // Field to group by; empty groups all rows together
+	Accumulators map[string]Accumulator `json:"accumulators"`
+}
+
+// Accumulator computes a value over the rows of a group
+type Accumulator struct {
+	Op    string `json:"op"`              // "count", "sum", "avg", "min", "max"
+	Field string `json:"field,omitempty"` // Not used by "count"
+}
+
+// SortField orders rows by a field
+type SortField struct {
+	Field string `json:"field"`
+	Desc  bool   `json:"desc,omitempty"`
+}
+
+// Aggregate runs an aggregation pipeline over the collection's documents.
+// Rows start out as documents (with "_id") and are transformed by each stage in order.
+// $lookup stages need other collections; use Database.Aggregate for those.
+func (c *Collection) Aggregate(pipeline []AggregateStage) ([]map[string]any, error) {
+	return c.aggregate(context.Background(), pipeline, nil)
+}
+
+// Aggregate runs an aggregation pipeline over a collection of the database,
+// resolving $lookup stages against the database's other collections
+func (db *Database) Aggregate(collName string, pipeline []AggregateStage) ([]map[string]any, error) {
+	return db.AggregateContext(context.Background(), collName, pipeline)
+}
+
+// AggregateContext is like Aggregate but stops with an error wrapping
+// ctx.Err() once ctx is done. ctx is checked while building and matching
+// rows and between stages.
+func (db *Database) AggregateContext(ctx context.Context, collName string, pipeline []AggregateStage) ([]map[string]any, error) {
+	coll, err := db.GetCollection(collName)
+	if err != nil {
+		return nil, err
+	}
+
+	return coll.aggregate(ctx, pipeline, db.GetCollection)
+}
+
+// aggregate runs the pipeline, using resolve for $lookup stages
+func (c *Collection) aggregate(ctx context.Context, pipeline []AggregateStage, resolve collectionResolver) ([]map[string]any, error) {
+	docs, err := c.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	collation := c.GetCollation()
+	rows := make([]map[string]any, 0, len(docs))
+	for i, doc := range docs {
+		if i%contextCheckInterval == 0 {
+			if err := checkContext(ctx); err != nil {
+				return nil, err
+			}
+		}
+		rows = append(rows, docToRow(doc))
+	}
+
+	for i, stage := range pipeline {
+		if err := checkContext(ctx); err != nil {
+			return nil, err
+		}
+		var err error
+		rows, err = stage.apply(ctx, rows, resolve, collation)
+		if err != nil {
+			return nil, fmt.Errorf("stage %d: %w", i, err)
+		}
+	}
+
+	return rows, nil
+}
+
+// apply runs the stage over the given rows, comparing strings under collation
+func (s AggregateStage) apply(ctx context.Context, rows []map[string]any, resolve collectionResolver, collation Collation) ([]map[string]any, error) {
+	set := 0
+	if s.Match != nil {
+		set++
+	}
+	if s.Group != nil {
+		set++
+	}
+	if s.Sort != nil {
+		set++
+	}
+	if s.Limit != 0 {
+		set++
+	}
+	if s.Lookup != nil {
+		set++
+	}
+	if s.Project != nil {
+		set++
+	}
+	if set != 1 {
+		return nil, fmt.Errorf("stage must set exactly one of $match, $group, $sort, $limit, $lookup, $project")
+	}
+
+	switch {
+	case s.Match != nil:
+		if err := validateFilters(s.Match); err != nil {
+			return nil, err
+		}
+		return matchRows(ctx, rows, s.Match, collation)
+	case s.Group != nil:
+		return s.Group.apply(rows, collation)
+	case s.Sort != nil:
+		sortRows(rows, s.Sort, collation)
+		return rows, nil
+	case s.Lookup != nil:
+		if resolve == nil {
+			return nil, fmt.Errorf("$lookup requires Database.Aggregate")
+		}
+		return s.Lookup.apply(rows, resolve)
+	case s.Project != nil:
+		if err := s.Project.Validate(); err != nil {
+			return nil, err
+		}
+		for i, row := range rows {
+			rows[i] = s.Project.apply(row)
+		}
+		return rows, nil
+	default:
+		if s.Limit < 0 {
+			return nil, fmt.Errorf("$limit must be positive")
+		}
+		if s.Limit < len(rows) {
+			rows = rows[:s.Limit]
+		}
+		return rows, nil
+	}
+}
+
+// matchRows keeps the rows matching all filters
+func matchRows(ctx context.Context, rows []map[string]any, filters []QueryFilter, collation Collation) ([]map[string]any, error) {
+	matched := make([]map[string]any, 0, len(rows))
+	for i, row := range rows {
+		if i%contextCheckInterval == 0 {
+			if err := checkContext(ctx); err != nil {
+				return nil, err
+			}
+		}
+		doc := &Document{Data: row}
+		if id, ok := row["_id"].(string); ok {
+			doc.ID = id
+		}
+		if matchesAllFilters(doc, filters, collation) {
+			matched = append(matched, row)
+		}
+	}
+	return matched, nil
+}
+
+// sortRows sorts rows in place using the same comparison as query filters.
+// Missing and null values sort before all other values.
+func sortRows(rows []map[string]any, fields []SortField, collation Collation) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		for _, field := range fields {
+			cmp := collation.compareSortValues(rows[i][field.Field], rows[j][field.Field])
+			if cmp == 0 {
+				continue
+			}
+			if field.Desc {
+				return cmp > 0
+			}
+			return cmp < 0
+		}
+		return false
+	})
+}
+
+// apply embeds the referenced document into each row
+func (l *LookupStage) apply(rows []map[string]any, resolve collectionResolver) ([]map[string]any, error) {
+	if l.From == "" || l.LocalField == "" || l.As == "" {
+		return nil, fmt.Errorf("$lookup requires from, local_field and as")
+	}
+
+	target, err := resolve(l.From)
+	if err != nil {
+		return nil, err
+	}
+
+	foreignField := l.ForeignField
+	if foreignField == "" {
+		foreignField = "_id"
+	}
+
+	if err := target.rlock(); err != nil {
+		return nil, err
+	}
+	defer target.mu.RUnlock()
+
+	var index *Index
+	for _, idx := range target.Indexes {
+		if idx.FieldName == foreignField {
+			index = idx
+			break
+		}
+	}
+	if index == nil {
+		return nil, fmt.Errorf("$lookup: field '%s' of collection '%s' is not indexed", foreignField, l.From)
+	}
+
+	for _, row := range rows {
+		var embedded any
+		if value := row[l.LocalField]; value != nil {
+			if docID, found := index.Find(value); found {
+				if doc, exists := target.Documents[docID]; exists {
+					embedded = docToRow(doc)
+				}
+			}
+		}
+		row[l.As] = embedded
+	}
+
+	return rows, nil
+}
+
+// docToRow converts a document to a pipeline row, including "_id"
+func docToRow(doc *Document) map[string]any {
+	row := make(map[string]any, len(doc.Data)+1)
+	for k, v := range doc.Data {
+		row[k] = v
+	}
+	row["_id"] = doc.ID
+	return row
+}
+
+// apply groups rows and computes the accumulators for each group
+func (g *GroupStage) apply(rows []map[string]any, collation Collation) ([]map[string]any, error) {
+	for name, acc := range g.Accumulators {
+		switch acc.Op {
+		case "count":
+		case "sum", "avg", "min", "max":
+			if acc.Field == "" {
+				return nil, fmt.Errorf("accumulator '%s': field is required for '%s'", name, acc.Op)
+			}
+		default:
+			return nil, fmt.Errorf("accumulator '%s': unknown op '%s'", name, acc.Op)
+		}
+	}
+
+	// Group rows, keeping groups in order of first appearance
+	var keys []any
+	groups := make(map[string][]map[string]any)
+	for _, row := range rows {
+		var key any
+		if g.By != "" {
+			key = row[g.By]
+		}
+		groupKey := indexKey(key)
+		if _, exists := groups[groupKey]; !exists {
+			keys = append(keys, key)
+		}
+		groups[groupKey] = append(groups[groupKey], row)
+	}
+
+	result := make([]map[string]any, 0, len(keys))
+	for _, key := range keys {
+		members := groups[indexKey(key)]
+		out := map[string]any{"_id": key}
+		for name, acc := range g.Accumulators {
+			out[name] = acc.compute(members, collation)
+		}
+		result = append(result, out)
+	}
+
+	return result, nil
+}
+
+// compute evaluates the accumulator over a group's rows.
+// Non-numeric values are ignored by "sum" and "avg"; "avg", "min" and "max"
+// return nil when the group has no usable values.
+func (a Accumulator) compute(rows []map[string]any, collation Collation) any {
+	switch a.Op {
+	case "count":
+		return len(rows)
+	case "sum", "avg":
+		var sum float64
+		var n int
+		for _, row := range rows {
+			if f, ok := toFloat(row[a.Field]); ok {
+				sum += f
+				n++
+			}
+		}
+		if a.Op == "sum" {
+			return sum
+		}
+		if n == 0 {
+			return nil
+		}
+		return sum / float64(n)
+	default:
+		var best any
+		for _, row := range rows {
+			value, ok := row[a.Field]
+			if !ok || value == nil {
+				continue
+			}
+			cmp := 0
+			if best != nil {
+				cmp = collation.compareValues(value, best)
+			}
+			if best == nil || (a.Op == "min" && cmp < 0) || (a.Op == "max" && cmp > 0) {
+				best = value
+			}
+		}
+		return best
+	}
+}
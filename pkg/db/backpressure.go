@@ -0,0 +1,117 @@
+package db
+
+import (
+	"context"
+	"fmt"
+)
+
+// BackpressureMode decides what a write does when too many operations are
+// waiting for a checkpoint (see WithMaxPendingWrites)
+type BackpressureMode string
+
+// Backpressure modes
+const (
+	BackpressureBlock BackpressureMode = "block" // wait for the next checkpoint
+	BackpressureError BackpressureMode = "error" // fail with ErrBusy
+)
+
+// WithMaxPendingWrites bounds the WAL entries appended since the last
+// checkpoint, and so the WAL and the unsaved data behind them. Once limit
+// entries are pending, WaitForWriteCapacity asks the background syncer to
+// save and checkpoint right away and, depending on mode, waits for it or
+// returns ErrBusy. The limit is checked before each write, so a batch can
+// go past it. 0 disables the limit, which is the default.
+//
+// Blocking needs the background syncer started by StartBackgroundSync and
+// can't be combined with WithWALOnly, where only Flush checkpoints.
+func WithMaxPendingWrites(limit int, mode BackpressureMode) StorageOption {
+	return func(sm *StorageManager) {
+		sm.maxPending = limit
+		sm.backpressure = mode
+	}
+}
+
+// validateBackpressure checks the WithMaxPendingWrites settings
+func (sm *StorageManager) validateBackpressure() error {
+	if sm.maxPending < 0 {
+		return fmt.Errorf("invalid max pending writes %d", sm.maxPending)
+	}
+	if sm.maxPending == 0 {
+		return nil
+	}
+
+	switch sm.backpressure {
+	case BackpressureBlock:
+		if sm.walOnly {
+			return fmt.Errorf("blocking backpressure needs background checkpoints, which WAL-only mode disables")
+		}
+	case BackpressureError:
+	default:
+		return fmt.Errorf("unknown backpressure mode '%s'", sm.backpressure)
+	}
+	return nil
+}
+
+// PendingWrites returns how many WAL entries were appended since the last
+// checkpoint
+func (sm *StorageManager) PendingWrites() int {
+	if sm.WAL == nil {
+		return 0
+	}
+	return int(sm.WAL.Pending())
+}
+
+// WaitForWriteCapacity applies the WithMaxPendingWrites limit before a
+// write. It returns at once while fewer entries than the limit are pending.
+// Otherwise it requests a checkpoint and then, with BackpressureBlock, waits
+// until one brings the count under the limit or ctx is done, or, with
+// BackpressureError, returns an error wrapping ErrBusy.
+func (sm *StorageManager) WaitForWriteCapacity(ctx context.Context) error {
+	if sm.maxPending <= 0 || sm.WAL == nil {
+		return nil
+	}
+
+	for {
+		// Take the channel before counting, so a checkpoint in between isn't missed
+		sm.checkpointedMu.Lock()
+		checkpointed := sm.checkpointed
+		sm.checkpointedMu.Unlock()
+
+		pending := sm.WAL.Pending()
+		if pending < uint64(sm.maxPending) {
+			return nil
+		}
+
+		sm.requestSync()
+		if sm.backpressure == BackpressureError {
+			return fmt.Errorf("%w: %d writes waiting for a checkpoint", ErrBusy, pending)
+		}
+
+		select {
+		case <-checkpointed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// requestSync asks the background syncer to save and checkpoint without
+// waiting for its next tick. WAL-only mode never syncs in the background.
+func (sm *StorageManager) requestSync() {
+	if sm.walOnly {
+		return
+	}
+	select {
+	case sm.syncNow <- struct{}{}:
+	default: // a sync is already requested
+	}
+}
+
+// notifyCheckpointed wakes the writers waiting in WaitForWriteCapacity
+func (sm *StorageManager) notifyCheckpointed() {
+	sm.checkpointedMu.Lock()
+	defer sm.checkpointedMu.Unlock()
+
+	close(sm.checkpointed)
+	sm.checkpointed = make(chan struct{})
+}
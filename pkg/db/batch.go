@@ -0,0 +1,282 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Batch operation types
+const (
+	BatchInsert = "insert"
+	BatchUpdate = "update"
+	BatchDelete = "delete"
+)
+
+// BatchOp is a single write of a batch
+type BatchOp struct {
+	Op         string         `json:"op"` // "insert", "update" or "delete"
+	Collection string         `json:"collection"`
+	ID         string         `json:"id,omitempty"`       // Required for update and delete; optional for insert, which also accepts "_id" in the document
+	Document   map[string]any `json:"document,omitempty"` // Insert only
+	Updates    map[string]any `json:"updates,omitempty"`  // Update only
+
+	SkipValidation bool `json:"skip_validation,omitempty"` // Insert and update: don't check the schema (see SkipValidation)
+}
+
+// BatchResult is the outcome of a batch operation. Document is the stored
+// document after an insert or update, and nil after a delete.
+type BatchResult struct {
+	Op         string    `json:"op"`
+	Collection string    `json:"collection"`
+	ID         string    `json:"id"`
+	Document   *Document `json:"document,omitempty"`
+}
+
+// WriteResult summarizes what a write did. Matched counts the existing
+// documents it applied to, of which Modified were updated or replaced and
+// Deleted removed, and Inserted the new documents. IDs lists the documents
+// written, in the order they were; UpsertedIDs those an upsert inserted.
+type WriteResult struct {
+	Matched     int      `json:"matched"`
+	Inserted    int      `json:"inserted"`
+	Modified    int      `json:"modified"`
+	Deleted     int      `json:"deleted"`
+	UpsertedIDs []string `json:"upserted_ids,omitempty"`
+	IDs         []string `json:"ids"`
+}
+
+// NewWriteResult summarizes the results of a batch
+func NewWriteResult(results []BatchResult) *WriteResult {
+	summary := &WriteResult{IDs: make([]string, 0, len(results))}
+	for _, result := range results {
+		summary.record(result.Op, result.ID)
+	}
+	return summary
+}
+
+// record counts a document written by a batch operation
+func (r *WriteResult) record(op, id string) {
+	switch op {
+	case BatchInsert:
+		r.Inserted++
+	case BatchUpdate:
+		r.Matched++
+		r.Modified++
+	case BatchDelete:
+		r.Matched++
+		r.Deleted++
+	}
+	r.IDs = append(r.IDs, id)
+}
+
+// batchChange records a document's versions before and after an operation
+type batchChange struct {
+	coll          *Collection
+	before, after *Document
+	position      uint64 // before's position in insertion order, if tracked
+}
+
+// ApplyBatch applies the operations in order as a single unit: either all
+// of them are applied, or, if one fails, the ones before it are rolled back
+// and the error of the failing one is returned. All involved collections are
+// locked for the duration of the batch, so other readers and writers never
+// see it partly applied.
+func (db *Database) ApplyBatch(ops []BatchOp) ([]BatchResult, error) {
+	colls := make(map[string]*Collection)
+	for i, op := range ops {
+		if _, ok := colls[op.Collection]; ok {
+			continue
+		}
+		coll, err := db.GetCollection(op.Collection)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+		colls[op.Collection] = coll
+	}
+
+	// Lock in name order so concurrent batches can't deadlock
+	names := make([]string, 0, len(colls))
+	for name := range colls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		if err := colls[name].lock(); err != nil {
+			for _, locked := range names[:i] {
+				colls[locked].mu.Unlock()
+			}
+			return nil, err
+		}
+	}
+	defer func() {
+		for _, name := range names {
+			colls[name].mu.Unlock()
+		}
+	}()
+
+	results := make([]BatchResult, 0, len(ops))
+	changes := make([]batchChange, 0, len(ops))
+	for i, op := range ops {
+		coll := colls[op.Collection]
+		change, err := coll.applyBatchOpLocked(op)
+		if err != nil {
+			for j := len(changes) - 1; j >= 0; j-- {
+				changes[j].coll.revertLocked(changes[j])
+			}
+			return nil, fmt.Errorf("operation %d (%s): %w", i, op.Op, err)
+		}
+		changes = append(changes, change)
+
+		result := BatchResult{Op: op.Op, Collection: op.Collection, ID: op.ID}
+		if change.after != nil {
+			result.ID = change.after.ID
+			result.Document = change.after.Clone()
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// applyBatchOpLocked applies a batch operation (caller must hold mu)
+func (c *Collection) applyBatchOpLocked(op BatchOp) (batchChange, error) {
+	change := batchChange{coll: c}
+	opts := writeOptions{skipValidation: op.SkipValidation}
+
+	switch op.Op {
+	case BatchInsert:
+		doc := &Document{ID: op.ID, Data: make(map[string]any, len(op.Document))}
+		for k, v := range op.Document {
+			if k == "_id" {
+				if id, ok := v.(string); ok && doc.ID == "" {
+					doc.ID = id
+				}
+				continue
+			}
+			doc.Data[k] = v
+		}
+		if err := c.insertLocked(doc, opts); err != nil {
+			return change, err
+		}
+		change.after = c.Documents[doc.ID]
+	case BatchUpdate, BatchDelete:
+		if op.ID == "" {
+			return change, fmt.Errorf("id is required for %s", op.Op)
+		}
+		change.before = c.Documents[op.ID]
+		change.position = c.orderOfLocked(op.ID)
+		if op.Op == BatchUpdate {
+			if err := c.updateLocked(op.ID, op.Updates, opts); err != nil {
+				return change, err
+			}
+			change.after = c.Documents[op.ID]
+		} else if err := c.deleteLocked(op.ID); err != nil {
+			return change, err
+		}
+	default:
+		return change, fmt.Errorf("unknown operation '%s'", op.Op)
+	}
+
+	return change, nil
+}
+
+// revertLocked restores the version before of a document changed to after,
+// at its position in insertion order; before is nil for an insert and after
+// is nil for a delete (caller must hold mu)
+func (c *Collection) revertLocked(change batchChange) {
+	before, after := change.before, change.after
+	c.updateIndexes(after, before) //nolint:errcheck // index updates can't fail
+
+	if after != nil {
+		delete(c.Documents, after.ID)
+		delete(c.order, after.ID)
+		c.memSize -= docMemSize(after)
+	}
+	if before != nil {
+		c.Documents[before.ID] = before
+		c.restoreOrderLocked(before.ID, change.position)
+		c.memSize += docMemSize(before)
+	}
+	c.modCount++
+}
+
+// UpdateMany applies updates to every document matching filters. The result
+// lists the IDs of the updated documents in ID order. Matching and updating
+// happen under one write lock, all or nothing: if an update fails, e.g. on
+// schema validation, the documents updated before it are restored.
+func (c *Collection) UpdateMany(filters []QueryFilter, updates map[string]any, opts ...WriteOption) (*WriteResult, error) {
+	changes, err := c.updateMany(filters, updates, newWriteOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+	return changesResult(BatchUpdate, changes), nil
+}
+
+// DeleteMany deletes every document matching filters. The result lists the
+// IDs of the deleted documents in ID order.
+func (c *Collection) DeleteMany(filters []QueryFilter) (*WriteResult, error) {
+	changes, err := c.deleteMany(filters)
+	if err != nil {
+		return nil, err
+	}
+	return changesResult(BatchDelete, changes), nil
+}
+
+// updateMany is UpdateMany returning each document's versions
+func (c *Collection) updateMany(filters []QueryFilter, updates map[string]any, opts writeOptions) ([]batchChange, error) {
+	return c.changeMatching(filters, func(id string) error {
+		return c.updateLocked(id, updates, opts)
+	})
+}
+
+// deleteMany is DeleteMany returning each document's versions
+func (c *Collection) deleteMany(filters []QueryFilter) ([]batchChange, error) {
+	return c.changeMatching(filters, c.deleteLocked)
+}
+
+// changeMatching applies change to the documents matching filters, in ID
+// order, under the write lock, and reverts all of them if one fails
+func (c *Collection) changeMatching(filters []QueryFilter, change func(id string) error) ([]batchChange, error) {
+	if err := validateFilters(filters); err != nil {
+		return nil, err
+	}
+	if err := c.lock(); err != nil {
+		return nil, err
+	}
+	defer c.mu.Unlock()
+
+	if c.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	var ids []string
+	candidates, _ := c.findCandidatesLocked(filters)
+	for _, doc := range candidates {
+		if matchesAllFilters(doc, filters, c.Collation) {
+			ids = append(ids, doc.ID)
+		}
+	}
+	sort.Strings(ids)
+
+	changes := make([]batchChange, 0, len(ids))
+	for _, id := range ids {
+		before, position := c.Documents[id], c.orderOfLocked(id)
+		if err := change(id); err != nil {
+			for j := len(changes) - 1; j >= 0; j-- {
+				c.revertLocked(changes[j])
+			}
+			return nil, fmt.Errorf("document '%s': %w", id, err)
+		}
+		changes = append(changes, batchChange{coll: c, before: before, after: c.Documents[id], position: position})
+	}
+	return changes, nil
+}
+
+// changesResult summarizes the changes of one bulk operation
+func changesResult(op string, changes []batchChange) *WriteResult {
+	result := &WriteResult{IDs: make([]string, 0, len(changes))}
+	for _, change := range changes {
+		result.record(op, change.before.ID)
+	}
+	return result
+}
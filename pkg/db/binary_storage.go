@@ -1,12 +1,18 @@
 package db
 
 import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
 	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"hash/crc32"
 	"io"
 	"os"
 	"path/filepath"
+	"sync"
 )
 
 const (
@@ -14,20 +20,114 @@ const (
 	CollectionMagic = 0x43414348 // "CACH" in hex
 
 	// Version for binary format
-	BinaryFormatVersion = 1
+	BinaryFormatVersion = 3
 
 	// Header size: magic(4) + version(2) + flags(2) = 8 bytes
 	HeaderSize = 8
 
-	// Document entry header: offset(8) + size(4) + compressed_size(4) + checksum(4) = 20 bytes
-	DocEntryHeaderSize = 20
+	// Document entry header: offset(8) + size(4) + compressed_size(4) + checksum(4) + id_len(2) = 22 bytes,
+	// followed by the document ID and the compressed data. The checksum field
+	// is wider for checksum algorithms with longer sums (see ChecksumAlgorithm.Size).
+	DocEntryHeaderSize = 22
+
+	// Version 1 entry header: offset(8) + size(4) + compressed_size(4) + checksum(4) = 20 bytes
+	DocEntryHeaderSizeV1 = 20
+
+	// Maximum document ID length that fits in an entry header
+	MaxDocumentIDLength = 1<<16 - 1
+
+	// Magic number for offset index files
+	OffsetIndexMagic = 0x58444943 // "CIDX" in little-endian
+
+	// Version for offset index format
+	OffsetIndexVersion = 1
+
+	// Offset index header: magic(4) + version(2) + flags(2) + checksum(4) = 12 bytes
+	OffsetIndexHeaderSize = 12
+)
+
+// Header flag bits
+const (
+	flagCompressed    = 1 << 0
+	flagChecksumShift = 1 // bits 1-3 hold the checksum algorithm code (version 3+)
+	flagChecksumMask  = 7 << flagChecksumShift
 )
 
 // BinaryHeader represents the file header for binary storage
 type BinaryHeader struct {
 	Magic   uint32 // Magic number to identify file type
 	Version uint16 // Format version
-	Flags   uint16 // Flags (bit 0: compressed)
+	Flags   uint16 // Flags (bit 0: compressed, bits 1-3: checksum algorithm)
+}
+
+// ChecksumAlgorithm is the integrity check of binary collection entries.
+// Each data file records the algorithm it was written with.
+type ChecksumAlgorithm string
+
+const (
+	// ChecksumCRC32 is fast and detects accidental corruption; the default
+	ChecksumCRC32 ChecksumAlgorithm = "crc32"
+	// ChecksumSHA256 is slower but collision resistant, so modified entries
+	// can't be made to match by chance or by tweaking a few bytes. It is not
+	// a signature: someone able to rewrite the file can recompute it.
+	ChecksumSHA256 ChecksumAlgorithm = "sha256"
+)
+
+// checksumCodes maps checksum algorithms to their header flag codes
+var checksumCodes = map[ChecksumAlgorithm]uint16{
+	ChecksumCRC32:  0,
+	ChecksumSHA256: 1,
+}
+
+// Validate checks that the algorithm is known
+func (a ChecksumAlgorithm) Validate() error {
+	if _, ok := checksumCodes[a]; !ok {
+		return fmt.Errorf("unknown checksum algorithm '%s'", a)
+	}
+	return nil
+}
+
+// Size returns the number of bytes of the algorithm's sums in entry headers
+func (a ChecksumAlgorithm) Size() int {
+	if a == ChecksumSHA256 {
+		return sha256.Size
+	}
+	return crc32.Size
+}
+
+// sum computes the checksum of an entry's ID and compressed data.
+// Version 1 entries have no embedded ID, so only the data is covered.
+func (a ChecksumAlgorithm) sum(idData, compressedData []byte) []byte {
+	if a == ChecksumSHA256 {
+		h := sha256.New()
+		h.Write(idData)
+		h.Write(compressedData)
+		return h.Sum(nil)
+	}
+	return binary.LittleEndian.AppendUint32(nil, entryChecksum(idData, compressedData))
+}
+
+// checksumFromHeader returns the checksum algorithm a data file was written with
+func checksumFromHeader(header *BinaryHeader) (ChecksumAlgorithm, error) {
+	if header.Version < 3 {
+		return ChecksumCRC32, nil
+	}
+
+	code := (header.Flags & flagChecksumMask) >> flagChecksumShift
+	for alg, c := range checksumCodes {
+		if c == code {
+			return alg, nil
+		}
+	}
+	return "", fmt.Errorf("unknown checksum algorithm code %d", code)
+}
+
+// entryHeaderSize returns the size of the entry headers of a data file
+func entryHeaderSize(version uint16, checksum ChecksumAlgorithm) int64 {
+	if version == 1 {
+		return DocEntryHeaderSizeV1
+	}
+	return DocEntryHeaderSize - crc32.Size + int64(checksum.Size())
 }
 
 // DocumentEntry represents a single document entry in the binary file
@@ -35,7 +135,7 @@ type DocumentEntry struct {
 	Offset         int64  // Offset in the data file
 	Size           uint32 // Original size
 	CompressedSize uint32 // Size after compression (0 if not compressed)
-	Checksum       uint32 // CRC32 checksum
+	Checksum       uint32 // CRC32 checksum, or the first 4 bytes of a longer sum
 }
 
 // OffsetIndex maps document IDs to their locations in the binary file
@@ -43,25 +143,99 @@ type OffsetIndex struct {
 	Entries map[string]*DocumentEntry `json:"entries"`
 }
 
+// CompressionStats summarizes how well documents compress
+type CompressionStats struct {
+	UncompressedBytes int64   `json:"uncompressed_bytes"`
+	CompressedBytes   int64   `json:"compressed_bytes"`
+	Ratio             float64 `json:"ratio"` // uncompressed / compressed, higher is better (0 when empty)
+}
+
+// CompressionStats computes compression statistics from the index entries
+// without reading any documents
+func (idx *OffsetIndex) CompressionStats() CompressionStats {
+	var stats CompressionStats
+	for _, entry := range idx.Entries {
+		stats.UncompressedBytes += int64(entry.Size)
+		stats.CompressedBytes += int64(entry.CompressedSize)
+	}
+
+	if stats.CompressedBytes > 0 {
+		stats.Ratio = float64(stats.UncompressedBytes) / float64(stats.CompressedBytes)
+	}
+
+	return stats
+}
+
+// Entry returns a copy of the index entry of a document: where its current
+// copy starts in the data file, its size before and after compression and
+// its checksum
+func (idx *OffsetIndex) Entry(docID string) (*DocumentEntry, bool) {
+	entry, exists := idx.Entries[docID]
+	if !exists {
+		return nil, false
+	}
+	copied := *entry
+	return &copied, true
+}
+
 // BinaryCollectionWriter handles writing documents to binary storage
 type BinaryCollectionWriter struct {
-	dataFile  *os.File
-	indexFile *os.File
-	offset    int64
-	index     *OffsetIndex
+	dataFile         *os.File
+	indexFile        *os.File
+	offset           int64
+	index            *OffsetIndex
+	perms            FilePermissions
+	compressionLevel int
+	checksum         ChecksumAlgorithm
 }
 
-// NewBinaryCollectionWriter creates a new binary collection writer
-func NewBinaryCollectionWriter(dataDir, dbName, collName string) (*BinaryCollectionWriter, error) {
+// NewBinaryCollectionWriter creates a new binary collection writer. Entries
+// appended to an existing data file use the file's checksum algorithm; new
+// files use CRC32.
+func NewBinaryCollectionWriter(dataDir, dbName, collName string, perms FilePermissions) (*BinaryCollectionWriter, error) {
+	return newBinaryCollectionWriter(dataDir, dbName, collName, perms, "")
+}
+
+// newBinaryCollectionWriter creates a binary collection writer using the given
+// checksum algorithm, rewriting an existing data file that uses another one.
+// An empty algorithm keeps the existing file's.
+func newBinaryCollectionWriter(dataDir, dbName, collName string, perms FilePermissions, checksum ChecksumAlgorithm) (*BinaryCollectionWriter, error) {
 	collDir := filepath.Join(dataDir, dbName, collName)
-	if err := os.MkdirAll(collDir, 0755); err != nil {
+	if err := os.MkdirAll(collDir, perms.DirMode); err != nil {
 		return nil, fmt.Errorf("failed to create collection directory: %w", err)
 	}
+	if err := finishCompaction(collDir); err != nil {
+		return nil, err
+	}
 
 	dataPath := filepath.Join(collDir, "collection.data")
 
+	// Never append to a file in an older or unknown format
+	if err := ensureCurrentBinaryFormat(dataDir, dbName, collName, perms); err != nil {
+		return nil, err
+	}
+
+	// Every entry of a file uses the algorithm recorded in its header
+	existing, err := dataFileChecksum(dataPath)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case checksum == "" && existing != "":
+		checksum = existing
+	case checksum == "":
+		checksum = ChecksumCRC32
+	case existing != "" && existing != checksum:
+		if err := rewriteBinaryCollection(dataDir, dbName, collName, perms, checksum); err != nil {
+			return nil, fmt.Errorf("failed to rewrite data file with %s checksums: %w", checksum, err)
+		}
+	}
+	if err := checksum.Validate(); err != nil {
+		return nil, err
+	}
+
 	// Open or create data file
-	dataFile, err := os.OpenFile(dataPath, os.O_CREATE|os.O_RDWR, 0644)
+	dataFile, err := os.OpenFile(dataPath, os.O_CREATE|os.O_RDWR, perms.FileMode)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open data file: %w", err)
 	}
@@ -74,19 +248,25 @@ func NewBinaryCollectionWriter(dataDir, dbName, collName string) (*BinaryCollect
 	}
 
 	writer := &BinaryCollectionWriter{
-		dataFile: dataFile,
-		offset:   stat.Size(),
+		dataFile:         dataFile,
+		offset:           stat.Size(),
+		perms:            perms,
+		compressionLevel: gzip.DefaultCompression,
+		checksum:         checksum,
 		index: &OffsetIndex{
 			Entries: make(map[string]*DocumentEntry),
 		},
 	}
 
-	// Write header if file is new
+	// Write header if file is new, otherwise append after existing entries
 	if stat.Size() == 0 {
 		if err := writer.writeHeader(); err != nil {
 			dataFile.Close()
 			return nil, fmt.Errorf("failed to write header: %w", err)
 		}
+	} else if _, err := dataFile.Seek(0, io.SeekEnd); err != nil {
+		dataFile.Close()
+		return nil, fmt.Errorf("failed to seek data file: %w", err)
 	}
 
 	// Try to load existing index
@@ -103,7 +283,7 @@ func (w *BinaryCollectionWriter) writeHeader() error {
 	header := BinaryHeader{
 		Magic:   CollectionMagic,
 		Version: BinaryFormatVersion,
-		Flags:   1, // Bit 0 set = compressed
+		Flags:   flagCompressed | checksumCodes[w.checksum]<<flagChecksumShift,
 	}
 
 	buf := make([]byte, HeaderSize)
@@ -120,7 +300,8 @@ func (w *BinaryCollectionWriter) writeHeader() error {
 	return nil
 }
 
-// WriteDocument writes a document to the binary file
+// WriteDocument appends a document to the binary file, unless the index
+// already points at an identical copy of it
 func (w *BinaryCollectionWriter) WriteDocument(doc *Document) error {
 	// Serialize document to JSON
 	jsonData, err := doc.MarshalJSON()
@@ -129,26 +310,42 @@ func (w *BinaryCollectionWriter) WriteDocument(doc *Document) error {
 	}
 
 	// Compress the data
-	compressedData, err := Compress(jsonData)
+	compressedData, err := CompressLevel(jsonData, w.compressionLevel)
 	if err != nil {
 		return fmt.Errorf("failed to compress document: %w", err)
 	}
 
-	// Calculate checksum
-	checksum := crc32.ChecksumIEEE(compressedData)
+	idData := []byte(doc.ID)
+	if len(idData) > MaxDocumentIDLength {
+		return fmt.Errorf("document ID exceeds %d bytes", MaxDocumentIDLength)
+	}
+
+	// Calculate checksum over the ID and compressed data
+	checksum := w.checksum.sum(idData, compressedData)
+
+	// Saves write every document; only append those that changed, so the
+	// data file doesn't grow with identical copies
+	if w.unchanged(doc.ID, len(jsonData), compressedData, checksum) {
+		return nil
+	}
 
 	// Create entry header
-	entryBuf := make([]byte, DocEntryHeaderSize)
+	entryBuf := make([]byte, entryHeaderSize(BinaryFormatVersion, w.checksum))
 	binary.LittleEndian.PutUint64(entryBuf[0:8], uint64(w.offset))
 	binary.LittleEndian.PutUint32(entryBuf[8:12], uint32(len(jsonData)))
 	binary.LittleEndian.PutUint32(entryBuf[12:16], uint32(len(compressedData)))
-	binary.LittleEndian.PutUint32(entryBuf[16:20], checksum)
+	copy(entryBuf[16:], checksum)
+	binary.LittleEndian.PutUint16(entryBuf[16+len(checksum):], uint16(len(idData)))
 
-	// Write entry header + compressed data
+	// Write entry header + document ID + compressed data
 	if _, err := w.dataFile.Write(entryBuf); err != nil {
 		return fmt.Errorf("failed to write entry header: %w", err)
 	}
 
+	if _, err := w.dataFile.Write(idData); err != nil {
+		return fmt.Errorf("failed to write document ID: %w", err)
+	}
+
 	if _, err := w.dataFile.Write(compressedData); err != nil {
 		return fmt.Errorf("failed to write compressed data: %w", err)
 	}
@@ -158,22 +355,61 @@ func (w *BinaryCollectionWriter) WriteDocument(doc *Document) error {
 		Offset:         w.offset,
 		Size:           uint32(len(jsonData)),
 		CompressedSize: uint32(len(compressedData)),
-		Checksum:       checksum,
+		Checksum:       binary.LittleEndian.Uint32(checksum),
 	}
 
 	// Update offset for next write
-	w.offset += int64(DocEntryHeaderSize + len(compressedData))
+	w.offset += int64(len(entryBuf) + len(idData) + len(compressedData))
 
 	return nil
 }
 
+// unchanged reports whether the index already points at an entry holding
+// exactly these bytes for the document
+func (w *BinaryCollectionWriter) unchanged(id string, size int, compressed, checksum []byte) bool {
+	entry, exists := w.index.Entries[id]
+	if !exists || entry.Size != uint32(size) || entry.CompressedSize != uint32(len(compressed)) ||
+		entry.Checksum != binary.LittleEndian.Uint32(checksum) {
+		return false
+	}
+
+	// Compare the stored bytes too, as the index only keeps part of the checksum
+	stored := make([]byte, len(compressed))
+	dataOffset := entry.Offset + entryHeaderSize(BinaryFormatVersion, w.checksum) + int64(len(id))
+	if _, err := w.dataFile.ReadAt(stored, dataOffset); err != nil {
+		return false
+	}
+	return bytes.Equal(stored, compressed)
+}
+
+// retain drops the index entries of documents not in ids, such as documents
+// deleted since the index was saved. Their entries stay in the data file but
+// can no longer be reached through the index.
+func (w *BinaryCollectionWriter) retain(ids map[string]bool) {
+	for id := range w.index.Entries {
+		if !ids[id] {
+			delete(w.index.Entries, id)
+		}
+	}
+}
+
+// CompressionStats reports compression statistics for the documents written so far
+func (w *BinaryCollectionWriter) CompressionStats() CompressionStats {
+	return w.index.CompressionStats()
+}
+
+// Entry returns the index entry recorded for a document, e.g. by WriteDocument
+func (w *BinaryCollectionWriter) Entry(docID string) (*DocumentEntry, bool) {
+	return w.index.Entry(docID)
+}
+
 // Flush syncs the data file and saves the index
 func (w *BinaryCollectionWriter) Flush(dataDir, dbName, collName string) error {
 	if err := w.dataFile.Sync(); err != nil {
 		return fmt.Errorf("failed to sync data file: %w", err)
 	}
 
-	if err := SaveOffsetIndex(w.index, dataDir, dbName, collName); err != nil {
+	if err := SaveOffsetIndex(w.index, dataDir, dbName, collName, w.perms); err != nil {
 		return fmt.Errorf("failed to save index: %w", err)
 	}
 
@@ -193,6 +429,48 @@ func (w *BinaryCollectionWriter) Close(dataDir, dbName, collName string) error {
 type BinaryCollectionReader struct {
 	dataFile *os.File
 	index    *OffsetIndex
+	version  uint16            // Format version of the data file
+	checksum ChecksumAlgorithm // Checksum algorithm of the data file
+	dbName   string
+	collName string
+
+	recovery    bool // look for another valid copy of corrupt documents
+	recovered   []RecoveredDocument
+	recoveredMu sync.Mutex
+}
+
+// ChecksumError reports a document entry that failed its checksum. It wraps
+// ErrChecksumMismatch.
+type ChecksumError struct {
+	DocumentID string
+	Offset     int64  // offset of the entry in the data file
+	Expected   string // checksum recorded for the entry, in hex
+	Actual     string // checksum of the entry's data, in hex
+	Index      bool   // the entry is intact but doesn't match the offset index
+}
+
+func (e *ChecksumError) Error() string {
+	source := "entry header"
+	if e.Index {
+		source = "offset index"
+	}
+	return fmt.Sprintf("checksum mismatch for document %s at offset %d: %s expects %s, data has %s",
+		e.DocumentID, e.Offset, source, e.Expected, e.Actual)
+}
+
+func (e *ChecksumError) Unwrap() error {
+	return ErrChecksumMismatch
+}
+
+// RecoveredDocument records a corrupt document entry that was replaced by
+// another valid copy of the document found in the data file. That copy is
+// the newest valid one, but may be older than the corrupt entry.
+type RecoveredDocument struct {
+	Database      string `json:"database"`
+	Collection    string `json:"collection"`
+	DocumentID    string `json:"document_id"`
+	CorruptOffset int64  `json:"corrupt_offset"`
+	Offset        int64  `json:"offset"` // offset of the copy used instead
 }
 
 // NewBinaryCollectionReader creates a new binary collection reader
@@ -221,16 +499,42 @@ func NewBinaryCollectionReader(dataDir, dbName, collName string) (*BinaryCollect
 		return nil, fmt.Errorf("invalid magic number: expected 0x%X, got 0x%X", CollectionMagic, header.Magic)
 	}
 
-	// Load index
-	index, err := LoadOffsetIndex(dataDir, dbName, collName)
+	if err := validateBinaryVersion(header.Version); err != nil {
+		dataFile.Close()
+		return nil, err
+	}
+
+	checksum, err := checksumFromHeader(header)
 	if err != nil {
 		dataFile.Close()
-		return nil, fmt.Errorf("failed to load index: %w", err)
+		return nil, err
+	}
+
+	// A compaction that replaced the data file but crashed before moving its
+	// index left the index behind; this may be read-only, so read it there
+	indexColl := collName
+	if compactionCommitted(filepath.Join(dataDir, dbName, collName)) {
+		indexColl = filepath.Join(collName, compactDirName)
+	}
+
+	// Load index, rebuilding it from the data file if it is missing or unreadable
+	indexPath := filepath.Join(dataDir, dbName, indexColl, "collection.idx")
+	index, err := LoadOffsetIndex(dataDir, dbName, indexColl)
+	if _, statErr := os.Stat(indexPath); err != nil || os.IsNotExist(statErr) {
+		index, err = scanOffsetIndex(dataDir, dbName, collName)
+		if err != nil {
+			dataFile.Close()
+			return nil, fmt.Errorf("failed to rebuild index: %w", err)
+		}
 	}
 
 	return &BinaryCollectionReader{
 		dataFile: dataFile,
 		index:    index,
+		version:  header.Version,
+		checksum: checksum,
+		dbName:   dbName,
+		collName: collName,
 	}, nil
 }
 
@@ -250,24 +554,341 @@ func readHeader(f *os.File) (*BinaryHeader, error) {
 	return header, nil
 }
 
-// ReadDocument reads a document by ID from the binary file
+// formatChecksum formats a checksum as hex: CRC32 sums as their uint32 value,
+// longer sums byte by byte
+func formatChecksum(sum []byte) string {
+	if len(sum) == crc32.Size {
+		return fmt.Sprintf("%08x", binary.LittleEndian.Uint32(sum))
+	}
+	return hex.EncodeToString(sum)
+}
+
+// dataFileChecksum returns the checksum algorithm of an existing data file,
+// or "" if there is none yet
+func dataFileChecksum(dataPath string) (ChecksumAlgorithm, error) {
+	f, err := os.Open(dataPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to open data file: %w", err)
+	}
+	defer f.Close()
+
+	header, err := readHeader(f)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to read header: %w", err)
+	}
+	return checksumFromHeader(header)
+}
+
+// entryChecksum computes the CRC32 checksum of an entry's ID and compressed data.
+// Version 1 entries have no embedded ID, so only the data is covered.
+func entryChecksum(idData, compressedData []byte) uint32 {
+	h := crc32.NewIEEE()
+	h.Write(idData)
+	h.Write(compressedData)
+	return h.Sum32()
+}
+
+// binaryFormatUpgrade rewrites a collection data file in place from one
+// format version to the next
+type binaryFormatUpgrade func(dataDir, dbName, collName string, perms FilePermissions) error
+
+// binaryFormatUpgrades maps from_version -> upgrade to reach from_version+1
+var binaryFormatUpgrades = map[uint16]binaryFormatUpgrade{}
+
+func init() {
+	binaryFormatUpgrades[1] = upgradeBinaryV1ToV2
+	binaryFormatUpgrades[2] = upgradeBinaryV2ToV3
+}
+
+// upgradeBinaryV1ToV2 rewrites a version 1 data file so every entry embeds
+// its document ID
+func upgradeBinaryV1ToV2(dataDir, dbName, collName string, perms FilePermissions) error {
+	return rewriteBinaryCollection(dataDir, dbName, collName, perms, ChecksumCRC32)
+}
+
+// upgradeBinaryV2ToV3 marks a version 2 data file as version 3. Version 3
+// records the checksum algorithm in the header flags; version 2 files always
+// use CRC32, whose code is 0, so only the version changes.
+func upgradeBinaryV2ToV3(dataDir, dbName, collName string, perms FilePermissions) error {
+	dataPath := filepath.Join(dataDir, dbName, collName, "collection.data")
+
+	f, err := os.OpenFile(dataPath, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open version 2 data file: %w", err)
+	}
+	defer f.Close()
+
+	version := binary.LittleEndian.AppendUint16(nil, 3)
+	if _, err := f.WriteAt(version, 4); err != nil {
+		return fmt.Errorf("failed to write header: %w", err)
+	}
+	return f.Sync()
+}
+
+// compactDirName is the directory, inside a collection's, that a data file
+// is rewritten into before it replaces the original
+const compactDirName = "compact.tmp"
+
+// rewriteBinaryCollection compacts a data file: it rewrites it with only its
+// current documents, using the given checksum algorithm. The new data file
+// and its index are written and synced in compactDirName first, then moved
+// over the originals. Moving the data file is the commit point: a crash
+// before it leaves the original untouched, and finishCompaction completes or
+// discards an interrupted compaction on the next load.
+func rewriteBinaryCollection(dataDir, dbName, collName string, perms FilePermissions, checksum ChecksumAlgorithm) error {
+	collDir := filepath.Join(dataDir, dbName, collName)
+	compactColl := filepath.Join(collName, compactDirName)
+
+	reader, err := NewBinaryCollectionReader(dataDir, dbName, collName)
+	if err != nil {
+		return fmt.Errorf("failed to open data file: %w", err)
+	}
+	docs, err := reader.ReadAllDocuments()
+	reader.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read documents: %w", err)
+	}
+
+	// The writer would append to what an earlier attempt left behind
+	if err := os.RemoveAll(filepath.Join(collDir, compactDirName)); err != nil {
+		return fmt.Errorf("failed to remove incomplete compaction: %w", err)
+	}
+
+	writer, err := newBinaryCollectionWriter(dataDir, dbName, compactColl, perms, checksum)
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		if err := writer.WriteDocument(doc); err != nil {
+			writer.dataFile.Close()
+			return err
+		}
+	}
+	if err := writer.Close(dataDir, dbName, compactColl); err != nil {
+		return err
+	}
+
+	if err := os.Rename(filepath.Join(collDir, compactDirName, "collection.data"), filepath.Join(collDir, "collection.data")); err != nil {
+		return fmt.Errorf("failed to replace data file: %w", err)
+	}
+	if err := syncDir(collDir); err != nil {
+		return fmt.Errorf("failed to replace data file: %w", err)
+	}
+	return finishCompaction(collDir)
+}
+
+// finishCompaction settles a compaction of the data file in collDir that was
+// interrupted by a crash. If the data file wasn't replaced yet, what was
+// written is discarded and the original stays; if it was, its index is moved
+// in place too. Data files moved aside as backups by earlier versions, which
+// rewrote them in place, are restored.
+func finishCompaction(collDir string) error {
+	compactDir := filepath.Join(collDir, compactDirName)
+	if _, err := os.Stat(compactDir); err == nil {
+		if compactionCommitted(collDir) {
+			err := os.Rename(filepath.Join(compactDir, "collection.idx"), filepath.Join(collDir, "collection.idx"))
+			if err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to replace index file: %w", err)
+			}
+		}
+		if err := os.RemoveAll(compactDir); err != nil {
+			return fmt.Errorf("failed to remove incomplete compaction: %w", err)
+		}
+	}
+
+	dataPath := filepath.Join(collDir, "collection.data")
+	for _, suffix := range []string{".rewrite.bak", ".v1.bak"} {
+		if _, err := os.Stat(dataPath + suffix); err != nil {
+			continue
+		}
+		if err := os.Rename(dataPath+suffix, dataPath); err != nil {
+			return fmt.Errorf("failed to restore data file: %w", err)
+		}
+		// The index belonged to the interrupted rewrite; it is rebuilt from the data file
+		if err := os.Remove(filepath.Join(collDir, "collection.idx")); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove index file: %w", err)
+		}
+	}
+	return nil
+}
+
+// compactionCommitted reports whether a compaction in collDir replaced the
+// data file but was interrupted before its index was moved in place
+func compactionCommitted(collDir string) bool {
+	compactDir := filepath.Join(collDir, compactDirName)
+	if _, err := os.Stat(filepath.Join(compactDir, "collection.data")); !os.IsNotExist(err) {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(compactDir, "collection.idx"))
+	return err == nil
+}
+
+// validateBinaryVersion checks that a data file version can be handled by this build
+func validateBinaryVersion(version uint16) error {
+	if version == 0 || version > BinaryFormatVersion {
+		return fmt.Errorf("unsupported binary format version %d (this build supports up to %d)", version, BinaryFormatVersion)
+	}
+	return nil
+}
+
+// ensureCurrentBinaryFormat validates an existing data file and upgrades it
+// in place when it was written with an older format version
+func ensureCurrentBinaryFormat(dataDir, dbName, collName string, perms FilePermissions) error {
+	dataPath := filepath.Join(dataDir, dbName, collName, "collection.data")
+
+	f, err := os.Open(dataPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil // New file, header will be written at the current version
+		}
+		return fmt.Errorf("failed to open data file: %w", err)
+	}
+
+	header, err := readHeader(f)
+	f.Close()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil // Empty file, header will be written at the current version
+		}
+		return fmt.Errorf("failed to read header: %w", err)
+	}
+
+	if header.Magic != CollectionMagic {
+		return fmt.Errorf("invalid magic number: expected 0x%X, got 0x%X", CollectionMagic, header.Magic)
+	}
+
+	if err := validateBinaryVersion(header.Version); err != nil {
+		return err
+	}
+
+	for version := header.Version; version < BinaryFormatVersion; version++ {
+		upgrade, exists := binaryFormatUpgrades[version]
+		if !exists {
+			return fmt.Errorf("no upgrade found from binary format version %d to %d", version, version+1)
+		}
+
+		if err := upgrade(dataDir, dbName, collName, perms); err != nil {
+			return fmt.Errorf("binary format upgrade from version %d to %d failed: %w", version, version+1, err)
+		}
+	}
+
+	return nil
+}
+
+// SetRecovery sets whether ReadDocument recovers documents whose entry fails
+// its checksum, by scanning the data file for another valid copy. The data
+// file is append-only between rewrites, so older saves usually left one.
+// Recovered documents are reported by Recovered.
+func (r *BinaryCollectionReader) SetRecovery(enabled bool) {
+	r.recovery = enabled
+}
+
+// Recovered returns the documents ReadDocument recovered from another copy
+func (r *BinaryCollectionReader) Recovered() []RecoveredDocument {
+	r.recoveredMu.Lock()
+	defer r.recoveredMu.Unlock()
+	return append([]RecoveredDocument(nil), r.recovered...)
+}
+
+// ReadDocument reads a document by ID from the binary file. A checksum
+// failure is reported as a *ChecksumError, unless recovery is enabled and
+// another valid copy of the document is found.
 func (r *BinaryCollectionReader) ReadDocument(docID string) (*Document, error) {
 	entry, exists := r.index.Entries[docID]
 	if !exists {
-		return nil, fmt.Errorf("document not found: %s", docID)
+		return nil, fmt.Errorf("document '%s' %w", docID, ErrNotFound)
 	}
 
-	// Read entry header + data
-	buf := make([]byte, DocEntryHeaderSize+entry.CompressedSize)
+	doc, err := r.readEntry(docID, entry)
+	if err != nil && r.recovery && errors.Is(err, ErrChecksumMismatch) {
+		if recovered, recoverErr := r.recoverDocument(docID, entry); recoverErr == nil {
+			return recovered, nil
+		} else if !errors.Is(recoverErr, ErrNotFound) {
+			return nil, errors.Join(err, recoverErr)
+		}
+	}
+	return doc, err
+}
+
+// recoverDocument returns the newest valid copy of a document in the data
+// file, or an error wrapping ErrNotFound if there is none
+func (r *BinaryCollectionReader) recoverDocument(docID string, corrupt *DocumentEntry) (*Document, error) {
+	var copies []*DocumentEntry
+	err := scanEntries(r.dataFile, func(id string, entry *DocumentEntry) {
+		if id == docID {
+			copies = append(copies, entry)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan data file for document %s: %w", docID, err)
+	}
+
+	for i := len(copies) - 1; i >= 0; i-- {
+		doc, err := r.readEntry(docID, copies[i])
+		if err != nil {
+			continue
+		}
+
+		r.recoveredMu.Lock()
+		r.recovered = append(r.recovered, RecoveredDocument{
+			Database:      r.dbName,
+			Collection:    r.collName,
+			DocumentID:    docID,
+			CorruptOffset: corrupt.Offset,
+			Offset:        copies[i].Offset,
+		})
+		r.recoveredMu.Unlock()
+		return doc, nil
+	}
+
+	return nil, fmt.Errorf("valid copy of document %s %w", docID, ErrNotFound)
+}
+
+// readEntry reads and verifies a document entry
+func (r *BinaryCollectionReader) readEntry(docID string, entry *DocumentEntry) (*Document, error) {
+	// Version 1 entries don't embed the document ID
+	headerSize := entryHeaderSize(r.version, r.checksum)
+	idLen := int64(len(docID))
+	if r.version == 1 {
+		idLen = 0
+	}
+
+	// Read entry header + ID + data
+	buf := make([]byte, headerSize+idLen+int64(entry.CompressedSize))
 	if _, err := r.dataFile.ReadAt(buf, entry.Offset); err != nil {
 		return nil, fmt.Errorf("failed to read document data: %w", err)
 	}
 
-	// Verify checksum
-	compressedData := buf[DocEntryHeaderSize:]
-	checksum := crc32.ChecksumIEEE(compressedData)
-	if checksum != entry.Checksum {
-		return nil, fmt.Errorf("checksum mismatch for document %s", docID)
+	idData := buf[headerSize : headerSize+idLen]
+	if r.version > 1 && string(idData) != docID {
+		return nil, fmt.Errorf("entry at offset %d does not belong to document %s", entry.Offset, docID)
+	}
+
+	// Verify checksum against the entry header and the offset index
+	compressedData := buf[headerSize+idLen:]
+	checksum := r.checksum.sum(idData, compressedData)
+	if stored := buf[16 : 16+len(checksum)]; !bytes.Equal(checksum, stored) {
+		return nil, &ChecksumError{
+			DocumentID: docID,
+			Offset:     entry.Offset,
+			Expected:   formatChecksum(stored),
+			Actual:     formatChecksum(checksum),
+		}
+	}
+	if prefix := binary.LittleEndian.Uint32(checksum); prefix != entry.Checksum {
+		return nil, &ChecksumError{
+			DocumentID: docID,
+			Offset:     entry.Offset,
+			Expected:   fmt.Sprintf("%08x", entry.Checksum),
+			Actual:     fmt.Sprintf("%08x", prefix),
+			Index:      true,
+		}
 	}
 
 	// Decompress
@@ -300,24 +921,152 @@ func (r *BinaryCollectionReader) ReadAllDocuments() ([]*Document, error) {
 	return documents, nil
 }
 
+// CompressionStats reports compression statistics for the documents in the file
+func (r *BinaryCollectionReader) CompressionStats() CompressionStats {
+	return r.index.CompressionStats()
+}
+
+// Entry returns the index entry of a document, to inspect how it is stored
+// without reading it
+func (r *BinaryCollectionReader) Entry(docID string) (*DocumentEntry, bool) {
+	return r.index.Entry(docID)
+}
+
 // Close closes the reader
 func (r *BinaryCollectionReader) Close() error {
 	return r.dataFile.Close()
 }
 
-// SaveOffsetIndex saves the offset index to disk
-func SaveOffsetIndex(index *OffsetIndex, dataDir, dbName, collName string) error {
-	indexPath := filepath.Join(dataDir, dbName, collName, "collection.idx")
+// RebuildOffsetIndex regenerates collection.idx by scanning the data file
+// sequentially. Entries with a bad checksum are skipped; when a document was
+// written more than once, the last entry wins. Documents deleted since the
+// data file was last rewritten may reappear.
+func RebuildOffsetIndex(dataDir, dbName, collName string, perms FilePermissions) (*OffsetIndex, error) {
+	index, err := scanOffsetIndex(dataDir, dbName, collName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := SaveOffsetIndex(index, dataDir, dbName, collName, perms); err != nil {
+		return nil, fmt.Errorf("failed to save rebuilt index: %w", err)
+	}
 
-	f, err := os.Create(indexPath)
+	return index, nil
+}
+
+// scanOffsetIndex builds an offset index in memory by scanning the data file
+func scanOffsetIndex(dataDir, dbName, collName string) (*OffsetIndex, error) {
+	dataPath := filepath.Join(dataDir, dbName, collName, "collection.data")
+
+	f, err := os.Open(dataPath)
 	if err != nil {
-		return fmt.Errorf("failed to create index file: %w", err)
+		return nil, fmt.Errorf("failed to open data file: %w", err)
 	}
 	defer f.Close()
 
+	index := &OffsetIndex{
+		Entries: make(map[string]*DocumentEntry),
+	}
+	err = scanEntries(f, func(docID string, entry *DocumentEntry) {
+		index.Entries[docID] = entry
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return index, nil
+}
+
+// scanEntries walks the entries of a data file in order, calling visit for
+// each entry whose checksum is valid. Entries are self-describing, so no
+// offset index is needed. A truncated trailing entry ends the scan.
+func scanEntries(f *os.File, visit func(docID string, entry *DocumentEntry)) error {
+	header, err := readHeader(f)
+	if err != nil {
+		return fmt.Errorf("failed to read header: %w", err)
+	}
+
+	if header.Magic != CollectionMagic {
+		return fmt.Errorf("invalid magic number: expected 0x%X, got 0x%X", CollectionMagic, header.Magic)
+	}
+
+	if err := validateBinaryVersion(header.Version); err != nil {
+		return err
+	}
+
+	checksum, err := checksumFromHeader(header)
+	if err != nil {
+		return err
+	}
+	sumSize := checksum.Size()
+
+	// Version 1 entries don't embed the document ID
+	headerSize := entryHeaderSize(header.Version, checksum)
+
+	offset := int64(HeaderSize)
+	entryBuf := make([]byte, headerSize)
+	for {
+		if _, err := f.ReadAt(entryBuf, offset); err != nil {
+			if err == io.EOF {
+				break // End of file or truncated trailing entry header
+			}
+			return fmt.Errorf("failed to read entry header at offset %d: %w", offset, err)
+		}
+
+		entry := &DocumentEntry{
+			Offset:         int64(binary.LittleEndian.Uint64(entryBuf[0:8])),
+			Size:           binary.LittleEndian.Uint32(entryBuf[8:12]),
+			CompressedSize: binary.LittleEndian.Uint32(entryBuf[12:16]),
+			Checksum:       binary.LittleEndian.Uint32(entryBuf[16:20]),
+		}
+
+		// Every entry records its own position; a mismatch means framing is lost
+		if entry.Offset != offset {
+			return fmt.Errorf("corrupt entry header at offset %d", offset)
+		}
+
+		var idLen int64
+		if header.Version > 1 {
+			idLen = int64(binary.LittleEndian.Uint16(entryBuf[16+sumSize:]))
+		}
+
+		payload := make([]byte, idLen+int64(entry.CompressedSize))
+		if _, err := f.ReadAt(payload, offset+headerSize); err != nil {
+			if err == io.EOF {
+				break // Truncated trailing entry
+			}
+			return fmt.Errorf("failed to read entry data at offset %d: %w", offset, err)
+		}
+
+		idData, compressedData := payload[:idLen], payload[idLen:]
+		if bytes.Equal(checksum.sum(idData, compressedData), entryBuf[16:16+sumSize]) {
+			if header.Version > 1 {
+				visit(string(idData), entry)
+			} else if jsonData, err := Decompress(compressedData); err == nil {
+				// Version 1 entries only carry the ID inside the document itself
+				var doc Document
+				if err := doc.UnmarshalJSON(jsonData); err == nil && doc.ID != "" {
+					visit(doc.ID, entry)
+				}
+			}
+		}
+
+		offset += headerSize + int64(len(payload))
+	}
+
+	return nil
+}
+
+// SaveOffsetIndex saves the offset index to disk
+func SaveOffsetIndex(index *OffsetIndex, dataDir, dbName, collName string, perms FilePermissions) error {
+	indexPath := filepath.Join(dataDir, dbName, collName, "collection.idx")
+
+	// Serialize entries first so the header can carry their checksum
+	var body bytes.Buffer
+
 	// Write number of entries
 	numEntries := uint32(len(index.Entries))
-	if err := binary.Write(f, binary.LittleEndian, numEntries); err != nil {
+	if err := binary.Write(&body, binary.LittleEndian, numEntries); err != nil {
 		return fmt.Errorf("failed to write entry count: %w", err)
 	}
 
@@ -325,53 +1074,98 @@ func SaveOffsetIndex(index *OffsetIndex, dataDir, dbName, collName string) error
 	for docID, entry := range index.Entries {
 		// Write document ID length + ID
 		idLen := uint32(len(docID))
-		if err := binary.Write(f, binary.LittleEndian, idLen); err != nil {
+		if err := binary.Write(&body, binary.LittleEndian, idLen); err != nil {
 			return err
 		}
-		if _, err := f.Write([]byte(docID)); err != nil {
+		if _, err := body.Write([]byte(docID)); err != nil {
 			return err
 		}
 
 		// Write entry data
-		if err := binary.Write(f, binary.LittleEndian, entry.Offset); err != nil {
+		if err := binary.Write(&body, binary.LittleEndian, entry.Offset); err != nil {
 			return err
 		}
-		if err := binary.Write(f, binary.LittleEndian, entry.Size); err != nil {
+		if err := binary.Write(&body, binary.LittleEndian, entry.Size); err != nil {
 			return err
 		}
-		if err := binary.Write(f, binary.LittleEndian, entry.CompressedSize); err != nil {
+		if err := binary.Write(&body, binary.LittleEndian, entry.CompressedSize); err != nil {
 			return err
 		}
-		if err := binary.Write(f, binary.LittleEndian, entry.Checksum); err != nil {
+		if err := binary.Write(&body, binary.LittleEndian, entry.Checksum); err != nil {
 			return err
 		}
 	}
 
-	return nil
+	// Header: magic(4) + version(2) + flags(2) + checksum(4)
+	header := make([]byte, OffsetIndexHeaderSize)
+	binary.LittleEndian.PutUint32(header[0:4], OffsetIndexMagic)
+	binary.LittleEndian.PutUint16(header[4:6], OffsetIndexVersion)
+	binary.LittleEndian.PutUint16(header[6:8], 0)
+	binary.LittleEndian.PutUint32(header[8:12], crc32.ChecksumIEEE(body.Bytes()))
+
+	// The data file can't be read without its index, so the file is
+	// replaced atomically and a failed save leaves the previous one
+	return writeFileAtomic(indexPath, perms.FileMode, func(w io.Writer) error {
+		if _, err := w.Write(header); err != nil {
+			return fmt.Errorf("failed to write index header: %w", err)
+		}
+		if _, err := w.Write(body.Bytes()); err != nil {
+			return fmt.Errorf("failed to write index entries: %w", err)
+		}
+		return nil
+	})
 }
 
 // LoadOffsetIndex loads the offset index from disk
 func LoadOffsetIndex(dataDir, dbName, collName string) (*OffsetIndex, error) {
 	indexPath := filepath.Join(dataDir, dbName, collName, "collection.idx")
 
-	f, err := os.Open(indexPath)
+	data, err := os.ReadFile(indexPath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return &OffsetIndex{Entries: make(map[string]*DocumentEntry)}, nil
 		}
 		return nil, fmt.Errorf("failed to open index file: %w", err)
 	}
-	defer f.Close()
 
+	body := data
+	if len(data) >= 4 && binary.LittleEndian.Uint32(data[0:4]) == OffsetIndexMagic {
+		if len(data) < OffsetIndexHeaderSize {
+			return nil, fmt.Errorf("index file header is truncated")
+		}
+
+		version := binary.LittleEndian.Uint16(data[4:6])
+		if version == 0 || version > OffsetIndexVersion {
+			return nil, fmt.Errorf("unsupported index file version %d (this build supports up to %d)", version, OffsetIndexVersion)
+		}
+
+		body = data[OffsetIndexHeaderSize:]
+		checksum := binary.LittleEndian.Uint32(data[8:12])
+		if crc32.ChecksumIEEE(body) != checksum {
+			return nil, fmt.Errorf("index file checksum mismatch")
+		}
+	}
+	// Files without the magic number are legacy headerless indexes
+
+	return readOffsetIndexEntries(bytes.NewReader(body))
+}
+
+// readOffsetIndexEntries parses the entry count and entries of an offset index
+func readOffsetIndexEntries(r *bytes.Reader) (*OffsetIndex, error) {
 	// Read number of entries
 	var numEntries uint32
-	if err := binary.Read(f, binary.LittleEndian, &numEntries); err != nil {
+	if err := binary.Read(r, binary.LittleEndian, &numEntries); err != nil {
 		if err == io.EOF {
 			return &OffsetIndex{Entries: make(map[string]*DocumentEntry)}, nil
 		}
 		return nil, fmt.Errorf("failed to read entry count: %w", err)
 	}
 
+	// Each entry takes at least idLen(4) + offset(8) + size(4) + compressed_size(4) + checksum(4) bytes
+	if int64(numEntries)*24 > int64(r.Len()) {
+		return nil, fmt.Errorf("index entry count %d exceeds file size", numEntries)
+	}
+
 	index := &OffsetIndex{
 		Entries: make(map[string]*DocumentEntry, numEntries),
 	}
@@ -380,28 +1174,31 @@ func LoadOffsetIndex(dataDir, dbName, collName string) (*OffsetIndex, error) {
 	for i := uint32(0); i < numEntries; i++ {
 		// Read document ID
 		var idLen uint32
-		if err := binary.Read(f, binary.LittleEndian, &idLen); err != nil {
+		if err := binary.Read(r, binary.LittleEndian, &idLen); err != nil {
 			return nil, err
 		}
+		if int64(idLen) > int64(r.Len()) {
+			return nil, fmt.Errorf("index entry ID length %d exceeds file size", idLen)
+		}
 
 		idBuf := make([]byte, idLen)
-		if _, err := io.ReadFull(f, idBuf); err != nil {
+		if _, err := io.ReadFull(r, idBuf); err != nil {
 			return nil, err
 		}
 		docID := string(idBuf)
 
 		// Read entry data
 		entry := &DocumentEntry{}
-		if err := binary.Read(f, binary.LittleEndian, &entry.Offset); err != nil {
+		if err := binary.Read(r, binary.LittleEndian, &entry.Offset); err != nil {
 			return nil, err
 		}
-		if err := binary.Read(f, binary.LittleEndian, &entry.Size); err != nil {
+		if err := binary.Read(r, binary.LittleEndian, &entry.Size); err != nil {
 			return nil, err
 		}
-		if err := binary.Read(f, binary.LittleEndian, &entry.CompressedSize); err != nil {
+		if err := binary.Read(r, binary.LittleEndian, &entry.CompressedSize); err != nil {
 			return nil, err
 		}
-		if err := binary.Read(f, binary.LittleEndian, &entry.Checksum); err != nil {
+		if err := binary.Read(r, binary.LittleEndian, &entry.Checksum); err != nil {
 			return nil, err
 		}
 
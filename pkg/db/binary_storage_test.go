@@ -0,0 +1,117 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeCompactDir writes docs to the compaction directory of collection
+// items, as an interrupted compaction leaves it before its commit point
+func writeCompactDir(t *testing.T, dir string, docs ...*Document) {
+	t.Helper()
+	compactColl := filepath.Join("items", compactDirName)
+	writer, err := newBinaryCollectionWriter(dir, "app", compactColl, DefaultFilePermissions, ChecksumCRC32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, doc := range docs {
+		if err := writer.WriteDocument(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(dir, "app", compactColl); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// savedItems creates collection items with documents a and b, saving a
+// few versions of a so its data file holds stale copies
+func savedItems(t *testing.T, dir string) {
+	t.Helper()
+	d := openTestDB(t, dir)
+	if _, err := d.CreateCollection("items", nil); err != nil {
+		t.Fatal(err)
+	}
+	mustInsert(t, d, "items", "a", map[string]any{"n": 0})
+	mustInsert(t, d, "items", "b", map[string]any{"n": 10})
+	for i := 1; i <= 3; i++ {
+		if err := d.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Update("items", "a", map[string]any{"n": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// assertItems checks that collection items holds a with n 3 and b with n 10
+func assertItems(t *testing.T, d *DB) {
+	t.Helper()
+	coll, err := d.Collection("items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := coll.Count(); n != 2 {
+		t.Errorf("Count = %d, want 2", n)
+	}
+	for id, want := range map[string]int64{"a": 3, "b": 10} {
+		doc, err := coll.FindByID(id)
+		if err != nil {
+			t.Fatalf("FindByID(%s): %v", id, err)
+		}
+		if doc.Data["n"] != want {
+			t.Errorf("%s.n = %v, want %d", id, doc.Data["n"], want)
+		}
+	}
+}
+
+func TestInterruptedCompactionKeepsOriginal(t *testing.T) {
+	dir := t.TempDir()
+	savedItems(t, dir)
+
+	// Crash while writing the compacted file: it only holds part of the documents
+	writeCompactDir(t, dir, &Document{ID: "a", Data: map[string]any{"n": 3}})
+
+	d := openTestDB(t, dir)
+	defer d.Close()
+	assertItems(t, d)
+
+	if _, err := os.Stat(filepath.Join(dir, "app", "items", compactDirName)); !os.IsNotExist(err) {
+		t.Errorf("incomplete compaction not discarded: %v", err)
+	}
+}
+
+func TestCommittedCompactionIsCompleted(t *testing.T) {
+	dir := t.TempDir()
+	savedItems(t, dir)
+
+	// Crash after the commit point: the compacted data file replaced the
+	// original, but its index is still in the compaction directory. The
+	// original index points at offsets of the old file.
+	writeCompactDir(t, dir,
+		&Document{ID: "b", Data: map[string]any{"n": 10}},
+		&Document{ID: "a", Data: map[string]any{"n": 3}})
+	collDir := filepath.Join(dir, "app", "items")
+	if err := os.Rename(filepath.Join(collDir, compactDirName, "collection.data"), filepath.Join(collDir, "collection.data")); err != nil {
+		t.Fatal(err)
+	}
+
+	d := openTestDB(t, dir)
+	defer d.Close()
+	assertItems(t, d)
+
+	if _, err := os.Stat(filepath.Join(collDir, compactDirName)); !os.IsNotExist(err) {
+		t.Errorf("committed compaction not completed: %v", err)
+	}
+	index, err := LoadOffsetIndex(dir, "app", "items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index.Entries["b"].Offset != HeaderSize {
+		t.Errorf("index of the compacted file not moved in place: b at offset %d", index.Entries["b"].Offset)
+	}
+}
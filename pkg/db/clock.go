@@ -0,0 +1,65 @@
+package db
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time recorded by the database: WAL entry and checkpoint
+// times, which point-in-time recovery compares against, dirty marks and dump
+// creation times. Timers and measured durations keep using the system clock.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the default Clock, reading the system time
+var SystemClock Clock = systemClock{}
+
+// systemClock reads the system time
+type systemClock struct{}
+
+// Now returns the current system time
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// WithClock sets the clock the storage manager and its WAL read the time
+// from, e.g. a FakeClock in tests. The default is SystemClock.
+func WithClock(clock Clock) StorageOption {
+	return func(sm *StorageManager) {
+		sm.clock = clock
+	}
+}
+
+// FakeClock is a Clock that only moves when told to, so tests of
+// time-dependent behavior are deterministic. It is safe for concurrent use.
+type FakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewFakeClock returns a fake clock reading now
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns the fake clock's time
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the fake clock forward by d
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Set moves the fake clock to t
+func (c *FakeClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+}
@@ -0,0 +1,100 @@
+package db
+
+import (
+	"cmp"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Collation decides how strings are ordered by gt/gte/lt/lte filters, sort,
+// Range and the $sort, min and max of aggregations. Equality (eq, ne, in and
+// index lookups) stays exact whatever the collation.
+type Collation string
+
+// Collations
+const (
+	CollationBinary          Collation = ""                 // byte-wise, the default
+	CollationCaseInsensitive Collation = "case_insensitive" // ignores Unicode case, then byte-wise to break ties
+)
+
+// Validate checks that the collation is known
+func (c Collation) Validate() error {
+	switch c {
+	case CollationBinary, CollationCaseInsensitive:
+		return nil
+	}
+	return fmt.Errorf("unknown collation '%s'", c)
+}
+
+// compareValues compares two values: numerically when both are numbers,
+// otherwise by their string representation under the collation. Only equal
+// strings compare as 0, so orderings are total and agree with eq.
+func (c Collation) compareValues(a, b any) int {
+	if af, ok := toFloat(a); ok {
+		if bf, ok := toFloat(b); ok {
+			return cmp.Compare(af, bf)
+		}
+	}
+	aStr := fmt.Sprintf("%v", a)
+	bStr := fmt.Sprintf("%v", b)
+	if c == CollationCaseInsensitive {
+		if order := compareFolded(aStr, bStr); order != 0 {
+			return order
+		}
+	}
+	return strings.Compare(aStr, bStr)
+}
+
+// compareSortValues compares two values for sorting, ordering nil first
+func (c Collation) compareSortValues(a, b any) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	return c.compareValues(a, b)
+}
+
+// compareFolded compares two strings rune by rune, ignoring case
+func compareFolded(a, b string) int {
+	for a != "" && b != "" {
+		ra, na := utf8.DecodeRuneInString(a)
+		rb, nb := utf8.DecodeRuneInString(b)
+		if order := cmp.Compare(unicode.ToLower(ra), unicode.ToLower(rb)); order != 0 {
+			return order
+		}
+		a, b = a[na:], b[nb:]
+	}
+	return cmp.Compare(len(a), len(b))
+}
+
+// SetCollation sets how the collection orders strings when sorting and in
+// range filters. Indexes are unaffected, so it can change at any time.
+func (c *Collection) SetCollation(collation Collation) error {
+	if err := collation.Validate(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.readOnly {
+		return ErrReadOnly
+	}
+
+	c.Collation = collation
+	c.modCount++
+	return nil
+}
+
+// GetCollation returns the collection's collation
+func (c *Collection) GetCollation() Collation {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.Collation
+}
@@ -8,8 +8,18 @@ import (
 
 // Compress compresses data using gzip
 func Compress(data []byte) ([]byte, error) {
+	return CompressLevel(data, gzip.DefaultCompression)
+}
+
+// CompressLevel compresses data using gzip at the given level, from
+// gzip.HuffmanOnly (-2) to gzip.BestCompression (9). gzip.NoCompression (0)
+// stores the data as is, still in gzip framing.
+func CompressLevel(data []byte, level int) ([]byte, error) {
 	var buf bytes.Buffer
-	writer := gzip.NewWriter(&buf)
+	writer, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
 
 	if _, err := writer.Write(data); err != nil {
 		writer.Close()
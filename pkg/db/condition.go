@@ -0,0 +1,89 @@
+package db
+
+import "fmt"
+
+// maxConditionDepth bounds how deeply conditions nest
+const maxConditionDepth = 32
+
+// Condition combines filters with boolean logic. Exactly one of Filter, And,
+// Or and Not is set: And matches when all of its conditions match, Or when
+// any does, and Not when its condition doesn't.
+type Condition struct {
+	Filter *QueryFilter `json:"filter,omitempty"`
+	And    []*Condition `json:"and,omitempty"`
+	Or     []*Condition `json:"or,omitempty"`
+	Not    *Condition   `json:"not,omitempty"`
+}
+
+// Validate checks that each condition sets exactly one operand and that its
+// filters are valid. Errors wrap ErrInvalidQuery.
+func (c *Condition) Validate() error {
+	return c.validate(0)
+}
+
+// validate is Validate for a condition nested depth levels deep
+func (c *Condition) validate(depth int) error {
+	if c == nil {
+		return fmt.Errorf("%w: condition must not be null", ErrInvalidQuery)
+	}
+	if depth > maxConditionDepth {
+		return fmt.Errorf("%w: conditions nest more than %d levels deep", ErrInvalidQuery, maxConditionDepth)
+	}
+
+	set := 0
+	for _, isSet := range []bool{c.Filter != nil, c.And != nil, c.Or != nil, c.Not != nil} {
+		if isSet {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("%w: a condition must set exactly one of filter, and, or and not", ErrInvalidQuery)
+	}
+
+	switch {
+	case c.Filter != nil:
+		return validateFilters([]QueryFilter{*c.Filter})
+	case c.Not != nil:
+		return c.Not.validate(depth + 1)
+	}
+
+	conditions, op := c.And, "and"
+	if c.Or != nil {
+		conditions, op = c.Or, "or"
+	}
+	if len(conditions) == 0 {
+		return fmt.Errorf("%w: %s must have at least one condition", ErrInvalidQuery, op)
+	}
+	for _, cond := range conditions {
+		if err := cond.validate(depth + 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matches checks if a document matches the condition; a nil condition
+// matches every document
+func (c *Condition) matches(doc *Document, collation Collation) bool {
+	switch {
+	case c == nil:
+		return true
+	case c.Filter != nil:
+		return matchesFilter(doc, *c.Filter, collation)
+	case c.Not != nil:
+		return !c.Not.matches(doc, collation)
+	case c.Or != nil:
+		for _, cond := range c.Or {
+			if cond.matches(doc, collation) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, cond := range c.And {
+		if !cond.matches(doc, collation) {
+			return false
+		}
+	}
+	return true
+}
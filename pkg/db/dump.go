@@ -0,0 +1,318 @@
+package db
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// dumpFormat identifies a database dump
+	dumpFormat = "cachydb-dump"
+
+	// dumpFormatVersion is the version of the dump format written
+	dumpFormatVersion = 1
+)
+
+// ErrInvalidDump reports a dump that can't be loaded: not a dump, written by
+// a newer version, malformed or cut short
+var ErrInvalidDump = errors.New("invalid dump")
+
+// dumpHeader is the first line of a dump
+type dumpHeader struct {
+	Format        string    `json:"format"`
+	Version       int       `json:"version"`
+	Database      string    `json:"database"`
+	SchemaVersion int       `json:"schema_version"`
+	Created       time.Time `json:"created"`
+}
+
+// dumpEnd is the last line of a dump; a dump without it was cut short
+type dumpEnd struct {
+	Collections int `json:"collections"`
+	Documents   int `json:"documents"`
+}
+
+// dumpLine is one line of a dump, with exactly one field set
+type dumpLine struct {
+	Header     *dumpHeader     `json:"header,omitempty"`
+	Collection *collectionMeta `json:"collection,omitempty"`
+	Document   *Document       `json:"document,omitempty"`
+	End        *dumpEnd        `json:"end,omitempty"`
+}
+
+// DumpDatabase writes the database dbName to w as a single self-contained
+// dump: its collections with their schemas, index definitions, settings and
+// documents. When databases were loaded with LoadAllDatabases, the loaded
+// database is dumped, including changes not saved yet; otherwise it is
+// loaded from disk.
+//
+// The dump is newline-delimited JSON: a header line with the format version,
+// then for each collection a line with its metadata followed by one line per
+// document, and a final end line. Encrypted fields stay encrypted, so loading
+// the dump needs the same encryption key. Indexes are rebuilt on load.
+func (sm *StorageManager) DumpDatabase(dbName string, w io.Writer) error {
+	var db *Database
+	if sm.dbManager != nil {
+		db = sm.dbManager.GetDatabase(dbName)
+	}
+	if db == nil {
+		var err error
+		db, err = sm.LoadDatabase(dbName)
+		if err != nil {
+			return err
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw) // Encode ends each value with a newline
+	enc.SetEscapeHTML(false)
+
+	header := &dumpHeader{
+		Format:        dumpFormat,
+		Version:       dumpFormatVersion,
+		Database:      db.Name,
+		SchemaVersion: db.SchemaVersion,
+		Created:       sm.clock.Now().UTC(),
+	}
+	if err := enc.Encode(dumpLine{Header: header}); err != nil {
+		return fmt.Errorf("failed to write dump: %w", err)
+	}
+
+	names := db.ListCollections()
+	sort.Strings(names)
+
+	end := &dumpEnd{}
+	for _, name := range names {
+		coll, err := db.GetCollection(name)
+		if err != nil {
+			return err
+		}
+		meta, docs, err := coll.dumpSnapshot()
+		if err != nil {
+			return fmt.Errorf("failed to read collection '%s': %w", name, err)
+		}
+		if err := enc.Encode(dumpLine{Collection: meta}); err != nil {
+			return fmt.Errorf("failed to write dump: %w", err)
+		}
+
+		encrypted := meta.Schema.encryptedFields()
+		for _, doc := range docs {
+			doc, err := sm.storedDocument(doc, encrypted)
+			if err != nil {
+				return err
+			}
+			if err := enc.Encode(dumpLine{Document: doc}); err != nil {
+				return fmt.Errorf("failed to write dump: %w", err)
+			}
+		}
+
+		end.Collections++
+		end.Documents += len(docs)
+	}
+
+	if err := enc.Encode(dumpLine{End: end}); err != nil {
+		return fmt.Errorf("failed to write dump: %w", err)
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to write dump: %w", err)
+	}
+	return nil
+}
+
+// dumpSnapshot returns the collection's metadata and its documents ordered
+// by ID, or in insertion order if the collection tracks it. The documents are
+// shared, not cloned (see snapshot).
+func (c *Collection) dumpSnapshot() (*collectionMeta, []*Document, error) {
+	if err := c.rlock(); err != nil {
+		return nil, nil, err
+	}
+	defer c.mu.RUnlock()
+
+	meta := &collectionMeta{
+		Name:           c.Name,
+		Schema:         c.Schema,
+		Indexes:        make(map[string]string, len(c.Indexes)),
+		Format:         c.Format,
+		IDKey:          c.IDKey,
+		Shards:         c.Shards,
+		Collation:      c.Collation,
+		InsertionOrder: c.order != nil,
+	}
+	if c.Limits != (QueryLimits{}) {
+		limits := c.Limits
+		meta.Limits = &limits
+	}
+	for name, idx := range c.Indexes {
+		meta.Indexes[name] = idx.FieldName
+	}
+
+	docs := make([]*Document, 0, len(c.Documents))
+	for _, doc := range c.Documents {
+		docs = append(docs, doc)
+	}
+	sort.Slice(docs, func(i, j int) bool { return docs[i].ID < docs[j].ID })
+	c.sortByInsertionLocked(docs)
+
+	return meta, docs, nil
+}
+
+// LoadDump recreates the database written by DumpDatabase from r and saves
+// it. The database must not exist yet, on disk or loaded; it fails with
+// ErrAlreadyExists otherwise. When databases were loaded with
+// LoadAllDatabases, the new database is added to them. Documents are not
+// validated against the schemas again, since they were stored when dumped.
+// The dump is read whole before anything is saved, so a dump that turns out
+// to be invalid or cut short (ErrInvalidDump) leaves nothing behind.
+func (sm *StorageManager) LoadDump(r io.Reader) (*Database, error) {
+	if sm.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	db, err := sm.readDump(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if sm.dbManager != nil && sm.dbManager.GetDatabase(db.Name) != nil {
+		return nil, fmt.Errorf("database '%s' %w", db.Name, ErrAlreadyExists)
+	}
+	if !sm.memory {
+		if _, err := os.Stat(filepath.Join(sm.RootDir, db.Name)); err == nil {
+			return nil, fmt.Errorf("database '%s' %w", db.Name, ErrAlreadyExists)
+		}
+	}
+
+	if err := sm.SaveDatabase(db); err != nil {
+		return nil, err
+	}
+	if sm.dbManager != nil {
+		if err := sm.dbManager.AddDatabase(db); err != nil {
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// readDump decodes a dump into a database that is not saved yet
+func (sm *StorageManager) readDump(r io.Reader) (*Database, error) {
+	dec := json.NewDecoder(r)
+
+	var line dumpLine
+	if err := dec.Decode(&line); err != nil || line.Header == nil || line.Header.Format != dumpFormat {
+		return nil, fmt.Errorf("%w: missing dump header", ErrInvalidDump)
+	}
+	header := line.Header
+	if header.Version < 1 || header.Version > dumpFormatVersion {
+		return nil, fmt.Errorf("%w: unsupported dump version %d, this version reads up to %d", ErrInvalidDump, header.Version, dumpFormatVersion)
+	}
+	if !isPathElement(header.Database) {
+		return nil, fmt.Errorf("%w: invalid database name '%s'", ErrInvalidDump, header.Database)
+	}
+
+	db := NewDatabase(header.Database)
+	db.SchemaVersion = header.SchemaVersion
+
+	var coll *Collection
+	var metas []*collectionMeta
+	documents := 0
+	for {
+		line = dumpLine{}
+		if err := dec.Decode(&line); err != nil {
+			if err == io.EOF {
+				return nil, fmt.Errorf("%w: the dump was cut short", ErrInvalidDump)
+			}
+			return nil, fmt.Errorf("%w: %w", ErrInvalidDump, err)
+		}
+
+		switch {
+		case line.Collection != nil:
+			meta := line.Collection
+			if !isPathElement(meta.Name) {
+				return nil, fmt.Errorf("%w: invalid collection name '%s'", ErrInvalidDump, meta.Name)
+			}
+			if _, exists := db.Collections[meta.Name]; exists {
+				return nil, fmt.Errorf("%w: collection '%s' appears twice", ErrInvalidDump, meta.Name)
+			}
+			coll = NewCollection(meta.Name, meta.Schema)
+			coll.Format = meta.Format
+			if meta.Limits != nil {
+				coll.Limits = *meta.Limits
+			}
+			coll.IDKey = meta.IDKey
+			if meta.Shards < 0 || meta.Shards > MaxShards {
+				return nil, fmt.Errorf("%w: invalid shard count %d in collection '%s'", ErrInvalidDump, meta.Shards, meta.Name)
+			}
+			coll.Shards = meta.Shards
+			if err := meta.Collation.Validate(); err != nil {
+				return nil, fmt.Errorf("%w: collection '%s': %w", ErrInvalidDump, meta.Name, err)
+			}
+			coll.Collation = meta.Collation
+			if meta.InsertionOrder {
+				coll.setOrderLocked(nil) // documents follow in insertion order
+			}
+			db.Collections[meta.Name] = coll
+			metas = append(metas, meta)
+
+		case line.Document != nil:
+			doc := line.Document
+			if coll == nil {
+				return nil, fmt.Errorf("%w: document '%s' before any collection", ErrInvalidDump, doc.ID)
+			}
+			if doc.ID == "" {
+				return nil, fmt.Errorf("%w: document without an ID in collection '%s'", ErrInvalidDump, coll.Name)
+			}
+			if _, exists := coll.Documents[doc.ID]; exists {
+				return nil, fmt.Errorf("%w: document '%s' appears twice in collection '%s'", ErrInvalidDump, doc.ID, coll.Name)
+			}
+			if err := sm.decryptDocument(doc); err != nil {
+				return nil, err
+			}
+			coll.Documents[doc.ID] = doc
+			coll.trackInsertLocked(doc.ID)
+			documents++
+
+		case line.End != nil:
+			if line.End.Collections != len(metas) || line.End.Documents != documents {
+				return nil, fmt.Errorf("%w: expected %d collections and %d documents, read %d and %d",
+					ErrInvalidDump, line.End.Collections, line.End.Documents, len(metas), documents)
+			}
+			for _, meta := range metas {
+				sm.buildDumpedIndexes(db.Collections[meta.Name], meta.Indexes)
+			}
+			return db, nil
+
+		default:
+			return nil, fmt.Errorf("%w: unexpected line", ErrInvalidDump)
+		}
+	}
+}
+
+// buildDumpedIndexes creates a loaded collection's indexes (_id already
+// exists) and builds them
+func (sm *StorageManager) buildDumpedIndexes(coll *Collection, indexes map[string]string) {
+	build := []*Index{coll.Indexes["_id"]}
+	for indexName, fieldName := range indexes {
+		if indexName == "_id" {
+			continue
+		}
+		idx := NewIndex(indexName, fieldName)
+		coll.Indexes[indexName] = idx
+		build = append(build, idx)
+	}
+	buildIndexes(coll.Documents, build, workerCount(sm.indexConcurrency))
+	coll.recomputeMemSizeLocked()
+}
+
+// isPathElement reports whether a name from a dump can name a directory
+// under the root directory without leaving it
+func isPathElement(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
@@ -0,0 +1,216 @@
+package db
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+)
+
+// encryptedKey is the key of the object an encrypted field value is stored as
+const encryptedKey = "$encrypted"
+
+// WithEncryptionKey sets the AES key, 16, 24 or 32 bytes long, used to encrypt
+// the schema fields marked Encrypted. Their values are encrypted with AES-GCM
+// in data files and WAL entries, and decrypted when loaded; in memory they
+// stay plaintext, so queries work on them as on any other field. Indexes on
+// encrypted fields are not saved to disk but rebuilt when loading.
+func WithEncryptionKey(key []byte) StorageOption {
+	return func(sm *StorageManager) {
+		sm.encryptionKey = key
+	}
+}
+
+// newFieldCipher returns the AEAD for an encryption key
+func newFieldCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptedFields returns the names of the schema's encrypted fields, sorted
+func (s *Schema) encryptedFields() []string {
+	if s == nil {
+		return nil
+	}
+
+	var fields []string
+	for name, field := range s.Fields {
+		if field.Encrypted {
+			fields = append(fields, name)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// isEncrypted reports whether the schema encrypts a field
+func (s *Schema) isEncrypted(fieldName string) bool {
+	return s != nil && s.Fields[fieldName].Encrypted
+}
+
+// sealedCopies are the stored copies of a collection's documents with
+// encrypted fields, as last saved to or loaded from dir. Encryption uses a
+// random nonce, so encrypting an unchanged document again gives different
+// bytes, which binary saves would append to the data file anew; saves reuse
+// these copies instead while the documents are unchanged.
+type sealedCopies struct {
+	dir    string
+	fields []string // encrypted fields, sorted
+	docs   map[string]sealedDocument
+}
+
+// sealedDocument is the stored copy of a document
+type sealedDocument struct {
+	doc    *Document // the document in memory, replaced rather than modified by updates
+	stored *Document
+}
+
+// lookup returns the stored copy of doc if it is unchanged since it was
+// sealed for dir with the same encrypted fields
+func (s *sealedCopies) lookup(dir string, fields []string, doc *Document) (*Document, bool) {
+	if s == nil || s.dir != dir || !slices.Equal(s.fields, fields) {
+		return nil, false
+	}
+	sealed, exists := s.docs[doc.ID]
+	if !exists || sealed.doc != doc {
+		return nil, false
+	}
+	return sealed.stored, true
+}
+
+// decryptLoaded decrypts a loaded document like decryptDocument, keeping
+// its stored copy in sealed if it had encrypted fields, all of them ones
+// the schema still encrypts
+func (sm *StorageManager) decryptLoaded(doc *Document, schema *Schema, sealed map[string]sealedDocument) error {
+	reusable, found := true, false
+	for field, value := range doc.Data {
+		_, ok := encryptedValue(value)
+		found = found || ok
+		if ok != (schema.isEncrypted(field) && value != nil) {
+			reusable = false
+		}
+	}
+	if !found {
+		return nil
+	}
+
+	stored := &Document{ID: doc.ID, Data: maps.Clone(doc.Data)}
+	if err := sm.decryptDocument(doc); err != nil {
+		return err
+	}
+	if reusable {
+		sealed[doc.ID] = sealedDocument{doc: doc, stored: stored}
+	}
+	return nil
+}
+
+// encryptDocument returns doc with the fields in fields encrypted, or doc
+// itself if it has none of them. The document is not modified.
+func (sm *StorageManager) encryptDocument(doc *Document, fields []string) (*Document, error) {
+	var encrypted *Document
+	for _, field := range fields {
+		value, exists := doc.Data[field]
+		if !exists || value == nil {
+			continue
+		}
+		if sm.fieldCipher == nil {
+			return nil, fmt.Errorf("field '%s' is encrypted but no encryption key is set", field)
+		}
+
+		plaintext, err := marshalJSON(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode field '%s': %w", field, err)
+		}
+		nonce := make([]byte, sm.fieldCipher.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, fmt.Errorf("failed to generate nonce: %w", err)
+		}
+		// The ID and field name are authenticated, so a value can't be moved to another document or field
+		sealed := sm.fieldCipher.Seal(nonce, nonce, plaintext, fieldAAD(doc.ID, field))
+
+		if encrypted == nil {
+			encrypted = &Document{ID: doc.ID, Data: make(map[string]any, len(doc.Data))}
+			for k, v := range doc.Data {
+				encrypted.Data[k] = v
+			}
+		}
+		encrypted.Data[field] = map[string]any{encryptedKey: base64.StdEncoding.EncodeToString(sealed)}
+	}
+
+	if encrypted == nil {
+		return doc, nil
+	}
+	return encrypted, nil
+}
+
+// decryptDocument decrypts, in place, the fields of a loaded document stored
+// encrypted, whether or not the schema still marks them encrypted
+func (sm *StorageManager) decryptDocument(doc *Document) error {
+	for field, value := range doc.Data {
+		sealed, ok := encryptedValue(value)
+		if !ok {
+			continue
+		}
+		if sm.fieldCipher == nil {
+			return fmt.Errorf("field '%s' of document '%s' is encrypted but no encryption key is set", field, doc.ID)
+		}
+
+		data, err := base64.StdEncoding.DecodeString(sealed)
+		if err != nil || len(data) < sm.fieldCipher.NonceSize() {
+			return fmt.Errorf("field '%s' of document '%s' has a malformed encrypted value", field, doc.ID)
+		}
+		nonce, ciphertext := data[:sm.fieldCipher.NonceSize()], data[sm.fieldCipher.NonceSize():]
+		plaintext, err := sm.fieldCipher.Open(nil, nonce, ciphertext, fieldAAD(doc.ID, field))
+		if err != nil {
+			return fmt.Errorf("failed to decrypt field '%s' of document '%s': %w", field, doc.ID, err)
+		}
+
+		var decoded any
+		if err := DecodeJSON(plaintext, &decoded); err != nil {
+			return fmt.Errorf("failed to decode field '%s' of document '%s': %w", field, doc.ID, err)
+		}
+		doc.Data[field] = decoded
+	}
+	return nil
+}
+
+// encryptedValue returns the sealed data of a value stored encrypted
+func encryptedValue(value any) (string, bool) {
+	m, ok := value.(map[string]any)
+	if !ok || len(m) != 1 {
+		return "", false
+	}
+	sealed, ok := m[encryptedKey].(string)
+	return sealed, ok
+}
+
+// fieldAAD returns the additional data authenticated with a field's value
+func fieldAAD(docID, field string) []byte {
+	return []byte(docID + "\x00" + field)
+}
+
+// collectionSchema returns the schema of a loaded collection, or nil
+func (sm *StorageManager) collectionSchema(dbName, collName string) *Schema {
+	if sm.dbManager == nil {
+		return nil
+	}
+	db := sm.dbManager.GetDatabase(dbName)
+	if db == nil {
+		return nil
+	}
+
+	db.mu.RLock()
+	coll, exists := db.Collections[collName]
+	db.mu.RUnlock()
+	if !exists {
+		return nil
+	}
+	return coll.GetSchema()
+}
@@ -0,0 +1,43 @@
+package db
+
+import "errors"
+
+// ErrReadOnly is returned by write operations when storage was opened read-only
+var ErrReadOnly = errors.New("database is opened in read-only mode")
+
+// ErrChecksumMismatch is returned when stored data fails its integrity check
+var ErrChecksumMismatch = errors.New("checksum mismatch, file may be corrupt")
+
+// ErrNotFound is returned when a document, collection, index or database doesn't exist
+var ErrNotFound = errors.New("not found")
+
+// ErrAlreadyExists is returned when creating something whose name or ID is taken
+var ErrAlreadyExists = errors.New("already exists")
+
+// ErrDuplicateKey is returned alongside ErrAlreadyExists when inserting a
+// document whose ID is already used
+var ErrDuplicateKey = errors.New("duplicate key")
+
+// ErrSchemaValidation is returned when a document doesn't match the collection schema.
+// The wrapped error describes the offending field.
+var ErrSchemaValidation = errors.New("schema validation failed")
+
+// ErrReservedField is returned when a write sets a document field managed by
+// the database, such as "_id"
+var ErrReservedField = errors.New("reserved field")
+
+// ErrInvalidQuery is returned when a query or pipeline filter is malformed,
+// e.g. uses an unknown operator or a value of the wrong shape
+var ErrInvalidQuery = errors.New("invalid query")
+
+// ErrInvalidUpdate is returned when an update is malformed, e.g. uses an
+// unknown operator or sets a path through a field that isn't an object
+var ErrInvalidUpdate = errors.New("invalid update")
+
+// ErrUnavailable is returned for a collection that failed to load with
+// WithTolerantLoad; the wrapped error says why
+var ErrUnavailable = errors.New("unavailable")
+
+// ErrBusy is returned by WaitForWriteCapacity, with BackpressureError, when
+// too many writes are waiting for a checkpoint
+var ErrBusy = errors.New("too many pending writes")
@@ -0,0 +1,250 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// CollectionStore saves and reloads collections evicted from memory.
+// StorageManager implements it.
+type CollectionStore interface {
+	SaveCollection(dbName string, coll *Collection) error
+	LoadCollection(dbName, collName string) (*Collection, error)
+}
+
+// SetMemoryBudget limits the approximate memory used by the documents of the
+// database's loaded collections. When the budget is exceeded, the least
+// recently used collections are saved to store and evicted from memory; they
+// are reloaded from store the next time they are used. The budget is checked
+// whenever a collection is fetched with GetCollection. A budget of 0 disables
+// eviction.
+func (db *Database) SetMemoryBudget(budget int64, store CollectionStore) {
+	// In-memory storage has nowhere to evict collections to
+	if sm, ok := store.(*StorageManager); ok && sm.InMemory() {
+		store = nil
+	}
+
+	db.mu.Lock()
+	db.memoryBudget = budget
+	db.store = store
+	db.mu.Unlock()
+
+	db.enforceMemoryBudget(nil)
+}
+
+// MemoryUsage returns the approximate memory used by the loaded collections' documents
+func (db *Database) MemoryUsage() int64 {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var total int64
+	for _, coll := range db.Collections {
+		total += coll.memoryUsage()
+	}
+	return total
+}
+
+// touch records an access to a collection for least-recently-used eviction
+func (db *Database) touch(coll *Collection) {
+	coll.lastAccess.Store(db.accessClock.Add(1))
+}
+
+// enforceMemoryBudget evicts least recently used collections, except keep,
+// until the database is within its memory budget
+func (db *Database) enforceMemoryBudget(keep *Collection) {
+	db.evictMu.Lock()
+	defer db.evictMu.Unlock()
+
+	db.mu.RLock()
+	budget, store := db.memoryBudget, db.store
+	candidates := make([]*Collection, 0, len(db.Collections))
+	var total int64
+	for _, coll := range db.Collections {
+		total += coll.memoryUsage()
+		if coll != keep {
+			candidates = append(candidates, coll)
+		}
+	}
+	db.mu.RUnlock()
+
+	if budget <= 0 || store == nil || total <= budget {
+		return
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].lastAccess.Load() < candidates[j].lastAccess.Load()
+	})
+
+	for _, coll := range candidates {
+		if total <= budget {
+			return
+		}
+		size := coll.memoryUsage()
+		if size == 0 {
+			continue
+		}
+		evicted, err := coll.evict(db.Name, store)
+		if err != nil {
+			fmt.Printf("Failed to evict %s/%s: %v\n", db.Name, coll.Name, err)
+			continue
+		}
+		if evicted {
+			total -= size
+		}
+	}
+}
+
+// evict saves the collection to store and drops its documents and indexes
+// from memory. It reports false without error when the collection changed
+// while being saved, in which case it stays loaded.
+func (c *Collection) evict(dbName string, store CollectionStore) (bool, error) {
+	c.mu.RLock()
+	if c.unloaded {
+		c.mu.RUnlock()
+		return false, nil
+	}
+	modCount := c.modCount
+	c.mu.RUnlock()
+
+	// Flush the data before dropping it; read-only collections can't have changed
+	if err := store.SaveCollection(dbName, c); err != nil && !errors.Is(err, ErrReadOnly) {
+		return false, fmt.Errorf("failed to save collection: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.unloaded || c.modCount != modCount {
+		return false, nil
+	}
+
+	c.unloadLocked(func() (*Collection, error) {
+		return store.LoadCollection(dbName, c.Name)
+	})
+
+	return true, nil
+}
+
+// unloadLocked drops the documents and index contents from memory, keeping
+// the index definitions; loader is used to bring them back (caller must hold mu)
+func (c *Collection) unloadLocked(loader func() (*Collection, error)) {
+	indexes := make(map[string]*Index, len(c.Indexes))
+	for name, idx := range c.Indexes {
+		indexes[name] = NewIndex(idx.Name, idx.FieldName)
+	}
+
+	c.Documents = make(map[string]*Document)
+	if c.order != nil {
+		c.order, c.orderNext = make(map[string]uint64), 0 // filled in when reloaded
+	}
+	c.Indexes = indexes
+	c.memSize = 0
+	c.sealed = nil
+	c.unloaded = true
+	c.loader = loader
+}
+
+// Load brings the collection's documents into memory if they were evicted or
+// not loaded yet. Collections load on first use anyway; this only makes the
+// load (and any error) happen at a known point.
+func (c *Collection) Load() error {
+	if err := c.rlock(); err != nil {
+		return err
+	}
+	c.mu.RUnlock()
+	return nil
+}
+
+// Loaded reports whether the collection's documents are in memory
+func (c *Collection) Loaded() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return !c.unloaded
+}
+
+// LoadCollections loads every collection that isn't in memory,
+// e.g. to get eager loading on a lazily opened database
+func (db *Database) LoadCollections() error {
+	db.mu.RLock()
+	colls := make([]*Collection, 0, len(db.Collections))
+	for _, coll := range db.Collections {
+		colls = append(colls, coll)
+	}
+	db.mu.RUnlock()
+
+	for _, coll := range colls {
+		if err := coll.Load(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lock acquires the write lock, loading the collection first if it is unloaded
+func (c *Collection) lock() error {
+	c.mu.Lock()
+	if c.unloaded {
+		if err := c.reloadLocked(); err != nil {
+			c.mu.Unlock()
+			return err
+		}
+	}
+	return nil
+}
+
+// rlock acquires the read lock, loading the collection first if it is unloaded
+func (c *Collection) rlock() error {
+	for {
+		c.mu.RLock()
+		if !c.unloaded {
+			return nil
+		}
+		c.mu.RUnlock()
+
+		if err := c.lock(); err != nil {
+			return err
+		}
+		c.mu.Unlock()
+	}
+}
+
+// reloadLocked loads an unloaded collection's documents and indexes into
+// memory (caller must hold mu)
+func (c *Collection) reloadLocked() error {
+	loaded, err := c.loader()
+	if err != nil {
+		return fmt.Errorf("failed to load collection '%s': %w", c.Name, err)
+	}
+
+	c.Documents = loaded.Documents
+	c.order, c.orderNext = loaded.order, loaded.orderNext
+	c.Indexes = loaded.Indexes
+	c.memSize = loaded.memSize
+	c.sealed = loaded.sealed
+	c.unloaded = false
+	c.loader = nil
+
+	return nil
+}
+
+// memoryUsage returns the approximate memory used by the collection's documents
+func (c *Collection) memoryUsage() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.memSize
+}
+
+// recomputeMemSizeLocked recalculates the memory estimate from all documents
+// (caller must hold mu)
+func (c *Collection) recomputeMemSizeLocked() {
+	c.memSize = 0
+	for _, doc := range c.Documents {
+		c.memSize += docMemSize(doc)
+	}
+}
+
+// docMemSize returns the approximate memory used by a document
+func docMemSize(doc *Document) int64 {
+	return int64(len(doc.ID)) + estimateSize(doc.Data)
+}
@@ -0,0 +1,87 @@
+package db
+
+import "sort"
+
+// QueryPlan describes how Find looks up the documents of a query
+type QueryPlan struct {
+	Index      string `json:"index,omitempty"` // Index used to find candidates; empty for a full scan
+	Field      string `json:"field,omitempty"` // Field of the index
+	Candidates int    `json:"candidates"`      // Documents the filters are applied to
+	Total      int    `json:"total"`           // Documents in the collection
+}
+
+// Explain returns the plan Find uses for a query. An equality filter on "_id"
+// looks the document up by ID. Otherwise, of the equality filters on indexed
+// fields, Find uses the one whose value matches the fewest documents in its
+// index, and scans the whole collection when there is none.
+func (c *Collection) Explain(query *Query) (*QueryPlan, error) {
+	if err := query.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := c.rlock(); err != nil {
+		return nil, err
+	}
+	defer c.mu.RUnlock()
+
+	plan, _, _ := c.planLocked(query.Filters)
+	return plan, nil
+}
+
+// planLocked chooses the index to find candidates for filters with. It
+// returns the plan, and the index and filter to use, which are nil for a full
+// scan. Ties go to the earlier filter, then the index name (caller must hold mu).
+func (c *Collection) planLocked(filters []QueryFilter) (*QueryPlan, *Index, *QueryFilter) {
+	plan := &QueryPlan{Candidates: len(c.Documents), Total: len(c.Documents)}
+
+	// An ID lookup finds at most one document, so no index can do better
+	if id, ok := idFilter(filters); ok {
+		plan.Index, plan.Field, plan.Candidates = "_id", "_id", 0
+		if _, exists := c.Documents[id]; exists {
+			plan.Candidates = 1
+		}
+		return plan, nil, nil
+	}
+
+	names := make([]string, 0, len(c.Indexes))
+	for name := range c.Indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var best *Index
+	var bestFilter *QueryFilter
+	for i := range filters {
+		filter := &filters[i]
+		if filter.Operator != "eq" {
+			continue
+		}
+		for _, name := range names {
+			idx := c.Indexes[name]
+			if idx.FieldName != filter.Field {
+				continue
+			}
+			if count := idx.Count(filter.Value); best == nil || count < plan.Candidates {
+				best, bestFilter = idx, filter
+				plan.Index, plan.Field, plan.Candidates = idx.Name, idx.FieldName, count
+			}
+		}
+	}
+
+	return plan, best, bestFilter
+}
+
+// idFilter returns the ID of the first equality filter on "_id" with a
+// string value. IDs are strings, so other values can't match by ID lookup
+// and are left to the other filters.
+func idFilter(filters []QueryFilter) (string, bool) {
+	for _, filter := range filters {
+		if filter.Field != "_id" || filter.Operator != "eq" {
+			continue
+		}
+		if id, ok := filter.Value.(string); ok {
+			return id, true
+		}
+	}
+	return "", false
+}
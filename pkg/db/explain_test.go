@@ -0,0 +1,53 @@
+package db
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestExplainPicksMostSelectiveIndex(t *testing.T) {
+	docs := make(map[string]map[string]any)
+	for i := range 20 {
+		docs[fmt.Sprintf("d%02d", i)] = map[string]any{
+			"status": "open",
+			"email":  fmt.Sprintf("user%d@example.com", i),
+			"age":    i % 2,
+			"group":  i / 10,
+		}
+	}
+	coll := newTestCollection(t, docs, "status", "email", "age", "group")
+
+	for _, tc := range []struct {
+		query     *Query
+		wantIndex string
+		wantCands int
+		wantIDs   []string
+	}{
+		// The selective filter is picked whichever position it is in
+		{Where("status").Eq("open").And("email").Eq("user3@example.com").Build(), "email_idx", 1, []string{"d03"}},
+		{Where("email").Eq("user3@example.com").And("status").Eq("open").Build(), "email_idx", 1, []string{"d03"}},
+		{Where("status").Eq("open").And("age").Eq(1).Build(), "age_idx", 10, nil},
+		// A value no document has beats any other
+		{Where("age").Eq(1).And("status").Eq("closed").Build(), "status_idx", 0, []string{}},
+		// Ties go to the earlier filter
+		{Where("group").Eq(1).And("age").Eq(0).Build(), "group_idx", 10, nil},
+		{Where("age").Eq(0).And("group").Eq(1).Build(), "age_idx", 10, nil},
+		// Only equality filters use indexes
+		{Where("email").Ne("x").And("age").Gt(0).Build(), "", 20, nil},
+		{Where("_id").Eq("d05").And("status").Eq("open").Build(), "_id", 1, []string{"d05"}},
+	} {
+		plan, err := coll.Explain(tc.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plan.Index != tc.wantIndex || plan.Candidates != tc.wantCands || plan.Total != 20 {
+			t.Errorf("Explain %+v = %+v, want index %q with %d candidates of 20", tc.query.Filters, plan, tc.wantIndex, tc.wantCands)
+		}
+		if tc.wantIDs != nil {
+			if got := findIDs(t, coll, tc.query); !slices.Equal(got, tc.wantIDs) {
+				t.Errorf("Find %+v = %v, want %v", tc.query.Filters, got, tc.wantIDs)
+			}
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package db
+
+import "testing"
+
+// openTestDB opens the database "app" under dir without background syncs,
+// so data is only saved and the WAL checkpointed by Flush and Close.
+// Tests simulate a crash by opening dir again without closing the first DB.
+func openTestDB(t *testing.T, dir string, opts ...StorageOption) *DB {
+	t.Helper()
+	d, err := Open(dir, "app", append([]StorageOption{WithSyncInterval(0)}, opts...)...)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	return d
+}
+
+// mustInsert inserts a document with the given ID and data through d
+func mustInsert(t *testing.T, d *DB, collName, id string, data map[string]any) {
+	t.Helper()
+	if _, err := d.Insert(collName, &Document{ID: id, Data: data}); err != nil {
+		t.Fatalf("Insert %s: %v", id, err)
+	}
+}
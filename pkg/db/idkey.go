@@ -0,0 +1,105 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// IDDerivation names how an IDKey turns key field values into a document ID
+type IDDerivation string
+
+// ID derivations
+const (
+	IDSHA256 IDDerivation = "sha256" // hex SHA-256 of the JSON array of key values
+)
+
+// IDKey derives the IDs of documents inserted without one from natural key
+// fields, so inserting the same logical record twice yields the same ID and
+// fails with ErrDuplicateKey instead of creating a duplicate. Documents
+// inserted with an explicit ID keep it.
+type IDKey struct {
+	Fields     []string     `json:"fields"`               // dot paths of the key fields, in order
+	Derivation IDDerivation `json:"derivation,omitempty"` // empty means IDSHA256
+}
+
+// Validate checks that the key has fields and a known derivation
+func (k *IDKey) Validate() error {
+	if len(k.Fields) == 0 {
+		return fmt.Errorf("ID key needs at least one field")
+	}
+	seen := make(map[string]bool, len(k.Fields))
+	for _, field := range k.Fields {
+		if field == "" || field == "_id" {
+			return fmt.Errorf("invalid ID key field '%s'", field)
+		}
+		if seen[field] {
+			return fmt.Errorf("duplicate ID key field '%s'", field)
+		}
+		seen[field] = true
+	}
+
+	switch k.Derivation {
+	case "", IDSHA256:
+	default:
+		return fmt.Errorf("unknown ID derivation '%s'", k.Derivation)
+	}
+	return nil
+}
+
+// DeriveID returns the ID for a document from its key field values. Every
+// key field must be present and non-null.
+func (k *IDKey) DeriveID(doc *Document) (string, error) {
+	values := make([]any, len(k.Fields))
+	for i, field := range k.Fields {
+		value, ok := doc.GetPath(field)
+		if !ok || value == nil {
+			return "", fmt.Errorf("%w: missing ID key field '%s'", ErrSchemaValidation, field)
+		}
+		values[i] = value
+	}
+
+	// JSON gives equal values the same encoding: map keys are sorted and
+	// int64(1) and float64(1) both encode as 1
+	data, err := json.Marshal(values)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode ID key: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// SetIDKey sets the key IDs are derived from for documents inserted without
+// one; nil goes back to random UUIDs. Existing documents keep their IDs.
+func (c *Collection) SetIDKey(key *IDKey) error {
+	if key != nil {
+		if err := key.Validate(); err != nil {
+			return err
+		}
+		key = &IDKey{Fields: append([]string(nil), key.Fields...), Derivation: key.Derivation}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.readOnly {
+		return ErrReadOnly
+	}
+
+	c.IDKey = key
+	c.modCount++
+	return nil
+}
+
+// GetIDKey returns a copy of the collection's ID key, or nil if IDs are random
+func (c *Collection) GetIDKey() *IDKey {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.IDKey == nil {
+		return nil
+	}
+	return &IDKey{Fields: append([]string(nil), c.IDKey.Fields...), Derivation: c.IDKey.Derivation}
+}
@@ -2,11 +2,46 @@ package db
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
 )
 
+// nullIndexKey is the index key used for fields explicitly set to null.
+// Missing fields are never indexed, so the two cases stay distinct.
+const nullIndexKey = "\x00null"
+
+// indexKey converts a field value to its hash index key
+func indexKey(value any) string {
+	if value == nil {
+		return nullIndexKey
+	}
+	return valueKey(value)
+}
+
+// indexKeys returns the index keys of a field value: its own key and, for an
+// array, the keys of its elements, so equality filters on a single element
+// can use the index too
+func indexKeys(value any) []string {
+	keys := []string{indexKey(value)}
+	arr, ok := value.([]any)
+	if !ok {
+		return keys
+	}
+
+	seen := map[string]bool{keys[0]: true}
+	for _, elem := range arr {
+		if key := indexKey(elem); !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
 // AddToIndex adds a document to an index
 func (idx *Index) AddToIndex(doc *Document) error {
 	idx.mu.Lock()
@@ -17,13 +52,80 @@ func (idx *Index) AddToIndex(doc *Document) error {
 		return nil // Field doesn't exist in document, skip indexing
 	}
 
-	// Convert value to string for hash-based indexing
-	key := fmt.Sprintf("%v", value)
-	idx.Data[key] = doc.ID
+	// Convert value to string for hash-based indexing. IDs are kept sorted,
+	// so documents sharing a value are always returned in the same order.
+	for _, key := range indexKeys(value) {
+		ids := idx.Data[key]
+		i := sort.SearchStrings(ids, doc.ID)
+		if i < len(ids) && ids[i] == doc.ID {
+			continue
+		}
+		ids = append(ids, "")
+		copy(ids[i+1:], ids[i:])
+		ids[i] = doc.ID
+		idx.Data[key] = ids
+	}
 
 	return nil
 }
 
+// minIndexShard is the fewest documents buildIndexes gives a shard, so small
+// collections aren't split into more work than they save
+const minIndexShard = 4096
+
+// buildIndexes adds every document to the given empty indexes using at most
+// workers goroutines. Each index is built from shards of the documents that
+// are merged and sorted once at the end, which gives the same result as
+// adding the documents one by one with AddToIndex.
+func buildIndexes(docs map[string]*Document, indexes []*Index, workers int) {
+	if len(indexes) == 0 || len(docs) == 0 {
+		return
+	}
+
+	all := make([]*Document, 0, len(docs))
+	for _, doc := range docs {
+		all = append(all, doc)
+	}
+
+	// Spread the workers over the indexes, then split the documents of each
+	// index into that many shards
+	shards := max(1, min(workers/len(indexes), len(all)/minIndexShard))
+	shardSize := (len(all) + shards - 1) / shards
+	partials := make([]map[string][]string, len(indexes)*shards)
+
+	runParallel(len(partials), workers, func(i int) error { //nolint:errcheck // never fails
+		idx := indexes[i/shards]
+		shard := all[min(i%shards*shardSize, len(all)):min((i%shards+1)*shardSize, len(all))]
+
+		data := make(map[string][]string)
+		for _, doc := range shard {
+			if value, exists := doc.GetValue(idx.FieldName); exists {
+				for _, key := range indexKeys(value) {
+					data[key] = append(data[key], doc.ID)
+				}
+			}
+		}
+		partials[i] = data
+		return nil
+	})
+
+	runParallel(len(indexes), workers, func(i int) error { //nolint:errcheck // never fails
+		idx := indexes[i]
+		idx.mu.Lock()
+		defer idx.mu.Unlock()
+
+		for _, data := range partials[i*shards : (i+1)*shards] {
+			for key, ids := range data {
+				idx.Data[key] = append(idx.Data[key], ids...)
+			}
+		}
+		for _, ids := range idx.Data {
+			sort.Strings(ids)
+		}
+		return nil
+	})
+}
+
 // RemoveFromIndex removes a document from an index
 func (idx *Index) RemoveFromIndex(doc *Document) error {
 	idx.mu.Lock()
@@ -34,29 +136,59 @@ func (idx *Index) RemoveFromIndex(doc *Document) error {
 		return nil
 	}
 
-	key := fmt.Sprintf("%v", value)
-	delete(idx.Data, key)
+	for _, key := range indexKeys(value) {
+		ids := idx.Data[key]
+		i := sort.SearchStrings(ids, doc.ID)
+		if i == len(ids) || ids[i] != doc.ID {
+			continue
+		}
+		if len(ids) == 1 {
+			delete(idx.Data, key)
+		} else {
+			idx.Data[key] = append(ids[:i:i], ids[i+1:]...)
+		}
+	}
 
 	return nil
 }
 
-// Find finds a document ID by indexed field value
+// Find finds the first document ID, in ID order, with an indexed field value
 func (idx *Index) Find(value any) (string, bool) {
 	idx.mu.RLock()
 	defer idx.mu.RUnlock()
 
-	key := fmt.Sprintf("%v", value)
-	docID, exists := idx.Data[key]
-	return docID, exists
+	ids := idx.Data[indexKey(value)]
+	if len(ids) == 0 {
+		return "", false
+	}
+	return ids[0], true
+}
+
+// Count returns the number of documents with an indexed field value
+func (idx *Index) Count(value any) int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	return len(idx.Data[indexKey(value)])
+}
+
+// FindAll finds the IDs of all documents with an indexed field value, in ID order
+func (idx *Index) FindAll(value any) []string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	return append([]string(nil), idx.Data[indexKey(value)]...)
 }
 
 // CreateIndex creates a new index on a collection
 func (c *Collection) CreateIndex(indexName, fieldName string) error {
-	c.mu.Lock()
+	if err := c.lock(); err != nil {
+		return err
+	}
 	defer c.mu.Unlock()
 
 	if _, exists := c.Indexes[indexName]; exists {
-		return fmt.Errorf("index '%s' already exists", indexName)
+		return fmt.Errorf("index '%s' %w", indexName, ErrAlreadyExists)
 	}
 
 	idx := NewIndex(indexName, fieldName)
@@ -69,12 +201,157 @@ func (c *Collection) CreateIndex(indexName, fieldName string) error {
 	}
 
 	c.Indexes[indexName] = idx
+	c.modCount++
 	return nil
 }
 
+// schemaIndexName returns the name of the index created for a schema field
+// marked Index or Unique
+func schemaIndexName(fieldName string) string {
+	return fieldName + "_idx"
+}
+
+// createSchemaIndexes creates the missing indexes of the schema fields
+// marked Index or Unique, named after the field (e.g. "email_idx"), and
+// returns them. They are empty; a collection with documents has to build
+// them (caller must hold mu).
+func (c *Collection) createSchemaIndexes() []*Index {
+	if c.Schema == nil {
+		return nil
+	}
+	var created []*Index
+	for fieldName, field := range c.Schema.Fields {
+		if !field.Index && !field.Unique {
+			continue
+		}
+		name := schemaIndexName(fieldName)
+		if _, exists := c.Indexes[name]; exists {
+			continue
+		}
+		c.Indexes[name] = NewIndex(name, fieldName)
+		created = append(created, c.Indexes[name])
+	}
+	return created
+}
+
+// duplicateValuesLocked returns the IDs, sorted, of the documents sharing a
+// value of a field schema marks Unique with another document. Missing and
+// null values never conflict (caller must hold mu).
+func (c *Collection) duplicateValuesLocked(schema *Schema) []string {
+	duplicates := make(map[string]bool)
+	for fieldName, field := range schema.Fields {
+		if !field.Unique {
+			continue
+		}
+		owners := make(map[string]string)
+		for id, doc := range c.Documents {
+			value, exists := doc.GetValue(fieldName)
+			if !exists || value == nil {
+				continue
+			}
+			key := valueKey(value)
+			if other, taken := owners[key]; taken {
+				duplicates[other], duplicates[id] = true, true
+				continue
+			}
+			owners[key] = id
+		}
+	}
+
+	ids := make([]string, 0, len(duplicates))
+	for id := range duplicates {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// checkUniqueLocked checks that no other document has the value doc has in
+// a schema field marked Unique. Missing and null values never conflict. It
+// looks values up in an index on the field when there is one (caller must
+// hold mu).
+func (c *Collection) checkUniqueLocked(doc *Document) error {
+	if c.Schema == nil {
+		return nil
+	}
+	for fieldName, field := range c.Schema.Fields {
+		if !field.Unique {
+			continue
+		}
+		value, exists := doc.GetValue(fieldName)
+		if !exists || value == nil {
+			continue
+		}
+		if other, ok := c.findDuplicateLocked(fieldName, value, doc.ID); ok {
+			return fmt.Errorf("value of unique field '%s' %w in document '%s' (%w)", fieldName, ErrAlreadyExists, other, ErrDuplicateKey)
+		}
+	}
+	return nil
+}
+
+// findDuplicateLocked returns a document other than id whose field equals
+// value (caller must hold mu)
+func (c *Collection) findDuplicateLocked(fieldName string, value any, id string) (string, bool) {
+	matches := func(other *Document) bool {
+		if other.ID == id {
+			return false
+		}
+		otherValue, exists := other.GetValue(fieldName)
+		return exists && valuesEqual(otherValue, value)
+	}
+
+	for _, idx := range c.Indexes {
+		if idx.FieldName != fieldName {
+			continue
+		}
+		for _, otherID := range idx.FindAll(value) {
+			if other, ok := c.Documents[otherID]; ok && matches(other) {
+				return otherID, true
+			}
+		}
+		return "", false
+	}
+
+	for otherID, other := range c.Documents {
+		if matches(other) {
+			return otherID, true
+		}
+	}
+	return "", false
+}
+
+// IndexInfo describes an index of a collection
+type IndexInfo struct {
+	Name      string `json:"name"`
+	FieldName string `json:"field_name"`
+	Type      string `json:"type"` // Always "hash"
+}
+
+// ListIndexes returns the collection's indexes, ordered by name
+func (c *Collection) ListIndexes() []IndexInfo {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	infos := make([]IndexInfo, 0, len(c.Indexes))
+	for _, idx := range c.Indexes {
+		infos = append(infos, IndexInfo{
+			Name:      idx.Name,
+			FieldName: idx.FieldName,
+			Type:      "hash",
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+
+	return infos
+}
+
 // DropIndex removes an index from a collection
 func (c *Collection) DropIndex(indexName string) error {
-	c.mu.Lock()
+	if err := c.lock(); err != nil {
+		return err
+	}
 	defer c.mu.Unlock()
 
 	if indexName == "_id" {
@@ -82,46 +359,82 @@ func (c *Collection) DropIndex(indexName string) error {
 	}
 
 	if _, exists := c.Indexes[indexName]; !exists {
-		return fmt.Errorf("index '%s' does not exist", indexName)
+		return fmt.Errorf("index '%s' %w", indexName, ErrNotFound)
 	}
 
 	delete(c.Indexes, indexName)
+	c.modCount++
 	return nil
 }
 
-// updateIndexes updates all indexes when a document is modified
+// updateIndexes updates all indexes when a document is modified. It is all
+// or nothing: if an index fails to update, the indexes changed before it are
+// restored, so callers undoing the document change leave no index entry for
+// a document that isn't stored.
 func (c *Collection) updateIndexes(oldDoc, newDoc *Document) error {
+	updated := make([]*Index, 0, len(c.Indexes))
 	for _, idx := range c.Indexes {
-		if oldDoc != nil {
-			if err := idx.RemoveFromIndex(oldDoc); err != nil {
-				return err
+		if err := idx.replace(oldDoc, newDoc); err != nil {
+			for _, done := range updated {
+				done.replace(newDoc, oldDoc) //nolint:errcheck // restores entries that were just valid
 			}
+			return err
 		}
-		if newDoc != nil {
-			if err := idx.AddToIndex(newDoc); err != nil {
-				return err
+		updated = append(updated, idx)
+	}
+	return nil
+}
+
+// replace moves an index from the entries of oldDoc to those of newDoc,
+// either of which may be nil. If adding newDoc fails, oldDoc's entries are
+// put back.
+func (idx *Index) replace(oldDoc, newDoc *Document) error {
+	if oldDoc != nil {
+		if err := idx.RemoveFromIndex(oldDoc); err != nil {
+			return err
+		}
+	}
+	if newDoc != nil {
+		if err := idx.AddToIndex(newDoc); err != nil {
+			idx.RemoveFromIndex(newDoc) //nolint:errcheck
+			if oldDoc != nil {
+				idx.AddToIndex(oldDoc) //nolint:errcheck
 			}
+			return err
 		}
 	}
 	return nil
 }
 
+// indexFormatVersion is the version of saved index files. Version 2 added
+// the keys of array elements; version 3 keys integral numbers as integers
+// (see valueKey), so 1e+06 and 1000000 are the same key.
+const indexFormatVersion = 3
+
 // IndexData represents the serializable format of an index
 type IndexData struct {
-	Name      string            `json:"name"`
-	FieldName string            `json:"field_name"`
-	Data      map[string]string `json:"data"`
+	Version   int                 `json:"version"`
+	Name      string              `json:"name"`
+	FieldName string              `json:"field_name"`
+	Data      map[string][]string `json:"data"`
 }
 
-// Serialize converts an index to its serializable format
+// Serialize converts an index to its serializable format.
+// The data is copied, so the result can be used after the index changes.
 func (idx *Index) Serialize() (*IndexData, error) {
 	idx.mu.RLock()
 	defer idx.mu.RUnlock()
 
+	data := make(map[string][]string, len(idx.Data))
+	for k, ids := range idx.Data {
+		data[k] = append([]string(nil), ids...)
+	}
+
 	return &IndexData{
+		Version:   indexFormatVersion,
 		Name:      idx.Name,
 		FieldName: idx.FieldName,
-		Data:      idx.Data,
+		Data:      data,
 	}, nil
 }
 
@@ -133,37 +446,87 @@ func (idx *Index) Deserialize(data *IndexData) error {
 	idx.Name = data.Name
 	idx.FieldName = data.FieldName
 	idx.Data = data.Data
+	if idx.Data == nil {
+		idx.Data = make(map[string][]string)
+	}
+	for _, ids := range idx.Data {
+		sort.Strings(ids)
+	}
 
 	return nil
 }
 
 // SaveToDisk saves an index to a file
-func (idx *Index) SaveToDisk(dataDir, dbName, collName string) error {
+func (idx *Index) SaveToDisk(dataDir, dbName, collName string, perms FilePermissions) error {
 	data, err := idx.Serialize()
 	if err != nil {
 		return fmt.Errorf("failed to serialize index: %w", err)
 	}
 
+	return saveIndexData(data, dataDir, dbName, collName, perms, false)
+}
+
+// saveIndexData writes serialized index data to its file, indented if pretty
+func saveIndexData(data *IndexData, dataDir, dbName, collName string, perms FilePermissions, pretty bool) error {
 	// Create directory structure: dataDir/dbName/collName/indexes/
 	indexDir := filepath.Join(dataDir, dbName, collName, "indexes")
-	if err := os.MkdirAll(indexDir, 0755); err != nil {
+	if err := os.MkdirAll(indexDir, perms.DirMode); err != nil {
 		return fmt.Errorf("failed to create index directory: %w", err)
 	}
 
 	// Save to file: indexName.json
-	indexPath := filepath.Join(indexDir, idx.Name+".json")
-	jsonData, err := json.MarshalIndent(data, "", "  ")
+	indexPath := filepath.Join(indexDir, data.Name+".json")
+	jsonData, err := encodeJSON(data, pretty)
 	if err != nil {
 		return fmt.Errorf("failed to marshal index: %w", err)
 	}
 
-	if err := os.WriteFile(indexPath, jsonData, 0644); err != nil {
+	// The file is replaced atomically, so a failed save leaves the previous one
+	err = writeFileAtomic(indexPath, perms.FileMode, func(w io.Writer) error {
+		_, err := w.Write(jsonData)
+		return err
+	})
+	if err != nil {
 		return fmt.Errorf("failed to write index file: %w", err)
 	}
 
 	return nil
 }
 
+// removeStaleIndexFiles deletes the index files of indexes not in keep,
+// so dropped indexes aren't loaded again, and temporary files left behind
+// by saves that crashed
+func removeStaleIndexFiles(keep []*IndexData, dataDir, dbName, collName string) error {
+	indexDir := filepath.Join(dataDir, dbName, collName, "indexes")
+	entries, err := os.ReadDir(indexDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read index directory: %w", err)
+	}
+
+	names := make(map[string]bool, len(keep))
+	for _, data := range keep {
+		names[data.Name+".json"] = true
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || (filepath.Ext(entry.Name()) != ".json" && !isTempFile(entry.Name())) || names[entry.Name()] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(indexDir, entry.Name())); err != nil {
+			return fmt.Errorf("failed to remove index file: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// errLegacyIndex reports an index file in an older format, which has to be
+// rebuilt from the documents
+var errLegacyIndex = errors.New("legacy index format")
+
 // LoadFromDisk loads an index from a file
 func LoadIndexFromDisk(dataDir, dbName, collName, indexName string) (*Index, error) {
 	indexPath := filepath.Join(dataDir, dbName, collName, "indexes", indexName+".json")
@@ -175,8 +538,21 @@ func LoadIndexFromDisk(dataDir, dbName, collName, indexName string) (*Index, err
 
 	var data IndexData
 	if err := json.Unmarshal(jsonData, &data); err != nil {
+		// Indexes saved before they held several IDs per value map each
+		// value to a single ID and may have lost documents
+		var legacy struct {
+			Data map[string]string `json:"data"`
+		}
+		if json.Unmarshal(jsonData, &legacy) == nil {
+			return nil, errLegacyIndex
+		}
 		return nil, fmt.Errorf("failed to unmarshal index: %w", err)
 	}
+	// Older indexes lack the keys of array elements or key numbers
+	// differently; rebuilding them is cheaper than converting
+	if data.Version < indexFormatVersion {
+		return nil, errLegacyIndex
+	}
 
 	idx := NewIndex(data.Name, data.FieldName)
 	if err := idx.Deserialize(&data); err != nil {
@@ -208,6 +584,9 @@ func LoadAllIndexes(dataDir, dbName, collName string) (map[string]*Index, error)
 
 		indexName := entry.Name()[:len(entry.Name())-5] // Remove .json extension
 		idx, err := LoadIndexFromDisk(dataDir, dbName, collName, indexName)
+		if errors.Is(err, errLegacyIndex) {
+			continue // left for the caller to rebuild
+		}
 		if err != nil {
 			return nil, fmt.Errorf("failed to load index %s: %w", indexName, err)
 		}
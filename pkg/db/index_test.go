@@ -0,0 +1,148 @@
+package db
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// failedWrite replaces path the way writeFileAtomic does, but fails after
+// writing half of data, as a full disk or crashed save would
+func failedWrite(t *testing.T, path string, data []byte) {
+	t.Helper()
+	err := writeFileAtomic(path, DefaultFilePermissions.FileMode, func(w io.Writer) error {
+		if _, err := w.Write(data[:len(data)/2]); err != nil {
+			return err
+		}
+		return errors.New("no space left on device")
+	})
+	if err == nil {
+		t.Fatal("failed write reported success")
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if isTempFile(entry.Name()) {
+			t.Errorf("temporary file %s left behind", entry.Name())
+		}
+	}
+}
+
+func TestFailedIndexWriteKeepsPreviousIndex(t *testing.T) {
+	dir := t.TempDir()
+	idx := NewIndex("email_idx", "email")
+	if err := idx.AddToIndex(&Document{ID: "a", Data: map[string]any{"email": "x"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.SaveToDisk(dir, "app", "users", DefaultFilePermissions); err != nil {
+		t.Fatal(err)
+	}
+
+	newer := NewIndex("email_idx", "email")
+	if err := newer.AddToIndex(&Document{ID: "b", Data: map[string]any{"email": "y"}}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := newer.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := encodeJSON(data, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failedWrite(t, filepath.Join(dir, "app", "users", "indexes", "email_idx.json"), encoded)
+
+	loaded, err := LoadIndexFromDisk(dir, "app", "users", "email_idx")
+	if err != nil {
+		t.Fatalf("previous index unreadable: %v", err)
+	}
+	if got := loaded.FindAll("x"); !slices.Equal(got, []string{"a"}) {
+		t.Errorf("FindAll(x) = %v, want [a]", got)
+	}
+	if got := loaded.FindAll("y"); len(got) != 0 {
+		t.Errorf("FindAll(y) = %v, want none", got)
+	}
+}
+
+func TestFailedOffsetIndexWriteKeepsPreviousIndex(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "app", "users"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	index := &OffsetIndex{Entries: map[string]*DocumentEntry{
+		"a": {Offset: 8, Size: 10, CompressedSize: 12, Checksum: 42},
+	}}
+	if err := SaveOffsetIndex(index, dir, "app", "users", DefaultFilePermissions); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "app", "users", "collection.idx")
+	saved, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failedWrite(t, path, append(slices.Clone(saved), saved...))
+
+	loaded, err := LoadOffsetIndex(dir, "app", "users")
+	if err != nil {
+		t.Fatalf("previous offset index unreadable: %v", err)
+	}
+	if len(loaded.Entries) != 1 || *loaded.Entries["a"] != *index.Entries["a"] {
+		t.Errorf("loaded entries = %v, want the saved one", loaded.Entries)
+	}
+}
+
+func TestIndexKeepsSharedValuesInIDOrder(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDB(t, dir)
+	schema := &Schema{Fields: map[string]Field{"status": {Type: TypeString, Index: true}}}
+	if _, err := d.CreateCollection("items", schema); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"e", "b", "d", "a", "c"} {
+		mustInsert(t, d, "items", id, map[string]any{"status": "open"})
+	}
+	mustInsert(t, d, "items", "f", map[string]any{"status": "closed"})
+	if err := d.Delete("items", "c"); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(d *DB) {
+		t.Helper()
+		coll, err := d.Collection("items")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := coll.Indexes["status_idx"].FindAll("open"); !slices.Equal(got, []string{"a", "b", "d", "e"}) {
+			t.Errorf("FindAll(open) = %v, want [a b d e]", got)
+		}
+		if id, ok := coll.Indexes["status_idx"].Find("open"); !ok || id != "a" {
+			t.Errorf("Find(open) = %q, %v, want a", id, ok)
+		}
+		docs, err := coll.Find(Where("status").Eq("open").Limit(3).Build())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, doc := range docs {
+			ids = append(ids, doc.ID)
+		}
+		if !slices.Equal(ids, []string{"a", "b", "d"}) {
+			t.Errorf("Find with limit 3 = %v, want [a b d]", ids)
+		}
+	}
+	check(d)
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	d = openTestDB(t, dir)
+	defer d.Close()
+	check(d)
+}
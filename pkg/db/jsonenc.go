@@ -0,0 +1,95 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"maps"
+	"slices"
+	"time"
+)
+
+// encodeJSON encodes v as JSON followed by a newline, indented if pretty.
+// Unlike json.Marshal it leaves <, > and & as they are instead of escaping
+// them as \u003c and so on, since stored JSON is not embedded in HTML.
+func encodeJSON(v any, pretty bool) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if pretty {
+		enc.SetIndent("", "  ")
+	}
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// marshalJSON is json.Marshal without HTML escaping (see encodeJSON)
+func marshalJSON(v any) ([]byte, error) {
+	data, err := encodeJSON(v, false)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(data, []byte("\n")), nil
+}
+
+// WithTimeFormat sets the layout, as for time.Format, that time.Time values
+// in documents are stored with: in data files, the WAL and dumps. The
+// default, an empty layout, stores them as RFC 3339 with nanoseconds.
+// Stored times load back as strings either way, and Document.GetTime only
+// parses RFC 3339 ones.
+func WithTimeFormat(layout string) StorageOption {
+	return func(sm *StorageManager) {
+		sm.timeFormat = layout
+	}
+}
+
+// formatTimes returns doc with its time.Time values, nested ones included,
+// formatted with layout, or doc itself if layout is empty or it has none.
+// The document is not modified.
+func formatTimes(doc *Document, layout string) *Document {
+	if layout == "" {
+		return doc
+	}
+	data, changed := formatTimeValue(doc.Data, layout)
+	if !changed {
+		return doc
+	}
+	return &Document{ID: doc.ID, Data: data.(map[string]any)}
+}
+
+// formatTimeValue formats the time.Time values in value with layout,
+// copying the maps and slices holding them, and reports whether it had any
+func formatTimeValue(value any, layout string) (any, bool) {
+	switch v := value.(type) {
+	case time.Time:
+		return v.Format(layout), true
+	case map[string]any:
+		var formatted map[string]any
+		for key, elem := range v {
+			if elem, changed := formatTimeValue(elem, layout); changed {
+				if formatted == nil {
+					formatted = maps.Clone(v)
+				}
+				formatted[key] = elem
+			}
+		}
+		if formatted != nil {
+			return formatted, true
+		}
+	case []any:
+		var formatted []any
+		for i, elem := range v {
+			if elem, changed := formatTimeValue(elem, layout); changed {
+				if formatted == nil {
+					formatted = slices.Clone(v)
+				}
+				formatted[i] = elem
+			}
+		}
+		if formatted != nil {
+			return formatted, true
+		}
+	}
+	return value, false
+}
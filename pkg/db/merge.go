@@ -0,0 +1,352 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// MergePolicy decides what a merge does with a source document whose ID
+// already exists in the target collection
+type MergePolicy string
+
+// Merge policies
+const (
+	MergeSkip      MergePolicy = "skip"      // keep the existing document
+	MergeOverwrite MergePolicy = "overwrite" // replace it with the source document
+	MergeError     MergePolicy = "error"     // fail the merge without changing anything
+)
+
+// MergeResult counts what a merge did
+type MergeResult struct {
+	CollectionsCreated []string `json:"collections_created"`
+	Inserted           int      `json:"inserted"`
+	Overwritten        int      `json:"overwritten"`
+	Skipped            int      `json:"skipped"`
+}
+
+// mergeSource is a source collection prepared for merging
+type mergeSource struct {
+	coll    *Collection
+	target  *Collection // nil when the collection doesn't exist in the target yet
+	docs    []*Document
+	indexes []IndexInfo
+}
+
+// Merge copies the collections and documents of src into the database.
+// Collections missing from the database are created with the source's schema,
+// format, query limits, ID key and indexes. Existing collections must have compatible
+// schemas: fields defined in both must have the same type, and every source
+// document must be valid against the target schema. Documents whose ID
+// already exists are handled according to policy.
+//
+// Everything is checked before anything is changed, so a schema mismatch, a
+// duplicate value of a unique field or, with MergeError, a conflicting ID
+// leaves the database untouched. Writes made
+// to the database while merging can still make it fail part way.
+func (db *Database) Merge(src *Database, policy MergePolicy) (*MergeResult, error) {
+	switch policy {
+	case MergeSkip, MergeOverwrite, MergeError:
+	default:
+		return nil, fmt.Errorf("invalid merge policy '%s'", policy)
+	}
+
+	sources, err := db.prepareMerge(src, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &MergeResult{CollectionsCreated: []string{}}
+	for _, source := range sources {
+		if source.target == nil {
+			target, err := db.createMergedCollection(source)
+			if err != nil {
+				return result, err
+			}
+			source.target = target
+			result.CollectionsCreated = append(result.CollectionsCreated, source.coll.Name)
+		}
+
+		if err := source.target.mergeDocuments(source.docs, policy, result); err != nil {
+			return result, fmt.Errorf("failed to merge collection '%s': %w", source.coll.Name, err)
+		}
+	}
+
+	return result, nil
+}
+
+// prepareMerge snapshots the source collections and checks them against the
+// database's collections
+func (db *Database) prepareMerge(src *Database, policy MergePolicy) ([]*mergeSource, error) {
+	names := src.ListCollections()
+	sort.Strings(names)
+
+	sources := make([]*mergeSource, 0, len(names))
+	for _, name := range names {
+		coll, err := src.GetCollection(name)
+		if err != nil {
+			return nil, err
+		}
+		docs, err := coll.snapshot()
+		if err != nil {
+			return nil, err
+		}
+		source := &mergeSource{coll: coll, docs: docs, indexes: coll.ListIndexes()}
+
+		target, err := db.GetCollection(name)
+		if errors.Is(err, ErrNotFound) {
+			sources = append(sources, source)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		source.target = target
+
+		if err := target.checkMergeSource(source, policy); err != nil {
+			return nil, fmt.Errorf("cannot merge collection '%s': %w", name, err)
+		}
+		sources = append(sources, source)
+	}
+
+	return sources, nil
+}
+
+// checkMergeSource checks that a source collection can be merged into the
+// collection without errors
+func (c *Collection) checkMergeSource(source *mergeSource, policy MergePolicy) error {
+	if err := c.rlock(); err != nil {
+		return err
+	}
+	defer c.mu.RUnlock()
+
+	if err := checkSchemasCompatible(c.Schema, source.coll.GetSchema()); err != nil {
+		return err
+	}
+
+	for _, info := range source.indexes {
+		if idx, ok := c.Indexes[info.Name]; ok && idx.FieldName != info.FieldName {
+			return fmt.Errorf("index '%s' is on field '%s' in the target and '%s' in the source",
+				info.Name, idx.FieldName, info.FieldName)
+		}
+	}
+
+	var conflicts []string
+	for _, doc := range source.docs {
+		_, exists := c.Documents[doc.ID]
+		if exists && policy == MergeError {
+			conflicts = append(conflicts, doc.ID)
+		}
+		if (exists && policy == MergeSkip) || c.Schema == nil {
+			continue
+		}
+		if err := c.Schema.ValidateDocument(doc); err != nil {
+			return fmt.Errorf("document '%s': %w: %w", doc.ID, ErrSchemaValidation, err)
+		}
+	}
+
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return fmt.Errorf("%d document(s) %w, first '%s'", len(conflicts), ErrAlreadyExists, conflicts[0])
+	}
+	return c.checkMergeUniqueLocked(source.docs, policy)
+}
+
+// checkMergeUniqueLocked checks that merging docs in order keeps the values
+// of unique schema fields unique, both against the collection's documents
+// and among docs, following what mergeDocuments does with existing IDs
+// (caller must hold mu)
+func (c *Collection) checkMergeUniqueLocked(docs []*Document, policy MergePolicy) error {
+	if c.Schema == nil {
+		return nil
+	}
+
+	for fieldName, field := range c.Schema.Fields {
+		if !field.Unique {
+			continue
+		}
+
+		// Document holding each value once docs merged so far are in
+		owners := make(map[string]string)
+		for id, doc := range c.Documents {
+			if value, exists := doc.GetValue(fieldName); exists && value != nil {
+				owners[valueKey(value)] = id
+			}
+		}
+
+		for _, doc := range docs {
+			if existing, exists := c.Documents[doc.ID]; exists {
+				if policy == MergeSkip {
+					continue
+				}
+				// Overwriting drops the existing document's value
+				if value, exists := existing.GetValue(fieldName); exists && value != nil {
+					if key := valueKey(value); owners[key] == doc.ID {
+						delete(owners, key)
+					}
+				}
+			}
+
+			value, exists := doc.GetValue(fieldName)
+			if !exists || value == nil {
+				continue
+			}
+			key := valueKey(value)
+			if other, taken := owners[key]; taken && other != doc.ID {
+				return fmt.Errorf("document '%s': value of unique field '%s' %w in document '%s' (%w)",
+					doc.ID, fieldName, ErrAlreadyExists, other, ErrDuplicateKey)
+			}
+			owners[key] = doc.ID
+		}
+	}
+	return nil
+}
+
+// checkSchemasCompatible checks that fields defined by both schemas have the same type
+func checkSchemasCompatible(target, source *Schema) error {
+	if target == nil || source == nil {
+		return nil
+	}
+
+	for name, field := range source.Fields {
+		if existing, ok := target.Fields[name]; ok && existing.Type != field.Type {
+			return fmt.Errorf("%w: field '%s' is %s in the target and %s in the source",
+				ErrSchemaValidation, name, existing.Type, field.Type)
+		}
+	}
+	return nil
+}
+
+// createMergedCollection creates a collection like a source collection, without its documents
+func (db *Database) createMergedCollection(source *mergeSource) (*Collection, error) {
+	name := source.coll.Name
+	if err := db.CreateCollection(name, source.coll.GetSchema()); err != nil {
+		return nil, err
+	}
+
+	coll, err := db.GetCollection(name)
+	if err != nil {
+		return nil, err
+	}
+
+	source.coll.mu.RLock()
+	format, shards := source.coll.Format, source.coll.Shards
+	source.coll.mu.RUnlock()
+	if err := coll.SetFormat(format); err != nil {
+		return nil, err
+	}
+	if err := coll.SetShards(shards); err != nil {
+		return nil, err
+	}
+	if err := coll.SetQueryLimits(source.coll.QueryLimits()); err != nil {
+		return nil, err
+	}
+	if err := coll.SetIDKey(source.coll.GetIDKey()); err != nil {
+		return nil, err
+	}
+	if err := coll.SetCollation(source.coll.GetCollation()); err != nil {
+		return nil, err
+	}
+	if err := coll.SetInsertionOrder(source.coll.InsertionOrder()); err != nil {
+		return nil, err
+	}
+
+	// _id and the indexes of the schema exist already
+	created := make(map[string]string)
+	for _, info := range coll.ListIndexes() {
+		created[info.Name] = info.FieldName
+	}
+	for _, info := range source.indexes {
+		if field, ok := created[info.Name]; ok && field == info.FieldName {
+			continue
+		}
+		if err := coll.CreateIndex(info.Name, info.FieldName); err != nil {
+			return nil, err
+		}
+	}
+
+	return coll, nil
+}
+
+// mergeDocuments inserts documents into the collection, resolving existing
+// IDs according to policy
+func (c *Collection) mergeDocuments(docs []*Document, policy MergePolicy, result *MergeResult) error {
+	if err := c.lock(); err != nil {
+		return err
+	}
+	defer c.mu.Unlock()
+
+	for _, doc := range docs {
+		if _, exists := c.Documents[doc.ID]; exists {
+			switch policy {
+			case MergeSkip:
+				result.Skipped++
+				continue
+			case MergeError:
+				return fmt.Errorf("document with ID '%s' %w", doc.ID, ErrAlreadyExists)
+			}
+
+			existing, position := c.Documents[doc.ID], c.orderOfLocked(doc.ID)
+			if err := c.deleteLocked(doc.ID); err != nil {
+				return err
+			}
+			if err := c.insertLocked(doc, writeOptions{}); err != nil {
+				c.revertLocked(batchChange{before: existing, position: position})
+				return err
+			}
+			c.restoreOrderLocked(doc.ID, position)
+			result.Overwritten++
+			continue
+		}
+
+		if err := c.insertLocked(doc, writeOptions{}); err != nil {
+			return err
+		}
+		result.Inserted++
+	}
+
+	return nil
+}
+
+// MergeDatabase merges the database dbName of src into the database of the
+// same name here, creating it if needed, and saves the result (see
+// Database.Merge). When databases were loaded with LoadAllDatabases, the
+// loaded database is merged into; otherwise it is loaded from disk.
+func (sm *StorageManager) MergeDatabase(src *StorageManager, dbName string, policy MergePolicy) (*MergeResult, error) {
+	if sm.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	srcDB, err := src.LoadDatabase(dbName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load source database: %w", err)
+	}
+
+	var target *Database
+	if sm.dbManager != nil {
+		target = sm.dbManager.GetDatabase(dbName)
+	}
+	if target == nil {
+		target, err = sm.LoadDatabase(dbName)
+		if errors.Is(err, ErrNotFound) {
+			target, err = NewDatabase(dbName), nil
+			if sm.dbManager != nil {
+				err = sm.dbManager.AddDatabase(target)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load database: %w", err)
+		}
+	}
+
+	result, err := target.Merge(srcDB, policy)
+	if err != nil && result == nil {
+		return nil, err
+	}
+
+	// Save what was merged, even if the merge stopped part way
+	if saveErr := sm.SaveDatabase(target); saveErr != nil {
+		return result, errors.Join(err, fmt.Errorf("failed to save database: %w", saveErr))
+	}
+	return result, err
+}
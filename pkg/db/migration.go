@@ -18,7 +18,7 @@ type MigrationFunc func(dbManager *DatabaseManager, storage *StorageManager) err
 //     db.RegisterMigration(1, func(dbManager *db.DatabaseManager, storage *db.StorageManager) error {
 //         fmt.Println("Running migration 1 -> 2")
 //         // Iterate through all databases
-//         for _, database := range dbManager.Databases {
+//         for _, database := range dbManager.AllDatabases() {
 //             // Perform migration operations on each database
 //             // Example: Add a new field, transform data, etc.
 //         }
@@ -101,7 +101,9 @@ func (mm *MigrationManager) MigrateDatabase(dbName string, targetVersion int) er
 
 	// Create a temporary DatabaseManager with just this database
 	dbManager := NewDatabaseManager()
-	dbManager.Databases[dbName] = db
+	if err := dbManager.AddDatabase(db); err != nil {
+		return err
+	}
 
 	// Apply migrations iteratively from currentVersion to targetVersion
 	for version := currentVersion; version < targetVersion; version++ {
@@ -142,7 +144,7 @@ func (mm *MigrationManager) MigrateAllDatabases(targetVersion int) error {
 	}
 
 	migratedCount := 0
-	for dbName := range dbManager.Databases {
+	for _, dbName := range dbManager.ListDatabases() {
 		if err := mm.MigrateDatabase(dbName, targetVersion); err != nil {
 			return fmt.Errorf("failed to migrate database '%s': %w", dbName, err)
 		}
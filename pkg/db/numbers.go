@@ -0,0 +1,154 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+)
+
+// DecodeJSON decodes JSON into v like json.Unmarshal, except that numbers
+// decoded into untyped (any) values keep their integer-ness: integers that
+// fit in an int64 become int64 instead of float64, so large IDs and counts
+// keep their exact value. Other numbers become float64 as usual.
+func DecodeJSON(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return fmt.Errorf("invalid character after top-level value")
+	}
+
+	normalizeNumbers(reflect.ValueOf(v))
+	return nil
+}
+
+// valueKey returns the string a value is compared by for equality, in
+// filters and updates, and indexed and grouped under. Numbers are
+// canonicalized first, so a value has the same key before and after it is
+// stored and loaded again: float64(1000000) and the int64 it loads back as
+// are both "1000000".
+func valueKey(value any) string {
+	value, _ = canonicalNumbers(value)
+	return fmt.Sprintf("%v", value)
+}
+
+// canonicalNumbers converts the numbers in value, nested ones included, to
+// the types DecodeJSON loads them as: int64 for integers that fit in one,
+// float64 for other numbers. It copies the maps and slices holding numbers
+// it converts, and reports whether it converted any.
+func canonicalNumbers(value any) (any, bool) {
+	switch v := value.(type) {
+	case nil, string, bool, int64:
+		return value, false
+	case float64:
+		if v == math.Trunc(v) && v >= math.MinInt64 && v < math.MaxInt64 {
+			return int64(v), true
+		}
+		return value, false
+	case map[string]any:
+		var converted map[string]any
+		for key, elem := range v {
+			if elem, changed := canonicalNumbers(elem); changed {
+				if converted == nil {
+					converted = maps.Clone(v)
+				}
+				converted[key] = elem
+			}
+		}
+		if converted != nil {
+			return converted, true
+		}
+		return value, false
+	case []any:
+		var converted []any
+		for i, elem := range v {
+			if elem, changed := canonicalNumbers(elem); changed {
+				if converted == nil {
+					converted = slices.Clone(v)
+				}
+				converted[i] = elem
+			}
+		}
+		if converted != nil {
+			return converted, true
+		}
+		return value, false
+	}
+
+	// Other numeric types, set by library users rather than loaded
+	rv := reflect.ValueOf(value)
+	switch {
+	case rv.CanInt():
+		return rv.Int(), true
+	case rv.CanUint():
+		if u := rv.Uint(); u <= math.MaxInt64 {
+			return int64(u), true
+		}
+		return float64(rv.Uint()), true
+	case rv.Kind() == reflect.Float32:
+		// Stored with float32 precision, e.g. 0.1 rather than 0.100000001
+		f, _ := strconv.ParseFloat(strconv.FormatFloat(rv.Float(), 'g', -1, 32), 64)
+		f2, _ := canonicalNumbers(f)
+		return f2, true
+	case rv.CanFloat():
+		f, _ := canonicalNumbers(rv.Float())
+		return f, true
+	}
+	return value, false
+}
+
+// numberValue converts a decoded json.Number to int64 or float64
+func numberValue(n json.Number) any {
+	if i, err := n.Int64(); err == nil {
+		return i
+	}
+	f, _ := n.Float64() // out of range values still yield ±Inf
+	return f
+}
+
+// normalizeNumbers replaces the json.Number values reachable from v
+// with int64 or float64 values
+func normalizeNumbers(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return
+		}
+		elem := v.Elem()
+		if n, ok := elem.Interface().(json.Number); ok && v.Kind() == reflect.Interface && v.CanSet() {
+			v.Set(reflect.ValueOf(numberValue(n)))
+			return
+		}
+		normalizeNumbers(elem)
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			value := iter.Value()
+			if value.Kind() == reflect.Interface && !value.IsNil() {
+				if n, ok := value.Elem().Interface().(json.Number); ok {
+					v.SetMapIndex(iter.Key(), reflect.ValueOf(numberValue(n)))
+					continue
+				}
+			}
+			normalizeNumbers(value)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			normalizeNumbers(v.Index(i))
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				normalizeNumbers(v.Field(i))
+			}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package db
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDecodeJSONKeepsIntegers(t *testing.T) {
+	var v map[string]any
+	if err := DecodeJSON([]byte(`{"big": 9007199254740993, "f": 1.5, "nested": [{"n": 2}]}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := v["big"].(int64); !ok || got != 9007199254740993 {
+		t.Errorf("big = %#v, want int64(9007199254740993)", v["big"])
+	}
+	if got, ok := v["f"].(float64); !ok || got != 1.5 {
+		t.Errorf("f = %#v, want 1.5", v["f"])
+	}
+	nested := v["nested"].([]any)[0].(map[string]any)
+	if _, ok := nested["n"].(int64); !ok {
+		t.Errorf("nested n = %#v, want int64", nested["n"])
+	}
+
+	if err := DecodeJSON([]byte(`{} x`), &v); err == nil {
+		t.Error("trailing data accepted")
+	}
+}
+
+func TestValueKey(t *testing.T) {
+	for _, tc := range []struct {
+		a, b any
+	}{
+		{float64(1000000), int64(1000000)},
+		{float32(2), 2},
+		{uint8(3), int64(3)},
+		{[]any{float64(1e6)}, []any{int64(1000000)}},
+		{map[string]any{"n": float64(4)}, map[string]any{"n": int64(4)}},
+	} {
+		if ka, kb := valueKey(tc.a), valueKey(tc.b); ka != kb {
+			t.Errorf("valueKey(%#v) = %q, valueKey(%#v) = %q, want equal", tc.a, ka, tc.b, kb)
+		}
+	}
+	if valueKey(1.5) == valueKey(int64(1)) {
+		t.Error("1.5 and 1 have the same key")
+	}
+}
+
+// Documents inserted with float64 numbers hold int64 ones after a reload,
+// and must still match the same filters and index keys
+func TestNumbersMatchAcrossReload(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDB(t, dir)
+	schema := &Schema{Fields: map[string]Field{
+		"n":   {Type: TypeNumber, Index: true},
+		"ref": {Type: TypeNumber, Unique: true},
+	}}
+	if _, err := d.CreateCollection("items", schema); err != nil {
+		t.Fatal(err)
+	}
+	mustInsert(t, d, "items", "a", map[string]any{"n": float64(1000000), "ref": float64(7), "tags": []any{float64(5)}})
+	mustInsert(t, d, "items", "b", map[string]any{"n": float64(1000000), "ref": float64(8)})
+	mustInsert(t, d, "items", "c", map[string]any{"n": 2.5, "ref": float64(9)})
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	d = openTestDB(t, dir)
+	defer d.Close()
+	coll, err := d.Collection("items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, ok := coll.Documents["a"].Data["n"].(int64); !ok || n != 1000000 {
+		t.Fatalf("reloaded n = %#v, want int64(1000000)", coll.Documents["a"].Data["n"])
+	}
+
+	for _, tc := range []struct {
+		query *Query
+		want  []string
+	}{
+		{Where("n").Eq(1000000).Build(), []string{"a", "b"}},
+		{Where("n").Eq(float64(1e6)).Build(), []string{"a", "b"}},
+		{Where("n").Eq(2.5).Build(), []string{"c"}},
+		{Where("n").In(float64(1e6), 3).Build(), []string{"a", "b"}},
+		{Where("tags").Eq(float64(5)).Build(), []string{"a"}},
+		{Where("ref").Ne(float64(7)).Build(), []string{"b", "c"}},
+	} {
+		if got := findIDs(t, coll, tc.query); !slices.Equal(got, tc.want) {
+			t.Errorf("%+v: got %v, want %v", tc.query.Filters, got, tc.want)
+		}
+	}
+
+	if _, err := d.Insert("items", &Document{ID: "d", Data: map[string]any{"ref": float64(7)}}); err == nil {
+		t.Error("duplicate unique number accepted after reload")
+	}
+
+	rows, err := coll.Aggregate([]AggregateStage{
+		{Match: []QueryFilter{{Field: "n", Operator: "eq", Value: float64(1e6)}}},
+		{Group: &GroupStage{By: "n", Accumulators: map[string]Accumulator{"count": {Op: "count"}}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || valueKey(rows[0]["count"]) != "2" {
+		t.Errorf("$group rows = %v, want one group of 2", rows)
+	}
+}
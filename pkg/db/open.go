@@ -0,0 +1,466 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// DB is an open database handle. It owns the storage manager and WAL of its
+// root directory; writes made through its methods are logged to the WAL and
+// saved in the background. With WithMaxPendingWrites, its writes are subject
+// to backpressure. Close must be called to release it.
+type DB struct {
+	storage  *StorageManager
+	manager  *DatabaseManager
+	database *Database
+}
+
+// Open opens the database dbName stored under rootDir, creating it if it
+// doesn't exist. Any WAL entries not yet saved are replayed first.
+func Open(rootDir, dbName string, opts ...StorageOption) (*DB, error) {
+	storage, err := NewStorageManager(rootDir, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage manager: %w", err)
+	}
+
+	manager, err := storage.LoadAllDatabases()
+	if err != nil {
+		storage.Close()
+		return nil, fmt.Errorf("failed to load databases: %w", err)
+	}
+
+	database := manager.GetDatabase(dbName)
+	if database == nil {
+		if storage.ReadOnly() {
+			storage.Close()
+			return nil, fmt.Errorf("database '%s' %w", dbName, ErrNotFound)
+		}
+
+		database = manager.CreateDatabase(dbName)
+		if err := storage.LogCreateDatabase(dbName); err != nil {
+			storage.Close()
+			return nil, fmt.Errorf("failed to log create database: %w", err)
+		}
+	}
+
+	if !storage.ReadOnly() {
+		storage.StartBackgroundSync(manager)
+	}
+
+	return &DB{
+		storage:  storage,
+		manager:  manager,
+		database: database,
+	}, nil
+}
+
+// Database returns the underlying database. Changes made directly through it
+// are not logged to the WAL; they are only saved by Flush and Close.
+func (d *DB) Database() *Database {
+	return d.database
+}
+
+// Storage returns the storage manager owned by the handle
+func (d *DB) Storage() *StorageManager {
+	return d.storage
+}
+
+// Collection returns a collection by name
+func (d *DB) Collection(name string) (*Collection, error) {
+	return d.database.GetCollection(name)
+}
+
+// ListCollections returns the names of all collections
+func (d *DB) ListCollections() []string {
+	return d.database.ListCollections()
+}
+
+// CreateCollection creates a collection and logs it to the WAL
+func (d *DB) CreateCollection(name string, schema *Schema) (*Collection, error) {
+	return d.CreateShardedCollection(name, schema, 0)
+}
+
+// CreateShardedCollection creates a collection whose documents are spread
+// across shards binary data files (see Collection.SetShards) and logs it to
+// the WAL
+func (d *DB) CreateShardedCollection(name string, schema *Schema, shards int) (*Collection, error) {
+	if shards < 0 || shards > MaxShards {
+		return nil, fmt.Errorf("shard count must be between 0 and %d, got %d", MaxShards, shards)
+	}
+	if err := d.database.CreateCollection(name, schema); err != nil {
+		return nil, err
+	}
+
+	coll, err := d.database.GetCollection(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := coll.SetShards(shards); err != nil {
+		return nil, err
+	}
+
+	if err := d.storage.LogCreateCollection(d.database.Name, name, schema, "", shards); err != nil {
+		return nil, fmt.Errorf("failed to log create collection: %w", err)
+	}
+
+	return coll, nil
+}
+
+// GetOrCreateCollection gets a collection, creating it with schema and
+// logging that to the WAL if it doesn't exist
+func (d *DB) GetOrCreateCollection(name string, schema *Schema) (*Collection, error) {
+	coll, created, err := d.database.GetOrCreateCollection(name, schema)
+	if err != nil {
+		return nil, err
+	}
+
+	if created {
+		if err := d.storage.LogCreateCollection(d.database.Name, name, schema, "", 0); err != nil {
+			return nil, fmt.Errorf("failed to log create collection: %w", err)
+		}
+	}
+
+	return coll, nil
+}
+
+// SetQueryLimits sets a collection's default and maximum Find limit and logs it to the WAL
+func (d *DB) SetQueryLimits(collName string, limits QueryLimits) error {
+	coll, err := d.database.GetCollection(collName)
+	if err != nil {
+		return err
+	}
+
+	if err := coll.SetQueryLimits(limits); err != nil {
+		return err
+	}
+
+	if err := d.storage.LogSetQueryLimits(d.database.Name, collName, limits); err != nil {
+		return fmt.Errorf("failed to log query limits: %w", err)
+	}
+
+	return nil
+}
+
+// SetIDKey sets the key a collection derives the IDs of inserted documents
+// from (nil for random UUIDs) and logs it to the WAL
+func (d *DB) SetIDKey(collName string, key *IDKey) error {
+	coll, err := d.database.GetCollection(collName)
+	if err != nil {
+		return err
+	}
+
+	if err := coll.SetIDKey(key); err != nil {
+		return err
+	}
+
+	if err := d.storage.LogSetIDKey(d.database.Name, collName, key); err != nil {
+		return fmt.Errorf("failed to log ID key: %w", err)
+	}
+
+	return nil
+}
+
+// SetCollation sets how a collection orders strings when sorting and in
+// range filters and logs it to the WAL
+func (d *DB) SetCollation(collName string, collation Collation) error {
+	coll, err := d.database.GetCollection(collName)
+	if err != nil {
+		return err
+	}
+
+	if err := coll.SetCollation(collation); err != nil {
+		return err
+	}
+
+	if err := d.storage.LogSetCollation(d.database.Name, collName, collation); err != nil {
+		return fmt.Errorf("failed to log collation: %w", err)
+	}
+
+	return nil
+}
+
+// SetInsertionOrder turns insertion order tracking of a collection on or
+// off and logs it to the WAL
+func (d *DB) SetInsertionOrder(collName string, enabled bool) error {
+	coll, err := d.database.GetCollection(collName)
+	if err != nil {
+		return err
+	}
+
+	if err := coll.SetInsertionOrder(enabled); err != nil {
+		return err
+	}
+
+	if err := d.storage.LogSetInsertionOrder(d.database.Name, collName, enabled); err != nil {
+		return fmt.Errorf("failed to log insertion order: %w", err)
+	}
+
+	return nil
+}
+
+// Insert inserts a document into a collection and logs it to the WAL.
+// It returns a copy of the stored document, including its assigned ID.
+func (d *DB) Insert(collName string, doc *Document, opts ...WriteOption) (*Document, error) {
+	coll, err := d.database.GetCollection(collName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := d.storage.WaitForWriteCapacity(context.Background()); err != nil {
+		return nil, err
+	}
+
+	stored, err := coll.InsertReturning(doc, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := d.storage.LogInsert(d.database.Name, collName, stored); err != nil {
+		return nil, fmt.Errorf("failed to log insert: %w", err)
+	}
+
+	return stored, nil
+}
+
+// Upsert inserts or replaces a document, like Collection.Upsert, and logs it
+// to the WAL
+func (d *DB) Upsert(collName string, doc *Document, opts ...WriteOption) (*WriteResult, error) {
+	coll, err := d.database.GetCollection(collName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := d.storage.WaitForWriteCapacity(context.Background()); err != nil {
+		return nil, err
+	}
+
+	result, err := coll.Upsert(doc, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := coll.FindByID(doc.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get upserted document: %w", err)
+	}
+	if result.Inserted > 0 {
+		err = d.storage.LogInsert(d.database.Name, collName, stored)
+	} else {
+		err = d.storage.LogUpdate(d.database.Name, collName, stored)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to log upsert: %w", err)
+	}
+
+	return result, nil
+}
+
+// Update updates a document and logs it to the WAL
+func (d *DB) Update(collName, id string, updates map[string]any, opts ...WriteOption) error {
+	coll, err := d.database.GetCollection(collName)
+	if err != nil {
+		return err
+	}
+
+	if err := d.storage.WaitForWriteCapacity(context.Background()); err != nil {
+		return err
+	}
+
+	if err := coll.Update(id, updates, opts...); err != nil {
+		return err
+	}
+
+	updated, err := coll.FindByID(id)
+	if err != nil {
+		return fmt.Errorf("failed to get updated document: %w", err)
+	}
+
+	if err := d.storage.LogUpdate(d.database.Name, collName, updated); err != nil {
+		return fmt.Errorf("failed to log update: %w", err)
+	}
+
+	return nil
+}
+
+// ConditionalUpdate updates a document if it matches cond, like
+// Collection.ConditionalUpdate, and logs it to the WAL when it was applied
+func (d *DB) ConditionalUpdate(collName, id string, cond []QueryFilter, updates map[string]any, opts ...WriteOption) (bool, error) {
+	coll, err := d.database.GetCollection(collName)
+	if err != nil {
+		return false, err
+	}
+
+	if err := d.storage.WaitForWriteCapacity(context.Background()); err != nil {
+		return false, err
+	}
+
+	applied, err := coll.ConditionalUpdate(id, cond, updates, opts...)
+	if err != nil || !applied {
+		return false, err
+	}
+
+	updated, err := coll.FindByID(id)
+	if err != nil {
+		return true, fmt.Errorf("failed to get updated document: %w", err)
+	}
+
+	if err := d.storage.LogUpdate(d.database.Name, collName, updated); err != nil {
+		return true, fmt.Errorf("failed to log update: %w", err)
+	}
+
+	return true, nil
+}
+
+// Delete deletes a document and logs it to the WAL
+func (d *DB) Delete(collName, id string) error {
+	coll, err := d.database.GetCollection(collName)
+	if err != nil {
+		return err
+	}
+
+	if err := d.storage.WaitForWriteCapacity(context.Background()); err != nil {
+		return err
+	}
+
+	if err := coll.Delete(id); err != nil {
+		return err
+	}
+
+	if err := d.storage.LogDelete(d.database.Name, collName, id); err != nil {
+		return fmt.Errorf("failed to log delete: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateMany updates the documents matching filters, like
+// Collection.UpdateMany, and logs them to the WAL as one batch
+func (d *DB) UpdateMany(collName string, filters []QueryFilter, updates map[string]any, opts ...WriteOption) (*WriteResult, error) {
+	coll, err := d.database.GetCollection(collName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := d.storage.WaitForWriteCapacity(context.Background()); err != nil {
+		return nil, err
+	}
+
+	changes, err := coll.updateMany(filters, updates, newWriteOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := d.logChanges(collName, BatchUpdate, changes); err != nil {
+		return nil, err
+	}
+	return changesResult(BatchUpdate, changes), nil
+}
+
+// DeleteMany deletes the documents matching filters, like
+// Collection.DeleteMany, and logs them to the WAL as one batch
+func (d *DB) DeleteMany(collName string, filters []QueryFilter) (*WriteResult, error) {
+	coll, err := d.database.GetCollection(collName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := d.storage.WaitForWriteCapacity(context.Background()); err != nil {
+		return nil, err
+	}
+
+	changes, err := coll.deleteMany(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := d.logChanges(collName, BatchDelete, changes); err != nil {
+		return nil, err
+	}
+	return changesResult(BatchDelete, changes), nil
+}
+
+// logChanges logs the changes of one bulk operation to the WAL as a batch
+func (d *DB) logChanges(collName, op string, changes []batchChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	results := make([]BatchResult, len(changes))
+	for i, change := range changes {
+		results[i] = BatchResult{Op: op, Collection: collName, ID: change.before.ID}
+		if change.after != nil {
+			results[i].Document = change.after
+		}
+	}
+	return d.storage.LogBatch(d.database.Name, results)
+}
+
+// Batch applies the operations as a single unit and logs them to the WAL.
+// If any operation fails, none of them are applied.
+func (d *DB) Batch(ops []BatchOp) ([]BatchResult, error) {
+	if err := d.storage.WaitForWriteCapacity(context.Background()); err != nil {
+		return nil, err
+	}
+
+	results, err := d.database.ApplyBatch(ops)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := d.storage.LogBatch(d.database.Name, results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// InsertMany inserts documents into a collection as one batch: all of them
+// are inserted, or none if one fails. Documents and their index entries are
+// changed together under the collection's write lock, and a failure rolls
+// both back, so readers never see index entries for documents that aren't
+// stored. They are logged to the WAL together with a single sync. The
+// result lists the IDs of the documents, assigned ones included, in order.
+func (d *DB) InsertMany(collName string, docs []*Document, opts ...WriteOption) (*WriteResult, error) {
+	o := newWriteOptions(opts)
+	ops := make([]BatchOp, len(docs))
+	for i, doc := range docs {
+		ops[i] = BatchOp{Op: BatchInsert, Collection: collName, ID: doc.ID, Document: doc.Data, SkipValidation: o.skipValidation}
+	}
+
+	results, err := d.Batch(ops)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewWriteResult(results), nil
+}
+
+// Flush saves the whole database and checkpoints the WAL, so everything
+// written so far survives a crash without needing replay
+func (d *DB) Flush() error {
+	if d.storage.ReadOnly() {
+		return nil
+	}
+
+	if err := d.storage.SaveDatabase(d.database); err != nil {
+		return fmt.Errorf("failed to save database: %w", err)
+	}
+
+	return d.storage.Flush(true)
+}
+
+// Close flushes the database and releases the storage manager and WAL.
+// With WithWALOnly, only the WAL is flushed; call Flush first to save data.
+func (d *DB) Close() error {
+	var flushErr error
+	if d.storage.WALOnly() {
+		flushErr = d.storage.Flush(false)
+	} else {
+		flushErr = d.Flush()
+	}
+	if err := d.storage.Close(); err != nil {
+		return errors.Join(flushErr, fmt.Errorf("failed to close storage: %w", err))
+	}
+	return flushErr
+}
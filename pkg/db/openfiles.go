@@ -0,0 +1,59 @@
+package db
+
+import "fmt"
+
+// WithMaxOpenFiles bounds how many collection data files loads and saves
+// keep open at once, a sharded collection having one per shard. Collections
+// are loaded in parallel and so are their shards, so without a limit up to
+// the square of WithLoadConcurrency files can be open while a database with
+// many sharded collections loads. Past the limit, a load or save waits for
+// another to close its file. A save that upgrades or compacts a data file
+// briefly has the rewritten copy open as well. The WAL and index files are
+// not counted. 0 disables the limit, which is the default.
+func WithMaxOpenFiles(limit int) StorageOption {
+	return func(sm *StorageManager) {
+		sm.maxOpenFiles = limit
+	}
+}
+
+// validateMaxOpenFiles checks the WithMaxOpenFiles limit and sets up the
+// slots it hands out
+func (sm *StorageManager) validateMaxOpenFiles() error {
+	if sm.maxOpenFiles < 0 {
+		return fmt.Errorf("invalid max open files %d", sm.maxOpenFiles)
+	}
+	if sm.maxOpenFiles > 0 {
+		sm.fileSlots = make(chan struct{}, sm.maxOpenFiles)
+	}
+	return nil
+}
+
+// OpenFiles returns how many collection data files loads and saves have
+// open right now
+func (sm *StorageManager) OpenFiles() int {
+	return int(sm.openFiles.Load())
+}
+
+// acquireFile takes a slot for a data file about to be opened, waiting for
+// one to be free under WithMaxOpenFiles. The caller calls releaseFile once
+// the file is closed.
+func (sm *StorageManager) acquireFile() {
+	if sm.fileSlots != nil {
+		sm.fileSlots <- struct{}{}
+	}
+	open := sm.openFiles.Add(1)
+	for {
+		peak := sm.peakOpenFiles.Load()
+		if open <= peak || sm.peakOpenFiles.CompareAndSwap(peak, open) {
+			return
+		}
+	}
+}
+
+// releaseFile frees the slot taken by acquireFile
+func (sm *StorageManager) releaseFile() {
+	sm.openFiles.Add(-1)
+	if sm.fileSlots != nil {
+		<-sm.fileSlots
+	}
+}
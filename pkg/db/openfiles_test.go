@@ -0,0 +1,53 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMaxOpenFiles(t *testing.T) {
+	const collections, shards, docs = 8, 4, 10
+	dir := t.TempDir()
+	d := openTestDB(t, dir)
+	for c := range collections {
+		name := fmt.Sprintf("c%d", c)
+		if _, err := d.CreateShardedCollection(name, nil, shards); err != nil {
+			t.Fatal(err)
+		}
+		for i := range docs {
+			mustInsert(t, d, name, fmt.Sprintf("d%d", i), map[string]any{"n": i})
+		}
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Far more data files than the limit, loaded by more workers than it
+	const limit = 2
+	d = openTestDB(t, dir, WithMaxOpenFiles(limit), WithLoadConcurrency(8))
+	defer d.Close()
+	for c := range collections {
+		coll := mustCollection(t, d, fmt.Sprintf("c%d", c))
+		if n := coll.Count(); n != docs {
+			t.Errorf("%s has %d documents, want %d", coll.Name, n, docs)
+		}
+		if err := d.Update(coll.Name, "d0", map[string]any{"n": -1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	sm := d.Storage()
+	if peak := sm.peakOpenFiles.Load(); peak < 1 || peak > limit {
+		t.Errorf("at most %d data files were open at once, want 1 to %d", peak, limit)
+	}
+	if n := sm.OpenFiles(); n != 0 {
+		t.Errorf("OpenFiles = %d after loading and saving, want 0", n)
+	}
+
+	if _, err := NewStorageManager(t.TempDir(), WithMaxOpenFiles(-1)); err == nil {
+		t.Error("negative max open files accepted")
+	}
+}
@@ -0,0 +1,162 @@
+package db
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+)
+
+// insertionOrderFile holds the IDs of a collection's documents in insertion
+// order, for collections that track it
+const insertionOrderFile = "order.json"
+
+// SetInsertionOrder turns tracking of the order documents were inserted in
+// on or off. While on, queries without a sort (Find, FindIter, aggregation
+// pipelines) return documents in insertion order instead of an unspecified
+// one; updates and upserts of a document keep its place, while deleting and
+// inserting it again moves it last. Documents already in the collection when
+// it is turned on are ordered by ID. It is off by default, since it costs a
+// sequence number per document and a sort per query.
+func (c *Collection) SetInsertionOrder(enabled bool) error {
+	if err := c.lock(); err != nil {
+		return err
+	}
+	defer c.mu.Unlock()
+
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	if enabled == (c.order != nil) {
+		return nil
+	}
+
+	if enabled {
+		ids := make([]string, 0, len(c.Documents))
+		for id := range c.Documents {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		c.setOrderLocked(ids)
+	} else {
+		c.order, c.orderNext = nil, 0
+	}
+	c.modCount++
+	return nil
+}
+
+// InsertionOrder reports whether the collection tracks insertion order
+func (c *Collection) InsertionOrder() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.order != nil
+}
+
+// setOrderLocked starts tracking insertion order with the documents in ids
+// inserted in that order, followed by any others by ID (caller must hold mu)
+func (c *Collection) setOrderLocked(ids []string) {
+	c.order = make(map[string]uint64, len(c.Documents))
+	c.orderNext = 0
+	for _, id := range ids {
+		if _, exists := c.Documents[id]; exists {
+			c.trackInsertLocked(id)
+		}
+	}
+
+	var rest []string
+	for id := range c.Documents {
+		if _, tracked := c.order[id]; !tracked {
+			rest = append(rest, id)
+		}
+	}
+	sort.Strings(rest)
+	for _, id := range rest {
+		c.trackInsertLocked(id)
+	}
+}
+
+// trackInsertLocked places an inserted document last in insertion order,
+// if the collection tracks it (caller must hold mu)
+func (c *Collection) trackInsertLocked(id string) {
+	if c.order == nil {
+		return
+	}
+	if _, tracked := c.order[id]; tracked {
+		return
+	}
+	c.order[id] = c.orderNext
+	c.orderNext++
+}
+
+// orderOfLocked returns a document's position in insertion order, 0 if the
+// collection doesn't track it (caller must hold mu)
+func (c *Collection) orderOfLocked(id string) uint64 {
+	return c.order[id]
+}
+
+// restoreOrderLocked puts a document replaced by a delete and an insert,
+// or restored by a rollback, back at its position (caller must hold mu)
+func (c *Collection) restoreOrderLocked(id string, position uint64) {
+	if c.order != nil {
+		c.order[id] = position
+	}
+}
+
+// sortByInsertionLocked sorts docs in insertion order, if the collection
+// tracks it (caller must hold mu or own docs)
+func (c *Collection) sortByInsertionLocked(docs []*Document) {
+	if c.order == nil {
+		return
+	}
+	slices.SortFunc(docs, func(a, b *Document) int {
+		return cmp.Compare(c.order[a.ID], c.order[b.ID])
+	})
+}
+
+// insertionOrderIDsLocked returns the IDs of the documents in insertion
+// order, nil if the collection doesn't track it (caller must hold mu)
+func (c *Collection) insertionOrderIDsLocked() []string {
+	if c.order == nil {
+		return nil
+	}
+	ids := make([]string, 0, len(c.order))
+	for id := range c.order {
+		ids = append(ids, id)
+	}
+	slices.SortFunc(ids, func(a, b string) int {
+		return cmp.Compare(c.order[a], c.order[b])
+	})
+	return ids
+}
+
+// saveInsertionOrder writes the IDs of a collection's documents in insertion
+// order to its directory, or removes the file if ids is nil
+func (sm *StorageManager) saveInsertionOrder(collDir string, ids []string) error {
+	path := filepath.Join(collDir, insertionOrderFile)
+	if ids == nil {
+		for _, p := range []string{path, path + JSONChecksumExt} {
+			if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove insertion order: %w", err)
+			}
+		}
+		return nil
+	}
+
+	if err := sm.writeJSON(path, ids); err != nil {
+		return fmt.Errorf("failed to save insertion order: %w", err)
+	}
+	return nil
+}
+
+// loadInsertionOrder reads the IDs written by saveInsertionOrder; without
+// the file, documents are ordered by ID
+func (sm *StorageManager) loadInsertionOrder(collDir string) ([]string, error) {
+	var ids []string
+	err := sm.readJSON(filepath.Join(collDir, insertionOrderFile), &ids)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to load insertion order: %w", err)
+	}
+	return ids, nil
+}
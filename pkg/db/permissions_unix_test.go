@@ -0,0 +1,96 @@
+//go:build unix
+
+package db
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestWriteFileAtomicMode(t *testing.T) {
+	oldMask := syscall.Umask(0o022)
+	defer syscall.Umask(oldMask)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.json")
+	write := func(w io.Writer) error {
+		_, err := io.WriteString(w, "{}")
+		return err
+	}
+
+	if err := writeFileAtomic(path, 0o600, write); err != nil {
+		t.Fatal(err)
+	}
+	assertMode(t, path, 0o600)
+
+	// The umask still applies to the mode of new files
+	masked := filepath.Join(dir, "masked.json")
+	if err := writeFileAtomic(masked, 0o666, write); err != nil {
+		t.Fatal(err)
+	}
+	assertMode(t, masked, 0o644)
+
+	// Rewrites keep the mode of the file they replace
+	if err := os.Chmod(path, 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(path, 0o600, write); err != nil {
+		t.Fatal(err)
+	}
+	assertMode(t, path, 0o640)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if isTempFile(entry.Name()) {
+			t.Errorf("temporary file %s left behind", entry.Name())
+		}
+	}
+}
+
+func TestStorageFilePermissions(t *testing.T) {
+	oldMask := syscall.Umask(0o022)
+	defer syscall.Umask(oldMask)
+
+	dir := t.TempDir()
+	d := openTestDB(t, dir, WithFilePermissions(FilePermissions{FileMode: 0o600, DirMode: 0o700}))
+	if _, err := d.CreateCollection("items", &Schema{Fields: map[string]Field{"n": {Type: TypeNumber, Index: true}}}); err != nil {
+		t.Fatal(err)
+	}
+	mustInsert(t, d, "items", "a", map[string]any{"n": 1})
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	err := filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+		if entry.IsDir() {
+			assertMode(t, path, 0o700)
+		} else {
+			assertMode(t, path, 0o600)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// assertMode checks the permission bits of the file at path
+func assertMode(t *testing.T, path string, want os.FileMode) {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := info.Mode().Perm(); got != want {
+		t.Errorf("%s has mode %o, want %o", filepath.Base(path), got, want)
+	}
+}
@@ -0,0 +1,188 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Projection shapes output rows. Each key is an output field and its value
+// one of:
+//
+//	true or 1           include the field of the same name
+//	false or 0          exclude the field
+//	"$field.path"       the value at a field path, following nested objects
+//	{"$concat": [...]}  the expressions concatenated as strings
+//	{"$literal": v}     the value v as is
+//
+// Any other value is used as a literal. Exclusions can't be mixed with other
+// entries, except for excluding "_id", which is otherwise always included.
+type Projection map[string]any
+
+// Validate checks that the projection is well formed
+func (p Projection) Validate() error {
+	excludes, others := 0, 0
+	for field, spec := range p {
+		if field == "" {
+			return fmt.Errorf("projection field name must not be empty")
+		}
+		if include, ok := projectionFlag(spec); ok {
+			if include {
+				others++
+			} else if field != "_id" {
+				excludes++
+			}
+			continue
+		}
+		if err := validateExpression(spec); err != nil {
+			return fmt.Errorf("projection field '%s': %w", field, err)
+		}
+		others++
+	}
+
+	if excludes > 0 && others > 0 {
+		return fmt.Errorf("projection cannot mix exclusions with other fields")
+	}
+
+	return nil
+}
+
+// Apply returns a new row shaped by the projection
+func (p Projection) Apply(row map[string]any) (map[string]any, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	return p.apply(row), nil
+}
+
+// apply shapes a row with an already validated projection
+func (p Projection) apply(row map[string]any) map[string]any {
+	excludeID := false
+	exclusion := false
+	for field, spec := range p {
+		if include, ok := projectionFlag(spec); ok && !include {
+			if field == "_id" {
+				excludeID = true
+			} else {
+				exclusion = true
+			}
+		}
+	}
+
+	out := make(map[string]any)
+	if exclusion {
+		for k, v := range row {
+			if _, excluded := p[k]; !excluded {
+				out[k] = v
+			}
+		}
+		return out
+	}
+
+	if id, ok := row["_id"]; ok && !excludeID {
+		out["_id"] = id
+	}
+	for field, spec := range p {
+		if include, ok := projectionFlag(spec); ok {
+			if include {
+				if value, exists := lookupPath(row, field); exists {
+					out[field] = value
+				}
+			}
+			continue
+		}
+		out[field] = evalExpression(row, spec)
+	}
+
+	return out
+}
+
+// projectionFlag reports whether spec is an include/exclude flag and which one
+func projectionFlag(spec any) (include bool, ok bool) {
+	switch v := spec.(type) {
+	case bool:
+		return v, true
+	case string, nil, map[string]any:
+		return false, false
+	}
+	if f, isNum := toFloat(spec); isNum && (f == 0 || f == 1) {
+		return f == 1, true
+	}
+	return false, false
+}
+
+// validateExpression checks that an expression only uses supported operators
+func validateExpression(expr any) error {
+	obj, ok := expr.(map[string]any)
+	if !ok {
+		return nil
+	}
+	if len(obj) != 1 {
+		return fmt.Errorf("expression object must have exactly one operator")
+	}
+
+	for op, arg := range obj {
+		switch op {
+		case "$literal":
+		case "$concat":
+			parts, ok := arg.([]any)
+			if !ok {
+				return fmt.Errorf("$concat expects an array")
+			}
+			for _, part := range parts {
+				if err := validateExpression(part); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("unsupported expression operator '%s'", op)
+		}
+	}
+
+	return nil
+}
+
+// evalExpression evaluates a validated expression against a row.
+// Field references to missing fields evaluate to nil, as does $concat when
+// any of its parts is nil.
+func evalExpression(row map[string]any, expr any) any {
+	switch v := expr.(type) {
+	case string:
+		if strings.HasPrefix(v, "$") {
+			value, _ := lookupPath(row, v[1:])
+			return value
+		}
+		return v
+	case map[string]any:
+		if literal, ok := v["$literal"]; ok {
+			return literal
+		}
+		var sb strings.Builder
+		for _, part := range v["$concat"].([]any) {
+			value := evalExpression(row, part)
+			if value == nil {
+				return nil
+			}
+			fmt.Fprintf(&sb, "%v", value)
+		}
+		return sb.String()
+	default:
+		return v
+	}
+}
+
+// lookupPath returns the value at a dot-separated field path
+func lookupPath(row map[string]any, path string) (any, bool) {
+	var current any = row
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := current.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		current, ok = obj[key]
+		if !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
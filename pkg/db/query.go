@@ -1,180 +1,541 @@
 package db
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"iter"
+	"math"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
 
 // Insert inserts a document into the collection
-func (c *Collection) Insert(doc *Document) error {
-	c.mu.Lock()
+func (c *Collection) Insert(doc *Document, opts ...WriteOption) error {
+	if err := c.lock(); err != nil {
+		return err
+	}
 	defer c.mu.Unlock()
 
-	// Generate ID if not provided
+	return c.insertLocked(doc, newWriteOptions(opts))
+}
+
+// InsertReturning inserts a document and returns a clone of the stored
+// document, including the server-assigned ID
+func (c *Collection) InsertReturning(doc *Document, opts ...WriteOption) (*Document, error) {
+	if err := c.lock(); err != nil {
+		return nil, err
+	}
+	defer c.mu.Unlock()
+
+	if err := c.insertLocked(doc, newWriteOptions(opts)); err != nil {
+		return nil, err
+	}
+
+	return doc.Clone(), nil
+}
+
+// Upsert stores a document, replacing the document with the same ID if there
+// is one. The result counts it as inserted, with its ID in UpsertedIDs, or
+// as matched and modified. The ID is the document's own or, without one,
+// derived from the collection's ID key, so upserting records with the same
+// key fields always lands on one document. The existence check and the
+// write happen under one write lock: concurrent upserts of the same key
+// insert once and replace after that.
+func (c *Collection) Upsert(doc *Document, opts ...WriteOption) (*WriteResult, error) {
+	if err := c.lock(); err != nil {
+		return nil, err
+	}
+	defer c.mu.Unlock()
+
+	if c.readOnly {
+		return nil, ErrReadOnly
+	}
+	if doc.ID == "" && c.IDKey != nil {
+		id, err := c.IDKey.DeriveID(doc)
+		if err != nil {
+			return nil, err
+		}
+		doc.ID = id
+	}
 	if doc.ID == "" {
+		return nil, fmt.Errorf("upsert needs a document ID or an ID key on collection '%s'", c.Name)
+	}
+
+	existing, exists := c.Documents[doc.ID]
+	position := c.orderOfLocked(doc.ID)
+	if exists {
+		if err := c.deleteLocked(doc.ID); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.insertLocked(doc, newWriteOptions(opts)); err != nil {
+		if exists {
+			c.revertLocked(batchChange{before: existing, position: position})
+		}
+		return nil, err
+	}
+	if exists {
+		c.restoreOrderLocked(doc.ID, position)
+	}
+
+	result := &WriteResult{}
+	if exists {
+		result.record(BatchUpdate, doc.ID)
+	} else {
+		result.record(BatchInsert, doc.ID)
+		result.UpsertedIDs = []string{doc.ID}
+	}
+	return result, nil
+}
+
+// insertLocked inserts a document (caller must hold mu)
+func (c *Collection) insertLocked(doc *Document, opts writeOptions) error {
+	if c.readOnly {
+		return ErrReadOnly
+	}
+
+	if err := checkReservedFields(doc.Data); err != nil {
+		return err
+	}
+
+	// Generate ID if not provided
+	if doc.ID == "" && c.IDKey != nil {
+		id, err := c.IDKey.DeriveID(doc)
+		if err != nil {
+			return err
+		}
+		doc.ID = id
+	} else if doc.ID == "" {
 		doc.ID = uuid.New().String()
 	}
 
 	// Check if document already exists
 	if _, exists := c.Documents[doc.ID]; exists {
-		return fmt.Errorf("document with ID '%s' already exists", doc.ID)
+		return fmt.Errorf("document with ID '%s' %w (%w)", doc.ID, ErrAlreadyExists, ErrDuplicateKey)
 	}
 
 	// Validate against schema
-	if c.Schema != nil {
+	if c.Schema != nil && !opts.skipValidation {
 		if err := c.Schema.ValidateDocument(doc); err != nil {
-			return fmt.Errorf("schema validation failed: %w", err)
+			return fmt.Errorf("%w: %w", ErrSchemaValidation, err)
+		}
+	}
+	if !opts.skipUnique {
+		if err := c.checkUniqueLocked(doc); err != nil {
+			return err
 		}
 	}
 
-	// Add document
-	c.Documents[doc.ID] = doc
+	// Store a copy: stored documents are never modified, as readers don't
+	// lock them, and the caller may keep setting fields of its own
+	stored := doc.Clone()
+	c.Documents[stored.ID] = stored
 
 	// Update indexes
-	if err := c.updateIndexes(nil, doc); err != nil {
-		delete(c.Documents, doc.ID)
+	if err := c.updateIndexes(nil, stored); err != nil {
+		delete(c.Documents, stored.ID)
 		return fmt.Errorf("failed to update indexes: %w", err)
 	}
 
+	c.trackInsertLocked(stored.ID)
+	c.memSize += docMemSize(stored)
+	c.modCount++
 	return nil
 }
 
 // FindByID finds a document by ID
 func (c *Collection) FindByID(id string) (*Document, error) {
-	c.mu.RLock()
+	if err := c.rlock(); err != nil {
+		return nil, err
+	}
 	defer c.mu.RUnlock()
 
 	doc, exists := c.Documents[id]
 	if !exists {
-		return nil, fmt.Errorf("document with ID '%s' not found", id)
+		return nil, fmt.Errorf("document with ID '%s' %w", id, ErrNotFound)
 	}
 
 	return doc.Clone(), nil
 }
 
-// Find finds documents matching a query
-func (c *Collection) Find(query *Query) ([]*Document, error) {
-	c.mu.RLock()
+// Exists reports whether the collection holds a document with an ID. Unlike
+// FindByID it neither clones the document nor builds an error when it is
+// missing. It reports false if an evicted collection fails to reload.
+func (c *Collection) Exists(id string) bool {
+	if err := c.rlock(); err != nil {
+		return false
+	}
 	defer c.mu.RUnlock()
 
+	_, exists := c.Documents[id]
+	return exists
+}
+
+// Find finds documents matching a query.
+// Candidate documents are collected under the read lock; filtering and
+// cloning happen after it is released, which is safe because stored
+// documents are never modified in place.
+func (c *Collection) Find(query *Query) ([]*Document, error) {
+	return c.FindContext(context.Background(), query)
+}
+
+// FindContext is like Find but stops with an error wrapping ctx.Err() once
+// ctx is done. ctx is checked while filtering, not during the final sort.
+func (c *Collection) FindContext(ctx context.Context, query *Query) ([]*Document, error) {
 	results := make([]*Document, 0)
+	for doc, err := range c.FindIter(ctx, query) {
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, doc)
+	}
+	return results, nil
+}
 
-	// If no filters, return all documents
-	if len(query.Filters) == 0 {
-		for _, doc := range c.Documents {
-			results = append(results, doc.Clone())
+// FindIter returns the results of FindContext one document at a time, cloning
+// each only when it is yielded. Without sort fields, documents are yielded as
+// they are matched; with them, all matches are collected and sorted first.
+// An error is yielded with a nil document and ends the iteration.
+func (c *Collection) FindIter(ctx context.Context, query *Query) iter.Seq2[*Document, error] {
+	return c.FindIterWithStats(ctx, query, nil)
+}
+
+// QueryStats reports how a query actually ran, unlike Explain which only
+// plans it
+type QueryStats struct {
+	Index    string        `json:"index,omitempty"` // Index used to find candidates ("_id" for a lookup by ID); empty for a full scan
+	Examined int           `json:"examined"`        // Documents the filters were checked on
+	Returned int           `json:"returned"`        // Documents returned, after skip and limit
+	Elapsed  time.Duration `json:"elapsed"`
+}
+
+// FindWithStats is like Find but also returns the stats of the run
+func (c *Collection) FindWithStats(query *Query) ([]*Document, *QueryStats, error) {
+	return c.FindWithStatsContext(context.Background(), query)
+}
+
+// FindWithStatsContext is like FindContext but also returns the stats of the run
+func (c *Collection) FindWithStatsContext(ctx context.Context, query *Query) ([]*Document, *QueryStats, error) {
+	stats := &QueryStats{}
+	results := make([]*Document, 0)
+	for doc, err := range c.FindIterWithStats(ctx, query, stats) {
+		if err != nil {
+			return nil, nil, err
 		}
-	} else {
-		// Try to use index for first filter if possible
-		firstFilter := query.Filters[0]
-		if firstFilter.Operator == "eq" {
-			// Check if there's an index for this field
-			var candidateDocs []*Document
-			indexFound := false
-
-			for _, idx := range c.Indexes {
-				if idx.FieldName == firstFilter.Field {
-					docID, found := idx.Find(firstFilter.Value)
-					if found {
-						if doc, exists := c.Documents[docID]; exists {
-							candidateDocs = []*Document{doc}
-							indexFound = true
-							break
-						}
-					} else {
-						// Index exists but no match found
-						return results, nil
-					}
-				}
+		results = append(results, doc)
+	}
+	return results, stats, nil
+}
+
+// FindIterWithStats is like FindIter but fills in stats as the iteration
+// runs; they are complete once it ends. Elapsed includes the time the caller
+// spends between documents. A nil stats is ignored.
+func (c *Collection) FindIterWithStats(ctx context.Context, query *Query, stats *QueryStats) iter.Seq2[*Document, error] {
+	return func(yield func(*Document, error) bool) {
+		if stats == nil {
+			stats = &QueryStats{}
+		}
+		start := time.Now()
+		defer func() { stats.Elapsed = time.Since(start) }()
+
+		if err := query.Validate(); err != nil {
+			yield(nil, err)
+			return
+		}
+
+		candidateDocs, index, err := c.findCandidates(query.Filters)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		stats.Index = index
+		collation := c.GetCollation()
+
+		// send applies skip and limit, and reports whether to go on
+		limit := c.EffectiveLimit(query.Limit)
+		skipped, sent := 0, 0
+		send := func(doc *Document) bool {
+			if skipped < query.Skip {
+				skipped++
+				return true
+			}
+			if limit > 0 && sent >= limit {
+				return false
 			}
+			sent++
+			stats.Returned++
+			return yield(doc.Clone(), nil)
+		}
 
-			if !indexFound {
-				// No index, scan all documents
-				for _, doc := range c.Documents {
-					candidateDocs = append(candidateDocs, doc)
+		var matched []*Document
+		for i, doc := range candidateDocs {
+			if i%contextCheckInterval == 0 {
+				if err := checkContext(ctx); err != nil {
+					yield(nil, err)
+					return
 				}
 			}
+			stats.Examined++
+			if !matchesAllFilters(doc, query.Filters, collation) || !query.Where.matches(doc, collation) {
+				continue
+			}
+			if len(query.Sort) > 0 {
+				matched = append(matched, doc)
+			} else if !send(doc) {
+				return
+			}
+		}
+		if len(query.Sort) == 0 {
+			return
+		}
 
-			// Apply all filters
-			for _, doc := range candidateDocs {
-				if matchesAllFilters(doc, query.Filters) {
-					results = append(results, doc.Clone())
+		if err := checkContext(ctx); err != nil {
+			yield(nil, err)
+			return
+		}
+		sortDocuments(matched, query.Sort, collation)
+		for i, doc := range matched {
+			if i%contextCheckInterval == 0 {
+				if err := checkContext(ctx); err != nil {
+					yield(nil, err)
+					return
 				}
 			}
-		} else {
-			// Non-equality first filter, scan all documents
-			for _, doc := range c.Documents {
-				if matchesAllFilters(doc, query.Filters) {
-					results = append(results, doc.Clone())
-				}
+			if !send(doc) {
+				return
 			}
 		}
 	}
+}
+
+// Range returns the documents whose field lies between low and high, ordered
+// by the field and then by ID. A nil bound leaves that side open; inclusive
+// decides whether values equal to a bound are included. Values compare as in
+// gt/lt filters, numerically when both are numbers and otherwise as strings
+// under the collection's collation, and null or missing fields never match.
+//
+// Indexes are hash indexes without key order, so Range scans the collection.
+func (c *Collection) Range(field string, low, high any, inclusive bool) ([]*Document, error) {
+	if field == "" {
+		return nil, fmt.Errorf("%w: range field is required", ErrInvalidQuery)
+	}
 
-	// Apply skip and limit
-	if query.Skip > 0 {
-		if query.Skip >= len(results) {
-			return []*Document{}, nil
+	docs, err := c.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	collation := c.GetCollation()
+
+	inRange := func(value any) bool {
+		if low != nil {
+			if cmp := collation.compareValues(value, low); cmp < 0 || (cmp == 0 && !inclusive) {
+				return false
+			}
+		}
+		if high != nil {
+			if cmp := collation.compareValues(value, high); cmp > 0 || (cmp == 0 && !inclusive) {
+				return false
+			}
 		}
-		results = results[query.Skip:]
+		return true
 	}
 
-	if query.Limit > 0 && query.Limit < len(results) {
-		results = results[:query.Limit]
+	results := make([]*Document, 0)
+	for _, doc := range docs {
+		if value, ok := doc.GetValue(field); ok && value != nil && inRange(value) {
+			results = append(results, doc.Clone())
+		}
 	}
 
+	sort.Slice(results, func(i, j int) bool {
+		a, _ := results[i].GetValue(field)
+		b, _ := results[j].GetValue(field)
+		if order := collation.compareValues(a, b); order != 0 {
+			return order < 0
+		}
+		return results[i].ID < results[j].ID
+	})
+
 	return results, nil
 }
 
-// Update updates a document
-func (c *Collection) Update(id string, updates map[string]any) error {
-	c.mu.Lock()
+// contextCheckInterval is how many documents a scan goes through between
+// checks of its context
+const contextCheckInterval = 256
+
+// checkContext returns an error wrapping ctx.Err() once ctx is done
+func checkContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("query stopped: %w", err)
+	}
+	return nil
+}
+
+// findCandidates returns the documents that may match the filters, looking
+// the document up by ID or using the most selective index available (see
+// Explain), and the name of the index used ("_id" for an ID lookup, empty
+// for a full scan). Candidates are a superset of the matches: the caller
+// checks every filter on them, including the one used here, so several
+// filters on one field combine as AND whichever of them picks the candidates.
+func (c *Collection) findCandidates(filters []QueryFilter) ([]*Document, string, error) {
+	if err := c.rlock(); err != nil {
+		return nil, "", err
+	}
+	defer c.mu.RUnlock()
+
+	docs, index := c.findCandidatesLocked(filters)
+	return docs, index, nil
+}
+
+// findCandidatesLocked is findCandidates for a caller holding mu
+func (c *Collection) findCandidatesLocked(filters []QueryFilter) ([]*Document, string) {
+	if id, ok := idFilter(filters); ok {
+		if doc, exists := c.Documents[id]; exists {
+			return []*Document{doc}, "_id"
+		}
+		return []*Document{}, "_id"
+	}
+
+	if _, idx, filter := c.planLocked(filters); idx != nil {
+		// IDs come in ID order, so results are stable across runs
+		ids := idx.FindAll(filter.Value)
+		docs := make([]*Document, 0, len(ids))
+		for _, id := range ids {
+			if doc, exists := c.Documents[id]; exists {
+				docs = append(docs, doc)
+			}
+		}
+		c.sortByInsertionLocked(docs)
+		return docs, idx.Name
+	}
+
+	// No usable index, scan all documents
+	candidateDocs := make([]*Document, 0, len(c.Documents))
+	for _, doc := range c.Documents {
+		candidateDocs = append(candidateDocs, doc)
+	}
+	c.sortByInsertionLocked(candidateDocs)
+	return candidateDocs, ""
+}
+
+// snapshot returns the collection's documents. The documents are shared, not
+// cloned: stored documents are replaced rather than modified, so they can be
+// read without holding the lock.
+func (c *Collection) snapshot() ([]*Document, error) {
+	if err := c.rlock(); err != nil {
+		return nil, err
+	}
+	defer c.mu.RUnlock()
+
+	docs := make([]*Document, 0, len(c.Documents))
+	for _, doc := range c.Documents {
+		docs = append(docs, doc)
+	}
+	c.sortByInsertionLocked(docs)
+	return docs, nil
+}
+
+// Update updates a document. Updates replace top-level fields, or use the
+// $set and $unset operators to change nested fields by dot path.
+func (c *Collection) Update(id string, updates map[string]any, opts ...WriteOption) error {
+	if err := c.lock(); err != nil {
+		return err
+	}
+	defer c.mu.Unlock()
+
+	return c.updateLocked(id, updates, newWriteOptions(opts))
+}
+
+// ConditionalUpdate applies updates to a document only if it currently
+// matches all the filters in cond, checked and applied under the write lock
+// so that no other write can come in between. It reports whether the update
+// was applied; a document that doesn't match is left as is without an error.
+// A missing document fails with ErrNotFound.
+func (c *Collection) ConditionalUpdate(id string, cond []QueryFilter, updates map[string]any, opts ...WriteOption) (bool, error) {
+	if err := validateFilters(cond); err != nil {
+		return false, err
+	}
+	if err := c.lock(); err != nil {
+		return false, err
+	}
 	defer c.mu.Unlock()
 
 	doc, exists := c.Documents[id]
 	if !exists {
-		return fmt.Errorf("document with ID '%s' not found", id)
+		return false, fmt.Errorf("document with ID '%s' %w", id, ErrNotFound)
+	}
+	if !matchesAllFilters(doc, cond, c.Collation) {
+		return false, nil
 	}
 
-	oldDoc := doc.Clone()
+	if err := c.updateLocked(id, updates, newWriteOptions(opts)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
 
-	// Apply updates
-	for key, value := range updates {
-		if key == "_id" {
-			return fmt.Errorf("cannot update _id field")
-		}
-		doc.Data[key] = value
+// updateLocked updates a document (caller must hold mu)
+func (c *Collection) updateLocked(id string, updates map[string]any, opts writeOptions) error {
+	if c.readOnly {
+		return ErrReadOnly
+	}
+
+	oldDoc, exists := c.Documents[id]
+	if !exists {
+		return fmt.Errorf("document with ID '%s' %w", id, ErrNotFound)
+	}
+
+	// Apply updates to a copy; the stored document is replaced, never
+	// modified, so lock-free readers holding it see a consistent version
+	doc := oldDoc.Clone()
+	if err := applyUpdates(doc.Data, updates); err != nil {
+		return err
 	}
 
 	// Validate against schema
-	if c.Schema != nil {
+	if c.Schema != nil && !opts.skipValidation {
 		if err := c.Schema.ValidateDocument(doc); err != nil {
-			// Rollback
-			c.Documents[id] = oldDoc
-			return fmt.Errorf("schema validation failed: %w", err)
+			return fmt.Errorf("%w: %w", ErrSchemaValidation, err)
 		}
 	}
+	if err := c.checkUniqueLocked(doc); err != nil {
+		return err
+	}
 
 	// Update indexes
 	if err := c.updateIndexes(oldDoc, doc); err != nil {
-		// Rollback
-		c.Documents[id] = oldDoc
 		return fmt.Errorf("failed to update indexes: %w", err)
 	}
 
+	c.Documents[id] = doc
+	c.memSize += docMemSize(doc) - docMemSize(oldDoc)
+	c.modCount++
 	return nil
 }
 
 // Delete deletes a document by ID
 func (c *Collection) Delete(id string) error {
-	c.mu.Lock()
+	if err := c.lock(); err != nil {
+		return err
+	}
 	defer c.mu.Unlock()
 
+	return c.deleteLocked(id)
+}
+
+// deleteLocked deletes a document by ID (caller must hold mu)
+func (c *Collection) deleteLocked(id string) error {
+	if c.readOnly {
+		return ErrReadOnly
+	}
+
 	doc, exists := c.Documents[id]
 	if !exists {
-		return fmt.Errorf("document with ID '%s' not found", id)
+		return fmt.Errorf("document with ID '%s' %w", id, ErrNotFound)
 	}
 
 	// Update indexes
@@ -183,20 +544,241 @@ func (c *Collection) Delete(id string) error {
 	}
 
 	delete(c.Documents, id)
+	delete(c.order, id)
+	c.memSize -= docMemSize(doc)
+	c.modCount++
 	return nil
 }
 
-// Count returns the number of documents in the collection
-func (c *Collection) Count() int {
+// restore stores a document as logged in the WAL, replacing any document
+// with its ID, so replaying an entry whose change is already saved is
+// harmless. The document was accepted when logged, so it isn't validated
+// again; it may have been written with SkipValidation. Unique fields aren't
+// checked either: the saved data can already hold a later document that
+// took over the value, e.g. after a delete, until the entries in between
+// are replayed too.
+func (c *Collection) restore(doc *Document) error {
+	if err := c.lock(); err != nil {
+		return err
+	}
+	defer c.mu.Unlock()
+
+	existing, exists := c.Documents[doc.ID]
+	position := c.orderOfLocked(doc.ID)
+	if exists {
+		if err := c.deleteLocked(doc.ID); err != nil {
+			return err
+		}
+	}
+	if err := c.insertLocked(doc, writeOptions{skipValidation: true, skipUnique: true}); err != nil {
+		if exists {
+			c.revertLocked(batchChange{before: existing, position: position})
+		}
+		return err
+	}
+	if exists {
+		c.restoreOrderLocked(doc.ID, position)
+	}
+	return nil
+}
+
+// SetFormat sets the storage format used when this collection is saved.
+// An empty format falls back to the storage manager default.
+func (c *Collection) SetFormat(format StorageFormat) error {
+	switch format {
+	case "", FormatJSON, FormatBinary:
+	default:
+		return fmt.Errorf("invalid storage format '%s'", format)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Format = format
+	c.modCount++
+	return nil
+}
+
+// QueryLimits bounds the number of documents Find returns, so a query
+// without a limit can't return a whole large collection. Zero means unset.
+type QueryLimits struct {
+	Default int `json:"default,omitempty"` // Limit used when a query sets none
+	Max     int `json:"max,omitempty"`     // Largest limit a query may use
+}
+
+// Validate checks that the limits are non-negative and the default is within the maximum
+func (l QueryLimits) Validate() error {
+	if l.Default < 0 || l.Max < 0 {
+		return fmt.Errorf("query limits must not be negative")
+	}
+	if l.Max > 0 && l.Default > l.Max {
+		return fmt.Errorf("default limit %d exceeds max limit %d", l.Default, l.Max)
+	}
+	return nil
+}
+
+// Apply returns the limit to use for a query limit: the default when the
+// query sets none, capped at the maximum. 0 means unlimited.
+func (l QueryLimits) Apply(limit int) int {
+	if limit <= 0 {
+		limit = l.Default
+	}
+	if l.Max > 0 && (limit <= 0 || limit > l.Max) {
+		limit = l.Max
+	}
+	return limit
+}
+
+// SetQueryLimits sets the default and maximum limit applied by Find
+func (c *Collection) SetQueryLimits(limits QueryLimits) error {
+	if err := limits.Validate(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.readOnly {
+		return ErrReadOnly
+	}
+
+	c.Limits = limits
+	c.modCount++
+	return nil
+}
+
+// QueryLimits returns the collection's query limits
+func (c *Collection) QueryLimits() QueryLimits {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
+	return c.Limits
+}
+
+// EffectiveLimit returns the limit Find uses for a query with the given limit
+func (c *Collection) EffectiveLimit(limit int) int {
+	return c.QueryLimits().Apply(limit)
+}
+
+// Count returns the number of documents in the collection,
+// or 0 if an unloaded collection can't be loaded
+func (c *Collection) Count() int {
+	if err := c.rlock(); err != nil {
+		return 0
+	}
+	defer c.mu.RUnlock()
 	return len(c.Documents)
 }
 
+// sortDocuments sorts documents in place by the given fields, comparing
+// strings under collation. Missing and null values sort before all other
+// values.
+func sortDocuments(docs []*Document, fields []SortField, collation Collation) {
+	sort.SliceStable(docs, func(i, j int) bool {
+		for _, field := range fields {
+			a, _ := docs[i].GetValue(field.Field)
+			b, _ := docs[j].GetValue(field.Field)
+			order := collation.compareSortValues(a, b)
+			if order == 0 {
+				continue
+			}
+			if field.Desc {
+				return order > 0
+			}
+			return order < 0
+		}
+		return false
+	})
+}
+
+// Validate checks the query's filters, condition and sort fields. Errors
+// wrap ErrInvalidQuery.
+func (q *Query) Validate() error {
+	if err := validateFilters(q.Filters); err != nil {
+		return err
+	}
+	if q.Where != nil {
+		if err := q.Where.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, field := range q.Sort {
+		if field.Field == "" {
+			return fmt.Errorf("%w: sort field name must not be empty", ErrInvalidQuery)
+		}
+	}
+	return nil
+}
+
+// validateFilters checks filters that would otherwise silently never match
+func validateFilters(filters []QueryFilter) error {
+	for _, filter := range filters {
+		if filter.Field == "" {
+			return fmt.Errorf("%w: filter field name must not be empty", ErrInvalidQuery)
+		}
+		if err := validateFilterValue(filter); err != nil {
+			return fmt.Errorf("%w: filter on '%s': %w", ErrInvalidQuery, filter.Field, err)
+		}
+	}
+	return nil
+}
+
+// validateFilterValue checks the operator of a filter and the shape of its value
+func validateFilterValue(filter QueryFilter) error {
+	switch filter.Operator {
+	case "eq", "ne":
+	case "gt", "gte", "lt", "lte":
+		if filter.Value == nil {
+			return fmt.Errorf("%s value must not be null", filter.Operator)
+		}
+	case "in":
+		if _, ok := filter.Value.([]any); !ok {
+			return fmt.Errorf("in value must be an array, got %T", filter.Value)
+		}
+	case "startsWith", "endsWith":
+		if _, ok := filter.Value.(string); !ok {
+			return fmt.Errorf("%s value must be a string, got %T", filter.Operator, filter.Value)
+		}
+	case "exists":
+		if _, ok := filter.Value.(bool); !ok && filter.Value != nil {
+			return fmt.Errorf("exists value must be a boolean, got %T", filter.Value)
+		}
+	case "mod":
+		if _, _, err := modOperands(filter.Value); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown operator '%s'", filter.Operator)
+	}
+	return nil
+}
+
+// modOperands extracts the [divisor, remainder] pair of a "mod" filter
+func modOperands(value any) (float64, float64, error) {
+	operands, ok := value.([]any)
+	if !ok || len(operands) != 2 {
+		return 0, 0, fmt.Errorf("mod value must be [divisor, remainder]")
+	}
+
+	divisor, ok := toFloat(operands[0])
+	if !ok {
+		return 0, 0, fmt.Errorf("mod divisor must be a number, got %T", operands[0])
+	}
+	if divisor == 0 {
+		return 0, 0, fmt.Errorf("mod divisor must not be zero")
+	}
+
+	remainder, ok := toFloat(operands[1])
+	if !ok {
+		return 0, 0, fmt.Errorf("mod remainder must be a number, got %T", operands[1])
+	}
+
+	return divisor, remainder, nil
+}
+
 // matchesAllFilters checks if a document matches all filters
-func matchesAllFilters(doc *Document, filters []QueryFilter) bool {
+func matchesAllFilters(doc *Document, filters []QueryFilter, collation Collation) bool {
 	for _, filter := range filters {
-		if !matchesFilter(doc, filter) {
+		if !matchesFilter(doc, filter, collation) {
 			return false
 		}
 	}
@@ -204,46 +786,139 @@ func matchesAllFilters(doc *Document, filters []QueryFilter) bool {
 }
 
 // matchesFilter checks if a document matches a single filter
-func matchesFilter(doc *Document, filter QueryFilter) bool {
+//
+// Null semantics: a field stored as JSON null is present with a nil value.
+// "eq" with a nil Value matches only null fields, "exists" distinguishes
+// missing fields from null ones, and ordering operators never match null.
+// Ordering operators compare strings under collation.
+func matchesFilter(doc *Document, filter QueryFilter, collation Collation) bool {
 	value, exists := doc.GetValue(filter.Field)
+
+	if filter.Operator == "exists" {
+		want := true
+		if b, ok := filter.Value.(bool); ok {
+			want = b
+		}
+		return exists == want
+	}
+
 	if !exists {
 		return false
 	}
 
 	switch filter.Operator {
 	case "eq":
-		return fmt.Sprintf("%v", value) == fmt.Sprintf("%v", filter.Value)
+		return matchesEq(value, filter.Value)
 	case "ne":
-		return fmt.Sprintf("%v", value) != fmt.Sprintf("%v", filter.Value)
-	case "gt":
-		return compareValues(value, filter.Value) > 0
-	case "gte":
-		return compareValues(value, filter.Value) >= 0
-	case "lt":
-		return compareValues(value, filter.Value) < 0
-	case "lte":
-		return compareValues(value, filter.Value) <= 0
+		return !matchesEq(value, filter.Value)
+	case "gt", "gte", "lt", "lte":
+		if value == nil || filter.Value == nil {
+			return false
+		}
+		cmp := collation.compareValues(value, filter.Value)
+		switch filter.Operator {
+		case "gt":
+			return cmp > 0
+		case "gte":
+			return cmp >= 0
+		case "lt":
+			return cmp < 0
+		default:
+			return cmp <= 0
+		}
 	case "in":
 		// Check if value is in the filter.Value array
 		if arr, ok := filter.Value.([]any); ok {
-			valueStr := fmt.Sprintf("%v", value)
 			for _, item := range arr {
-				if fmt.Sprintf("%v", item) == valueStr {
+				if matchesEq(value, item) {
 					return true
 				}
 			}
 		}
 		return false
+	case "startsWith":
+		str, ok := value.(string)
+		prefix, pok := filter.Value.(string)
+		return ok && pok && strings.HasPrefix(str, prefix)
+	case "endsWith":
+		str, ok := value.(string)
+		suffix, sok := filter.Value.(string)
+		return ok && sok && strings.HasSuffix(str, suffix)
+	case "mod":
+		num, ok := toFloat(value)
+		if !ok {
+			return false
+		}
+		divisor, remainder, err := modOperands(filter.Value)
+		if err != nil {
+			return false
+		}
+		return math.Mod(num, divisor) == remainder
 	}
 
 	return false
 }
 
-// compareValues compares two values (simple numeric/string comparison)
-func compareValues(a, b any) int {
-	aStr := fmt.Sprintf("%v", a)
-	bStr := fmt.Sprintf("%v", b)
-	return strings.Compare(aStr, bStr)
+// valuesEqual compares two values for equality, treating nil as equal only to nil
+func valuesEqual(a, b any) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return valueKey(a) == valueKey(b)
+}
+
+// matchesEq reports whether a field value matches an equality filter: it
+// equals target or, for an array value and a target that is not an array,
+// has an element equal to target. So {"tags": ["a", "b"]} matches both
+// tags eq "a" and tags eq ["a", "b"], but not tags eq ["a"].
+func matchesEq(value, target any) bool {
+	if valuesEqual(value, target) {
+		return true
+	}
+	arr, ok := value.([]any)
+	if !ok {
+		return false
+	}
+	if _, ok := target.([]any); ok {
+		return false
+	}
+	for _, elem := range arr {
+		if valuesEqual(elem, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// toFloat converts a numeric value to float64
+func toFloat(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	}
+	return 0, false
 }
 
 // CreateCollection creates a new collection in the database
@@ -251,8 +926,17 @@ func (db *Database) CreateCollection(name string, schema *Schema) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
+	if db.readOnly {
+		return ErrReadOnly
+	}
+
 	if _, exists := db.Collections[name]; exists {
-		return fmt.Errorf("collection '%s' already exists", name)
+		return fmt.Errorf("collection '%s' %w", name, ErrAlreadyExists)
+	}
+
+	// Its files are still on disk; a new collection would overwrite them
+	if err, exists := db.unavailable[name]; exists {
+		return fmt.Errorf("collection '%s' %w: %w", name, ErrUnavailable, err)
 	}
 
 	if schema != nil {
@@ -261,7 +945,9 @@ func (db *Database) CreateCollection(name string, schema *Schema) error {
 		}
 	}
 
-	db.Collections[name] = NewCollection(name, schema)
+	coll := NewCollection(name, schema)
+	coll.createSchemaIndexes()
+	db.Collections[name] = coll
 	return nil
 }
 
@@ -270,27 +956,107 @@ func (db *Database) DropCollection(name string) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
+	if db.readOnly {
+		return ErrReadOnly
+	}
+
 	if _, exists := db.Collections[name]; !exists {
-		return fmt.Errorf("collection '%s' does not exist", name)
+		return fmt.Errorf("collection '%s' %w", name, ErrNotFound)
 	}
 
 	delete(db.Collections, name)
 	return nil
 }
 
-// GetCollection gets a collection by name
+// GetCollection gets a collection by name, loading its documents if they
+// aren't in memory. With a memory budget set, this is also where least
+// recently used collections get evicted.
 func (db *Database) GetCollection(name string) (*Collection, error) {
 	db.mu.RLock()
-	defer db.mu.RUnlock()
-
 	coll, exists := db.Collections[name]
+	loadErr := db.unavailable[name]
+	budget := db.memoryBudget
+	db.mu.RUnlock()
+
+	if loadErr != nil {
+		return nil, fmt.Errorf("collection '%s' %w: %w", name, ErrUnavailable, loadErr)
+	}
 	if !exists {
-		return nil, fmt.Errorf("collection '%s' does not exist", name)
+		return nil, fmt.Errorf("collection '%s' %w", name, ErrNotFound)
+	}
+
+	if err := coll.Load(); err != nil {
+		return nil, err
+	}
+
+	db.touch(coll)
+	if budget > 0 {
+		db.enforceMemoryBudget(coll)
 	}
 
 	return coll, nil
 }
 
+// GetOrCreateCollection gets a collection by name like GetCollection,
+// creating it with schema first if it doesn't exist. It reports whether it
+// created the collection, which the caller then logs to the WAL; a
+// collection created concurrently by another caller is only fetched.
+func (db *Database) GetOrCreateCollection(name string, schema *Schema) (*Collection, bool, error) {
+	coll, err := db.GetCollection(name)
+	if !errors.Is(err, ErrNotFound) {
+		return coll, false, err
+	}
+
+	created := true
+	if err := db.CreateCollection(name, schema); err != nil {
+		if !errors.Is(err, ErrAlreadyExists) {
+			return nil, false, err
+		}
+		created = false
+	}
+
+	coll, err = db.GetCollection(name)
+	if err != nil {
+		return nil, false, err
+	}
+	return coll, created, nil
+}
+
+// HasCollection reports whether the database has a collection, without
+// loading it. Like ListCollections, it leaves out collections that failed to
+// load.
+func (db *Database) HasCollection(name string) bool {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	_, exists := db.Collections[name]
+	return exists
+}
+
+// UnavailableCollections returns the collections that failed to load with
+// WithTolerantLoad and the errors they failed with, by name. They are not
+// listed by ListCollections and are never saved, so their files stay as they
+// were for repair.
+func (db *Database) UnavailableCollections() map[string]error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	unavailable := make(map[string]error, len(db.unavailable))
+	for name, err := range db.unavailable {
+		unavailable[name] = err
+	}
+	return unavailable
+}
+
+// IsUnavailable reports whether a collection failed to load with WithTolerantLoad
+func (db *Database) IsUnavailable(name string) bool {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	_, exists := db.unavailable[name]
+	return exists
+}
+
 // ListCollections returns a list of all collection names
 func (db *Database) ListCollections() []string {
 	db.mu.RLock()
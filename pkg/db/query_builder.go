@@ -0,0 +1,128 @@
+package db
+
+// SortOrder is the direction of a sort key
+type SortOrder int
+
+// Sort orders
+const (
+	Asc SortOrder = iota
+	Desc
+)
+
+// QueryBuilder builds a Query fluently:
+//
+//	query := db.Where("age").Gte(30).And("status").Eq("active").Sort("age", db.Desc).Limit(10).Build()
+//
+// Each operator method adds a filter on the field named by the last Where or And.
+type QueryBuilder struct {
+	query Query
+	field string
+}
+
+// NewQuery starts a query without filters
+func NewQuery() *QueryBuilder {
+	return &QueryBuilder{}
+}
+
+// Where starts a query with a filter on field
+func Where(field string) *QueryBuilder {
+	return &QueryBuilder{field: field}
+}
+
+// And selects the field the next operator filters on
+func (b *QueryBuilder) And(field string) *QueryBuilder {
+	b.field = field
+	return b
+}
+
+// Eq matches values equal to value
+func (b *QueryBuilder) Eq(value any) *QueryBuilder {
+	return b.filter("eq", value)
+}
+
+// Ne matches values not equal to value
+func (b *QueryBuilder) Ne(value any) *QueryBuilder {
+	return b.filter("ne", value)
+}
+
+// Gt matches values greater than value
+func (b *QueryBuilder) Gt(value any) *QueryBuilder {
+	return b.filter("gt", value)
+}
+
+// Gte matches values greater than or equal to value
+func (b *QueryBuilder) Gte(value any) *QueryBuilder {
+	return b.filter("gte", value)
+}
+
+// Lt matches values less than value
+func (b *QueryBuilder) Lt(value any) *QueryBuilder {
+	return b.filter("lt", value)
+}
+
+// Lte matches values less than or equal to value
+func (b *QueryBuilder) Lte(value any) *QueryBuilder {
+	return b.filter("lte", value)
+}
+
+// In matches values equal to any of values
+func (b *QueryBuilder) In(values ...any) *QueryBuilder {
+	return b.filter("in", values)
+}
+
+// StartsWith matches string values with the given prefix
+func (b *QueryBuilder) StartsWith(prefix string) *QueryBuilder {
+	return b.filter("startsWith", prefix)
+}
+
+// EndsWith matches string values with the given suffix
+func (b *QueryBuilder) EndsWith(suffix string) *QueryBuilder {
+	return b.filter("endsWith", suffix)
+}
+
+// Exists matches documents where the field is present (or missing, if exists is false)
+func (b *QueryBuilder) Exists(exists bool) *QueryBuilder {
+	return b.filter("exists", exists)
+}
+
+// Mod matches numeric values where value % divisor == remainder
+func (b *QueryBuilder) Mod(divisor, remainder any) *QueryBuilder {
+	return b.filter("mod", []any{divisor, remainder})
+}
+
+// Sort adds a sort key; keys apply in the order they are added
+func (b *QueryBuilder) Sort(field string, order SortOrder) *QueryBuilder {
+	b.query.Sort = append(b.query.Sort, SortField{Field: field, Desc: order == Desc})
+	return b
+}
+
+// Limit sets the maximum number of results
+func (b *QueryBuilder) Limit(n int) *QueryBuilder {
+	b.query.Limit = n
+	return b
+}
+
+// Skip sets the number of results to skip
+func (b *QueryBuilder) Skip(n int) *QueryBuilder {
+	b.query.Skip = n
+	return b
+}
+
+// Build returns the query. The builder can keep being used afterwards
+// without affecting the returned query.
+func (b *QueryBuilder) Build() *Query {
+	query := b.query
+	query.Filters = append([]QueryFilter(nil), b.query.Filters...)
+	query.Sort = append([]SortField(nil), b.query.Sort...)
+	return &query
+}
+
+// filter adds a filter on the current field
+func (b *QueryBuilder) filter(operator string, value any) *QueryBuilder {
+	b.query.Filters = append(b.query.Filters, QueryFilter{
+		Field:    b.field,
+		Operator: operator,
+		Value:    value,
+	})
+	return b
+}
@@ -0,0 +1,222 @@
+package db
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// newTestCollection returns a collection of a new database with docs
+// inserted, and with indexes on the given fields
+func newTestCollection(t *testing.T, docs map[string]map[string]any, indexed ...string) *Collection {
+	t.Helper()
+	db := NewDatabase("app")
+	if err := db.CreateCollection("items", nil); err != nil {
+		t.Fatal(err)
+	}
+	coll, _ := db.GetCollection("items")
+	for _, field := range indexed {
+		if err := coll.CreateIndex(field+"_idx", field); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for id, data := range docs {
+		if err := coll.Insert(&Document{ID: id, Data: data}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return coll
+}
+
+// findIDs runs query and returns the IDs of the documents found, sorted
+func findIDs(t *testing.T, coll *Collection, query *Query) []string {
+	t.Helper()
+	docs, err := coll.Find(query)
+	if err != nil {
+		t.Fatalf("Find %+v: %v", query.Filters, err)
+	}
+	ids := make([]string, 0, len(docs))
+	for _, doc := range docs {
+		ids = append(ids, doc.ID)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func TestNullSemantics(t *testing.T) {
+	docs := map[string]map[string]any{
+		"null":    {"v": nil},
+		"missing": {},
+		"one":     {"v": 1},
+	}
+	for _, indexed := range [][]string{nil, {"v"}} {
+		coll := newTestCollection(t, docs, indexed...)
+		for _, tc := range []struct {
+			query *Query
+			want  []string
+		}{
+			{Where("v").Eq(nil).Build(), []string{"null"}},
+			// Operators other than exists never match a missing field
+			{Where("v").Ne(nil).Build(), []string{"one"}},
+			{Where("v").Exists(false).Build(), []string{"missing"}},
+			{Where("v").Exists(true).Build(), []string{"null", "one"}},
+			{Where("v").In(nil, 1).Build(), []string{"null", "one"}},
+		} {
+			if got := findIDs(t, coll, tc.query); !slices.Equal(got, tc.want) {
+				t.Errorf("indexed %v, %+v: got %v, want %v", indexed, tc.query.Filters, got, tc.want)
+			}
+		}
+	}
+
+	coll := newTestCollection(t, docs, "v")
+	idx := coll.Indexes["v_idx"]
+	if got := idx.FindAll(nil); !slices.Equal(got, []string{"null"}) {
+		t.Errorf("index FindAll(nil) = %v, want [null]", got)
+	}
+	if got := idx.FindAll("<nil>"); len(got) != 0 {
+		t.Errorf(`index FindAll("<nil>") = %v, want none`, got)
+	}
+}
+
+func TestArrayEquality(t *testing.T) {
+	docs := map[string]map[string]any{
+		"both":   {"tags": []any{"go", "db"}},
+		"go":     {"tags": []any{"go"}},
+		"scalar": {"tags": "go"},
+		"nested": {"tags": []any{[]any{"go"}}},
+		"none":   {"tags": []any{}},
+	}
+	for _, indexed := range [][]string{nil, {"tags"}} {
+		coll := newTestCollection(t, docs, indexed...)
+		for _, tc := range []struct {
+			query *Query
+			want  []string
+		}{
+			{Where("tags").Eq("go").Build(), []string{"both", "go", "scalar"}},
+			{Where("tags").Eq([]any{"go", "db"}).Build(), []string{"both"}},
+			{Where("tags").Eq([]any{"db", "go"}).Build(), []string{}},
+			// An array value is never compared with elements
+			{Where("tags").Eq([]any{"go"}).Build(), []string{"go"}},
+			{Where("tags").Eq([]any{}).Build(), []string{"none"}},
+			{Where("tags").Ne("go").Build(), []string{"nested", "none"}},
+			{Where("tags").In("db", "x").Build(), []string{"both"}},
+			{Where("tags").In([]any{"go"}, "db").Build(), []string{"both", "go"}},
+		} {
+			if got := findIDs(t, coll, tc.query); !slices.Equal(got, tc.want) {
+				t.Errorf("indexed %v, %+v: got %v, want %v", indexed, tc.query.Filters, got, tc.want)
+			}
+		}
+	}
+}
+
+func TestConcurrentUpsertsOfOneKey(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDB(t, dir)
+	if _, err := d.CreateCollection("users", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetIDKey("users", &IDKey{Fields: []string{"email"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	const workers, upserts = 8, 25
+	var inserted atomic.Int64
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Go(func() {
+			for i := range upserts {
+				result, err := d.Upsert("users", &Document{Data: map[string]any{"email": "x", "n": w*upserts + i}})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				inserted.Add(int64(result.Inserted))
+			}
+		})
+	}
+	wg.Wait()
+
+	if n := inserted.Load(); n != 1 {
+		t.Errorf("%d upserts inserted, want 1", n)
+	}
+	if n := mustCollection(t, d, "users").Count(); n != 1 {
+		t.Errorf("Count = %d, want 1", n)
+	}
+
+	// Replaying the logged upserts lands on one document too
+	reopened := openTestDB(t, dir)
+	defer reopened.Close()
+	if n := mustCollection(t, reopened, "users").Count(); n != 1 {
+		t.Errorf("Count after replay = %d, want 1", n)
+	}
+}
+
+func TestFiltersOnOneField(t *testing.T) {
+	docs := make(map[string]map[string]any)
+	for age := range 60 {
+		docs[fmt.Sprintf("p%02d", age)] = map[string]any{"age": age}
+	}
+	docs["text"] = map[string]any{"age": "15"}
+
+	for _, indexed := range [][]string{nil, {"age"}} {
+		coll := newTestCollection(t, docs, indexed...)
+		for _, tc := range []struct {
+			query *Query
+			want  int
+		}{
+			{Where("age").Gte(20).And("age").Lte(40).Build(), 21},
+			{Where("age").Gt(20).And("age").Lt(40).Build(), 19},
+			{Where("age").Eq(30).And("age").Lte(40).Build(), 1},
+			{Where("age").Lte(40).And("age").Eq(30).Build(), 1},
+			// Conflicting pairs match nothing, whichever filter picks the candidates
+			{Where("age").Eq(30).And("age").Gte(40).Build(), 0},
+			{Where("age").Gte(40).And("age").Eq(30).Build(), 0},
+			{Where("age").Gt(40).And("age").Lt(20).Build(), 0},
+			{Where("age").Eq(30).And("age").Eq(31).Build(), 0},
+			{Where("age").Eq(30).And("age").Ne(30).Build(), 0},
+		} {
+			if got := findIDs(t, coll, tc.query); len(got) != tc.want {
+				t.Errorf("indexed %v, %+v: got %d documents %v, want %d", indexed, tc.query.Filters, len(got), got, tc.want)
+			}
+		}
+	}
+}
+
+func TestInsertStoresCopy(t *testing.T) {
+	coll := newTestCollection(t, nil, "email")
+
+	data := map[string]any{"email": "x"}
+	doc := &Document{Data: data}
+	if err := coll.Insert(doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.ID == "" {
+		t.Fatal("Insert didn't set the assigned ID on the document")
+	}
+	upserted := map[string]any{"email": "y"}
+	if _, err := coll.Upsert(&Document{ID: "u", Data: upserted}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changing the caller's maps changes neither the documents nor the index
+	data["email"] = "changed"
+	upserted["email"] = "changed"
+	doc.Data = nil
+	for id, want := range map[string]string{doc.ID: "x", "u": "y"} {
+		stored, err := coll.FindByID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := stored.Data["email"]; got != want {
+			t.Errorf("stored email of %s = %v, want %s", id, got, want)
+		}
+		if got := findIDs(t, coll, Where("email").Eq(want).Build()); !slices.Equal(got, []string{id}) {
+			t.Errorf("email eq %s = %v, want [%s]", want, got, id)
+		}
+	}
+	if got := findIDs(t, coll, Where("email").Eq("changed").Build()); len(got) != 0 {
+		t.Errorf("email eq changed = %v, want none", got)
+	}
+}
@@ -0,0 +1,154 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrRecoveryPoint reports a recovery point that can't be reached from a
+// snapshot with the entries left in the WAL
+var ErrRecoveryPoint = errors.New("recovery point not reachable")
+
+// RecoverTo rebuilds the state of all databases as of until, e.g. to undo a
+// bad bulk operation. It loads the snapshot in snapshotDir, a copy of the
+// root directory taken earlier with everything checkpointed (after a clean
+// shutdown or Flush(true) with writes stopped), then replays this storage's
+// WAL from the snapshot's checkpoint up to the last entry appended at or
+// before until.
+//
+// The result lives in memory only: neither the snapshot nor this storage is
+// changed. Save it with SaveAllDatabases on another storage manager, or merge
+// databases back with MergeDatabase. It fails with ErrRecoveryPoint when the
+// snapshot is newer than until or the WAL no longer holds the entries after
+// the snapshot.
+func (sm *StorageManager) RecoverTo(snapshotDir string, until time.Time) (*DatabaseManager, *ReplaySummary, error) {
+	return sm.recover(snapshotDir, stopAfterTime(until))
+}
+
+// RecoverToOffset is like RecoverTo but recovers the state after the WAL
+// entry at offset. A batch is applied whole or not at all, so the state is
+// the one before a batch that doesn't end by offset.
+func (sm *StorageManager) RecoverToOffset(snapshotDir string, offset uint64) (*DatabaseManager, *ReplaySummary, error) {
+	return sm.recover(snapshotDir, stopAfterOffset(offset))
+}
+
+// recover loads a snapshot and replays the WAL entries after its checkpoint
+// until stop reports one past the recovery point
+func (sm *StorageManager) recover(snapshotDir string, stop func(*WALEntry) bool) (*DatabaseManager, *ReplaySummary, error) {
+	if sm.WAL == nil {
+		return nil, nil, fmt.Errorf("point-in-time recovery needs a WAL, which read-only and in-memory storage lack")
+	}
+	start := time.Now()
+
+	checkpoint, err := readWALCheckpoint(snapshotDir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read snapshot checkpoint: %w", err)
+	}
+	from := uint64(0)
+	if checkpoint != nil {
+		from = checkpoint.Offset
+	}
+
+	// Read all retained entries, to check those the snapshot covers too
+	if err := sm.WAL.Flush(); err != nil {
+		return nil, nil, err
+	}
+	entries, err := sm.WAL.ReadFrom(0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read WAL: %w", err)
+	}
+	covered := 0
+	for covered < len(entries) && entries[covered].Offset < from {
+		covered++
+	}
+	if covered > 0 && stop(entries[covered-1]) {
+		return nil, nil, fmt.Errorf("%w: the snapshot includes the entry at offset %d, past it", ErrRecoveryPoint, entries[covered-1].Offset)
+	}
+	entries = entries[covered:]
+
+	// Checkpoints leave a gap of one offset, so the WAL holds every entry
+	// after the snapshot if it starts at most one past its checkpoint
+	next := sm.WAL.Offset()
+	if len(entries) > 0 {
+		next = entries[0].Offset
+	}
+	if covered == 0 && next > from+1 {
+		return nil, nil, fmt.Errorf("%w: the WAL no longer holds the entries after the snapshot's checkpoint at offset %d", ErrRecoveryPoint, from)
+	}
+
+	// The snapshot's files are only read, but the databases loaded from it
+	// take writes in memory so the replay can apply to them
+	snapshot, err := NewStorageManager(snapshotDir, WithReadOnly(), WithEncryptionKey(sm.encryptionKey))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	snapshot.writableLoads = true
+	dm := NewDatabaseManager()
+	if err := snapshot.loadSavedDatabases(dm); err != nil {
+		return nil, nil, fmt.Errorf("failed to load snapshot: %w", err)
+	}
+
+	target, err := sm.replayTarget(dm)
+	if err != nil {
+		return nil, nil, err
+	}
+	summary, err := sm.WAL.replayEntries(entries, from, dm, target, nil, stop, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	summary.Skipped = covered
+	summary.Duration = time.Since(start)
+	return dm, summary, nil
+}
+
+// replayTarget returns an in-memory storage manager to replay WAL entries
+// into dm with, so that nothing replayed touches the disk
+func (sm *StorageManager) replayTarget(dm *DatabaseManager) (*StorageManager, error) {
+	target, err := NewStorageManager(MemoryRootDir, WithEncryptionKey(sm.encryptionKey))
+	if err != nil {
+		return nil, err
+	}
+	target.dbManager = dm
+	return target, nil
+}
+
+// LoadDatabaseReplayed loads the database dbName like LoadDatabase, then
+// applies the WAL entries logged for it since the last checkpoint, leaving
+// the entries of other databases alone. Only the returned database is
+// changed: nothing is saved or checkpointed. Without a WAL (read-only or
+// in-memory storage), it is LoadDatabase and the summary is nil.
+func (sm *StorageManager) LoadDatabaseReplayed(dbName string) (*Database, *ReplaySummary, error) {
+	if sm.WAL == nil {
+		db, err := sm.LoadDatabase(dbName)
+		return db, nil, err
+	}
+
+	// The database may only exist in the WAL so far
+	dm := NewDatabaseManager()
+	db, err := sm.LoadDatabase(dbName)
+	if err == nil {
+		err = dm.AddDatabase(db)
+	}
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, nil, err
+	}
+
+	target, err := sm.replayTarget(dm)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := sm.WAL.Flush(); err != nil {
+		return nil, nil, err
+	}
+	summary, err := sm.WAL.ReplayDatabase(dm, target, nil, dbName, "")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to replay WAL: %w", err)
+	}
+
+	db = dm.GetDatabase(dbName)
+	if db == nil {
+		return nil, nil, fmt.Errorf("database '%s' %w", dbName, ErrNotFound)
+	}
+	return db, summary, nil
+}
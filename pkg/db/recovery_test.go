@@ -0,0 +1,141 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// snapshotDir copies the root directory dir, as a backup would, and
+// returns the copy's path
+func snapshotDir(t *testing.T, dir string) string {
+	t.Helper()
+	snap := filepath.Join(t.TempDir(), "snapshot")
+	if err := os.CopyFS(snap, os.DirFS(dir)); err != nil {
+		t.Fatal(err)
+	}
+	return snap
+}
+
+// recoveredIDs returns the sorted IDs of the documents of items in the
+// database "app" of dm
+func recoveredIDs(t *testing.T, dm *DatabaseManager) []string {
+	t.Helper()
+	db := dm.GetDatabase("app")
+	if db == nil {
+		t.Fatal("database app not recovered")
+	}
+	coll, err := db.GetCollection("items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, 0, len(coll.Documents))
+	for id := range coll.Documents {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func TestRecoverTo(t *testing.T) {
+	dir := t.TempDir()
+	t0 := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	clock := NewFakeClock(t0)
+	d := openTestDB(t, dir, WithClock(clock))
+	defer d.Close()
+	if _, err := d.CreateCollection("items", nil); err != nil {
+		t.Fatal(err)
+	}
+	mustInsert(t, d, "items", "a", map[string]any{"n": 1})
+	if err := d.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	snap := snapshotDir(t, dir)
+
+	clock.Advance(time.Minute)
+	mustInsert(t, d, "items", "b", map[string]any{"n": 2})
+	clock.Advance(time.Minute)
+	mustInsert(t, d, "items", "c", map[string]any{"n": 3})
+	clock.Advance(time.Minute)
+	if err := d.Delete("items", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Storage().WAL.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.ReadDir(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		until     time.Time
+		want      []string
+		discarded int
+	}{
+		{t0, []string{"a"}, 3},
+		{t0.Add(time.Minute), []string{"a", "b"}, 2},
+		{t0.Add(90 * time.Second), []string{"a", "b"}, 2},
+		{t0.Add(3 * time.Minute), []string{"b", "c"}, 0},
+	} {
+		dm, summary, err := d.Storage().RecoverTo(snap, tc.until)
+		if err != nil {
+			t.Fatalf("RecoverTo %v: %v", tc.until, err)
+		}
+		if got := recoveredIDs(t, dm); !slices.Equal(got, tc.want) {
+			t.Errorf("RecoverTo %v = %v, want %v", tc.until, got, tc.want)
+		}
+		if summary.Discarded != tc.discarded {
+			t.Errorf("RecoverTo %v discarded %d entries, want %d", tc.until, summary.Discarded, tc.discarded)
+		}
+	}
+
+	entries, err := d.Storage().WAL.ReadFrom(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dm, _, err := d.Storage().RecoverToOffset(snap, entries[len(entries)-2].Offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := recoveredIDs(t, dm); !slices.Equal(got, []string{"a", "b", "c"}) {
+		t.Errorf("RecoverToOffset before the delete = %v, want [a b c]", got)
+	}
+
+	// The recovered databases take writes in memory only
+	coll, err := dm.GetDatabase("app").GetCollection("items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := coll.Insert(&Document{ID: "z", Data: map[string]any{}}); err != nil {
+		t.Fatalf("Insert into recovered collection: %v", err)
+	}
+	after, err := os.ReadDir(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != len(before) {
+		t.Errorf("snapshot changed from %d to %d entries", len(before), len(after))
+	}
+	if got := findIDs(t, mustCollection(t, d, "items"), NewQuery().Build()); !slices.Equal(got, []string{"b", "c"}) {
+		t.Errorf("live collection = %v after recovery, want [b c]", got)
+	}
+
+	_, _, err = d.Storage().RecoverTo(snap, t0.Add(-time.Second))
+	if !errors.Is(err, ErrRecoveryPoint) {
+		t.Errorf("RecoverTo before the snapshot: err = %v, want ErrRecoveryPoint", err)
+	}
+}
+
+// mustCollection returns the collection collName of d
+func mustCollection(t *testing.T, d *DB, collName string) *Collection {
+	t.Helper()
+	coll, err := d.Collection(collName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return coll
+}
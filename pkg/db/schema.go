@@ -1,30 +1,72 @@
 package db
 
 import (
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
 )
 
-// ValidateDocument validates a document against a schema
+// FieldViolation describes one way a document fails its schema
+type FieldViolation struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"` // "required" or "type"
+	Message string `json:"message"`
+}
+
+// ValidationError lists every schema violation of a document, ordered by field
+type ValidationError struct {
+	Violations []FieldViolation `json:"violations"`
+}
+
+// Error joins the violation messages
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		messages[i] = v.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// ValidateDocument validates a document against a schema. All violations are
+// reported together in a *ValidationError.
 func (s *Schema) ValidateDocument(doc *Document) error {
 	if s == nil {
 		return nil // No schema, no validation
 	}
 
-	// Check required fields
-	for fieldName, field := range s.Fields {
+	fieldNames := make([]string, 0, len(s.Fields))
+	for fieldName := range s.Fields {
+		fieldNames = append(fieldNames, fieldName)
+	}
+	sort.Strings(fieldNames)
+
+	var violations []FieldViolation
+	for _, fieldName := range fieldNames {
+		field := s.Fields[fieldName]
 		value, exists := doc.GetValue(fieldName)
 
 		if field.Required && !exists {
-			return fmt.Errorf("required field '%s' is missing", fieldName)
+			violations = append(violations, FieldViolation{
+				Field:   fieldName,
+				Rule:    "required",
+				Message: fmt.Sprintf("required field '%s' is missing", fieldName),
+			})
 		}
 
-		if exists {
-			if !ValidateType(value, field.Type) {
-				return fmt.Errorf("field '%s' has invalid type, expected %s", fieldName, field.Type)
-			}
+		if exists && !ValidateType(value, field.Type) {
+			violations = append(violations, FieldViolation{
+				Field:   fieldName,
+				Rule:    "type",
+				Message: fmt.Sprintf("field '%s' has invalid type, expected %s", fieldName, field.Type),
+			})
 		}
 	}
 
+	if len(violations) > 0 {
+		return &ValidationError{Violations: violations}
+	}
+
 	return nil
 }
 
@@ -57,3 +99,140 @@ func (s *Schema) Validate() error {
 
 	return nil
 }
+
+// GetSchema returns the collection's schema, or nil if it has none
+func (c *Collection) GetSchema() *Schema {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.Schema
+}
+
+// SetSchema installs a new schema on the collection. If validateExisting is
+// true, every stored document is checked first; when any fail, the schema is
+// left unchanged and the offending document IDs are returned with an error.
+// Fields marked Unique are always checked: documents sharing a value fail
+// with ErrDuplicateKey the same way. The indexes of fields marked Index or
+// Unique are created if missing, as by CreateCollection. A nil schema
+// removes validation from the collection.
+func (c *Collection) SetSchema(schema *Schema, validateExisting bool) ([]string, error) {
+	return c.setSchema(schema, validateExisting, true)
+}
+
+// setSchema is SetSchema, checking unique fields only if checkUnique. WAL
+// replay doesn't: the documents may only be unique again once the entries
+// after the schema change are replayed.
+func (c *Collection) setSchema(schema *Schema, validateExisting, checkUnique bool) ([]string, error) {
+	if schema != nil {
+		if err := schema.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid schema: %w", err)
+		}
+	}
+
+	if err := c.lock(); err != nil {
+		return nil, err
+	}
+	defer c.mu.Unlock()
+
+	if c.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	if validateExisting && schema != nil {
+		var invalid []string
+		for id, doc := range c.Documents {
+			if err := schema.ValidateDocument(doc); err != nil {
+				invalid = append(invalid, id)
+			}
+		}
+
+		if len(invalid) > 0 {
+			sort.Strings(invalid)
+			return invalid, fmt.Errorf("%w: %d document(s) do not match the new schema", ErrSchemaValidation, len(invalid))
+		}
+	}
+	if checkUnique && schema != nil {
+		if duplicates := c.duplicateValuesLocked(schema); len(duplicates) > 0 {
+			return duplicates, fmt.Errorf("%d document(s) share values of unique fields (%w)", len(duplicates), ErrDuplicateKey)
+		}
+	}
+
+	c.Schema = schema
+	buildIndexes(c.Documents, c.createSchemaIndexes(), workerCount(0))
+	c.modCount++
+	return nil, nil
+}
+
+// WriteOption changes how a single insert or update is applied
+type WriteOption func(*writeOptions)
+
+// writeOptions holds the WriteOptions of a write
+type writeOptions struct {
+	skipValidation bool
+	skipUnique     bool // not exposed; WAL replay may pass through states a newer save already resolved
+}
+
+// newWriteOptions applies opts to the default options
+func newWriteOptions(opts []WriteOption) writeOptions {
+	var o writeOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// SkipValidation makes a write skip schema validation, e.g. to load data
+// that is fixed up afterwards. It must be asked for explicitly on each
+// write; ValidateAll finds the documents that don't match the schema.
+func SkipValidation() WriteOption {
+	return func(o *writeOptions) {
+		o.skipValidation = true
+	}
+}
+
+// InvalidDocument lists the schema violations of a stored document
+type InvalidDocument struct {
+	DocumentID string           `json:"document_id"`
+	Violations []FieldViolation `json:"violations"`
+}
+
+// ValidateAll checks every stored document against the collection's current
+// schema and returns the IDs of the ones that fail, in order, without
+// modifying anything. Documents only fail when written with SkipValidation,
+// or stored before the schema was set or changed without validateExisting.
+func (c *Collection) ValidateAll() ([]string, error) {
+	invalid, err := c.ValidateAllDetailed()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(invalid))
+	for i, doc := range invalid {
+		ids[i] = doc.DocumentID
+	}
+	return ids, nil
+}
+
+// ValidateAllDetailed is ValidateAll with the violations of each document
+func (c *Collection) ValidateAllDetailed() ([]InvalidDocument, error) {
+	if err := c.rlock(); err != nil {
+		return nil, err
+	}
+	defer c.mu.RUnlock()
+
+	invalid := []InvalidDocument{}
+	if c.Schema == nil {
+		return invalid, nil
+	}
+
+	for id, doc := range c.Documents {
+		var validationErr *ValidationError
+		if err := c.Schema.ValidateDocument(doc); errors.As(err, &validationErr) {
+			invalid = append(invalid, InvalidDocument{DocumentID: id, Violations: validationErr.Violations})
+		}
+	}
+
+	sort.Slice(invalid, func(i, j int) bool {
+		return invalid[i].DocumentID < invalid[j].DocumentID
+	})
+	return invalid, nil
+}
@@ -0,0 +1,70 @@
+package db
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestSetSchemaRejectsExistingDuplicates(t *testing.T) {
+	db := NewDatabase("app")
+	if err := db.CreateCollection("users", nil); err != nil {
+		t.Fatal(err)
+	}
+	coll, _ := db.GetCollection("users")
+	for id, email := range map[string]any{"a": "x", "b": "y", "c": "x", "d": nil} {
+		if err := coll.Insert(&Document{ID: id, Data: map[string]any{"email": email}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	unique := &Schema{Fields: map[string]Field{"email": {Type: TypeString, Unique: true}}}
+	ids, err := coll.SetSchema(unique, false)
+	if !errors.Is(err, ErrDuplicateKey) {
+		t.Fatalf("SetSchema error = %v, want ErrDuplicateKey", err)
+	}
+	if !slices.Equal(ids, []string{"a", "c"}) {
+		t.Errorf("SetSchema IDs = %v, want [a c]", ids)
+	}
+	if coll.GetSchema() != nil {
+		t.Error("schema changed despite duplicates")
+	}
+	if _, exists := coll.Indexes["email_idx"]; exists {
+		t.Error("index created despite duplicates")
+	}
+}
+
+func TestSetSchemaCreatesSchemaIndexes(t *testing.T) {
+	db := NewDatabase("app")
+	if err := db.CreateCollection("users", nil); err != nil {
+		t.Fatal(err)
+	}
+	coll, _ := db.GetCollection("users")
+	for id, email := range map[string]string{"a": "x", "b": "y"} {
+		if err := coll.Insert(&Document{ID: id, Data: map[string]any{"email": email, "team": "t1"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	schema := &Schema{Fields: map[string]Field{
+		"email": {Type: TypeString, Unique: true},
+		"team":  {Type: TypeString, Index: true},
+	}}
+	if _, err := coll.SetSchema(schema, true); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]int{"email_idx": 1, "team_idx": 2} {
+		idx, exists := coll.Indexes[name]
+		if !exists {
+			t.Fatalf("index %s not created", name)
+		}
+		value := map[string]string{"email_idx": "x", "team_idx": "t1"}[name]
+		if got := len(idx.FindAll(value)); got != want {
+			t.Errorf("%s holds %d document(s) for %q, want %d", name, got, value, want)
+		}
+	}
+	if err := coll.Insert(&Document{ID: "c", Data: map[string]any{"email": "x"}}); !errors.Is(err, ErrDuplicateKey) {
+		t.Errorf("duplicate insert error = %v, want ErrDuplicateKey", err)
+	}
+}
@@ -0,0 +1,98 @@
+package db
+
+import (
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+)
+
+// MaxShards is the most data files a collection can be sharded across
+const MaxShards = 256
+
+// SetShards spreads the collection's documents across n data files, each
+// with its own offset index, by a hash of their ID. Shards are saved and
+// loaded in parallel; queries run on the documents in memory, so they cover
+// all shards. 0 or 1 means a single data file. Only the binary format is
+// sharded: JSON collections keep one documents.json.
+//
+// The shard count is fixed at creation: it can only change while the
+// collection is empty and has never been saved.
+func (c *Collection) SetShards(n int) error {
+	if n < 0 || n > MaxShards {
+		return fmt.Errorf("shard count must be between 0 and %d, got %d", MaxShards, n)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	if n == c.Shards {
+		return nil
+	}
+	if len(c.Documents) > 0 || c.unloaded || c.savedDir != "" {
+		return fmt.Errorf("the shard count of collection '%s' is fixed once it has documents or was saved", c.Name)
+	}
+
+	c.Shards = n
+	c.modCount++
+	return nil
+}
+
+// shardOf returns the shard, of n, holding the document with the given ID
+func shardOf(id string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(id)) //nolint:errcheck
+	return int(h.Sum32() % uint32(n))
+}
+
+// shardPath returns the directory of a shard, relative to the database
+// directory like a collection name, so the binary readers and writers can
+// use it in place of one
+func shardPath(collName string, shard int) string {
+	return filepath.Join(collName, "shards", fmt.Sprintf("%03d", shard))
+}
+
+// writeShards writes a sharded collection's documents, each to the data file
+// of its shard, in parallel
+func (sm *StorageManager) writeShards(dbName, collName string, shards int, docs []*Document) error {
+	groups := make([][]*Document, shards)
+	for _, doc := range docs {
+		shard := shardOf(doc.ID, shards)
+		groups[shard] = append(groups[shard], doc)
+	}
+
+	return sm.loadParallel(shards, func(i int) error {
+		if err := sm.writeBinaryDocuments(dbName, shardPath(collName, i), groups[i]); err != nil {
+			return fmt.Errorf("shard %d: %w", i, err)
+		}
+		return nil
+	})
+}
+
+// readShards reads the documents of all shards of a collection in parallel.
+// It reports whether any corrupt entry was replaced by a recovered copy.
+func (sm *StorageManager) readShards(dbName, collName string, shards int) ([]*Document, bool, error) {
+	groups := make([][]*Document, shards)
+	recovered := make([]bool, shards)
+	err := sm.loadParallel(shards, func(i int) error {
+		docs, rec, err := sm.readBinaryDocuments(dbName, collName, shardPath(collName, i))
+		if err != nil {
+			return fmt.Errorf("shard %d: %w", i, err)
+		}
+		groups[i], recovered[i] = docs, rec
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	var all []*Document
+	anyRecovered := false
+	for i, docs := range groups {
+		all = append(all, docs...)
+		anyRecovered = anyRecovered || recovered[i]
+	}
+	return all, anyRecovered, nil
+}
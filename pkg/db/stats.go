@@ -0,0 +1,185 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// CollectionStats holds size and count information about a collection
+type CollectionStats struct {
+	Name          string `json:"name"`
+	DocumentCount int    `json:"document_count"`
+	IndexCount    int    `json:"index_count"`
+	MemorySize    int64  `json:"memory_size"`      // Approximate in-memory size of documents in bytes
+	DataFileSize  int64  `json:"data_file_size"`   // Size of the document data file on disk
+	IndexFileSize int64  `json:"index_file_size"`  // Size of the offset index and persisted indexes on disk
+	Shards        int    `json:"shards,omitempty"` // Binary data files the documents are spread across, 0 if one
+
+	Compression *CompressionStats `json:"compression,omitempty"` // Only set for binary collections
+	Unloaded    bool              `json:"unloaded,omitempty"`    // Documents are not in memory (evicted or not loaded yet), so the document count is zero
+}
+
+// DatabaseStats aggregates collection statistics for a database
+type DatabaseStats struct {
+	Name            string             `json:"name"`
+	CollectionCount int                `json:"collection_count"`
+	DocumentCount   int                `json:"document_count"`
+	IndexCount      int                `json:"index_count"`
+	MemorySize      int64              `json:"memory_size"`
+	DataFileSize    int64              `json:"data_file_size"`
+	IndexFileSize   int64              `json:"index_file_size"`
+	Compression     *CompressionStats  `json:"compression,omitempty"` // Totals across binary collections
+	Collections     []*CollectionStats `json:"collections"`
+}
+
+// Stats returns in-memory statistics for the collection.
+// Disk sizes are left at zero; use StorageManager.CollectionStats to fill them.
+func (c *Collection) Stats() *CollectionStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return &CollectionStats{
+		Name:          c.Name,
+		DocumentCount: len(c.Documents),
+		IndexCount:    len(c.Indexes),
+		MemorySize:    c.memSize,
+		Shards:        c.Shards,
+		Unloaded:      c.unloaded,
+	}
+}
+
+// Stats returns in-memory statistics aggregated across all collections
+func (db *Database) Stats() *DatabaseStats {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	stats := &DatabaseStats{
+		Name:        db.Name,
+		Collections: make([]*CollectionStats, 0, len(db.Collections)),
+	}
+
+	for _, coll := range db.Collections {
+		stats.add(coll.Stats())
+	}
+
+	return stats
+}
+
+// add accumulates a collection's statistics into the database totals
+func (s *DatabaseStats) add(cs *CollectionStats) {
+	s.CollectionCount++
+	s.DocumentCount += cs.DocumentCount
+	s.IndexCount += cs.IndexCount
+	s.MemorySize += cs.MemorySize
+	s.DataFileSize += cs.DataFileSize
+	s.IndexFileSize += cs.IndexFileSize
+	if cs.Compression != nil {
+		if s.Compression == nil {
+			s.Compression = &CompressionStats{}
+		}
+		s.Compression.UncompressedBytes += cs.Compression.UncompressedBytes
+		s.Compression.CompressedBytes += cs.Compression.CompressedBytes
+		if s.Compression.CompressedBytes > 0 {
+			s.Compression.Ratio = float64(s.Compression.UncompressedBytes) / float64(s.Compression.CompressedBytes)
+		}
+	}
+	s.Collections = append(s.Collections, cs)
+}
+
+// CollectionStats returns collection statistics including on-disk file sizes
+func (sm *StorageManager) CollectionStats(dbName string, coll *Collection) *CollectionStats {
+	stats := coll.Stats()
+	collDir := filepath.Join(sm.RootDir, dbName, coll.Name)
+
+	stats.DataFileSize = fileSize(filepath.Join(collDir, "documents.json"))
+
+	// A sharded collection has a data file and offset index per shard
+	dirs := []string{coll.Name}
+	if stats.Shards > 1 {
+		dirs = make([]string, stats.Shards)
+		for i := range dirs {
+			dirs[i] = shardPath(coll.Name, i)
+		}
+	}
+	for _, dir := range dirs {
+		stats.DataFileSize += fileSize(filepath.Join(sm.RootDir, dbName, dir, "collection.data"))
+		offsetsSize := fileSize(filepath.Join(sm.RootDir, dbName, dir, "collection.idx"))
+		if offsetsSize == 0 {
+			continue
+		}
+		stats.IndexFileSize += offsetsSize
+		if offsets, err := LoadOffsetIndex(sm.RootDir, dbName, dir); err == nil {
+			compression := offsets.CompressionStats()
+			if stats.Compression == nil {
+				stats.Compression = &CompressionStats{}
+			}
+			stats.Compression.UncompressedBytes += compression.UncompressedBytes
+			stats.Compression.CompressedBytes += compression.CompressedBytes
+		}
+	}
+	if stats.Compression != nil && stats.Compression.CompressedBytes > 0 {
+		stats.Compression.Ratio = float64(stats.Compression.UncompressedBytes) / float64(stats.Compression.CompressedBytes)
+	}
+
+	if entries, err := os.ReadDir(filepath.Join(collDir, "indexes")); err == nil {
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				stats.IndexFileSize += fileSize(filepath.Join(collDir, "indexes", entry.Name()))
+			}
+		}
+	}
+
+	return stats
+}
+
+// DatabaseStats returns database statistics including on-disk file sizes
+func (sm *StorageManager) DatabaseStats(db *Database) *DatabaseStats {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	stats := &DatabaseStats{
+		Name:        db.Name,
+		Collections: make([]*CollectionStats, 0, len(db.Collections)),
+	}
+
+	for _, coll := range db.Collections {
+		stats.add(sm.CollectionStats(db.Name, coll))
+	}
+
+	return stats
+}
+
+// fileSize returns the size of a file, or 0 if it cannot be stat'ed
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// estimateSize returns a rough estimate of the memory used by a decoded JSON value
+func estimateSize(value any) int64 {
+	switch v := value.(type) {
+	case nil:
+		return 0
+	case string:
+		return int64(len(v))
+	case bool:
+		return 1
+	case map[string]any:
+		var size int64
+		for k, item := range v {
+			size += int64(len(k)) + estimateSize(item)
+		}
+		return size
+	case []any:
+		var size int64
+		for _, item := range v {
+			size += estimateSize(item)
+		}
+		return size
+	default:
+		return 8
+	}
+}
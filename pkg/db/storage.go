@@ -1,12 +1,21 @@
 package db
 
 import (
+	"compress/gzip"
+	"crypto/cipher"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/crc32"
+	"io"
+	"math/rand/v2"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -19,8 +28,186 @@ const (
 
 	// StorageSyncInterval is how often to sync dirty data to storage
 	StorageSyncInterval = 5 * time.Second
+
+	// MemoryRootDir is the root directory that keeps everything in memory:
+	// a storage manager opened with it never touches the disk (see InMemory)
+	MemoryRootDir = ":memory:"
 )
 
+// FilePermissions holds the modes used when creating files and directories.
+// Modes are passed to the create call itself (and are still subject to the
+// process umask); existing files keep their current mode.
+type FilePermissions struct {
+	FileMode os.FileMode
+	DirMode  os.FileMode
+}
+
+// DefaultFilePermissions are the permissions used when none are configured
+var DefaultFilePermissions = FilePermissions{
+	FileMode: 0644,
+	DirMode:  0755,
+}
+
+// StorageOption configures a StorageManager
+type StorageOption func(*StorageManager)
+
+// WithFilePermissions sets the modes used for all files and directories the
+// storage manager creates, including WAL, data, and index files
+func WithFilePermissions(perms FilePermissions) StorageOption {
+	return func(sm *StorageManager) {
+		sm.perms = perms
+	}
+}
+
+// WithReadOnly opens storage in read-only mode: no WAL is created or replayed,
+// data files are only opened for reading, and all Save*/Log* methods as well
+// as document writes on loaded collections return ErrReadOnly. Operations
+// that only exist in the WAL of another process are not visible.
+func WithReadOnly() StorageOption {
+	return func(sm *StorageManager) {
+		sm.readOnly = true
+	}
+}
+
+// WithLazyLoading makes LoadDatabase read only collection metadata (schema,
+// format and index definitions); each collection's documents are loaded the
+// first time it is used. Database.LoadCollections forces loading everything.
+func WithLazyLoading() StorageOption {
+	return func(sm *StorageManager) {
+		sm.lazyLoad = true
+	}
+}
+
+// WithLoadConcurrency sets how many collections LoadDatabase loads in
+// parallel, and how many shards of a sharded collection are read or written
+// in parallel. Values below 1 mean one per CPU, which is the default.
+func WithLoadConcurrency(n int) StorageOption {
+	return func(sm *StorageManager) {
+		sm.loadConcurrency = n
+	}
+}
+
+// WithIndexBuildConcurrency sets how many goroutines LoadCollection uses to
+// rebuild each collection's indexes, split across indexes and shards of the
+// documents. Values below 1 mean one per CPU, which is the default; 1
+// builds them sequentially.
+func WithIndexBuildConcurrency(n int) StorageOption {
+	return func(sm *StorageManager) {
+		sm.indexConcurrency = n
+	}
+}
+
+// WithWALDir stores the WAL files in dir instead of the root directory, e.g.
+// on a separate disk. An empty dir keeps them in the root directory. Existing
+// WAL files are not moved, so entries not yet checkpointed must be moved by
+// hand when changing it.
+func WithWALDir(dir string) StorageOption {
+	return func(sm *StorageManager) {
+		sm.walDir = dir
+	}
+}
+
+// WithFormat sets the storage format used for collections that don't set
+// their own. The default is FormatBinary.
+func WithFormat(format StorageFormat) StorageOption {
+	return func(sm *StorageManager) {
+		sm.Format = format
+	}
+}
+
+// WithCompressionLevel sets the gzip level used to compress documents in
+// binary collections, from gzip.HuffmanOnly (-2) to gzip.BestCompression (9).
+// gzip.NoCompression (0) disables compression; the default is
+// gzip.DefaultCompression (-1). Documents already on disk keep their level
+// until the collection is saved again.
+func WithCompressionLevel(level int) StorageOption {
+	return func(sm *StorageManager) {
+		sm.compressionLevel = level
+	}
+}
+
+// WithChecksum sets the checksum algorithm of binary collection entries. The
+// default is ChecksumCRC32. A data file written with another algorithm is
+// rewritten the next time its collection is saved; until then it is read
+// with the algorithm recorded in it.
+func WithChecksum(checksum ChecksumAlgorithm) StorageOption {
+	return func(sm *StorageManager) {
+		sm.checksum = checksum
+	}
+}
+
+// WithPrettyJSON indents the JSON files written, metadata, index files and
+// the documents of JSON collections, for reading them while debugging. By
+// default they are written compact, which is smaller and faster.
+func WithPrettyJSON() StorageOption {
+	return func(sm *StorageManager) {
+		sm.prettyJSON = true
+	}
+}
+
+// WithChecksumRecovery makes loading binary collections recover documents
+// whose entry fails its checksum from another valid copy in the data file,
+// instead of failing. The copy may be older than the corrupt entry, so
+// recovered documents are reported by RecoveredDocuments.
+func WithChecksumRecovery() StorageOption {
+	return func(sm *StorageManager) {
+		sm.checksumRecovery = true
+	}
+}
+
+// WithTolerantLoad makes LoadDatabase skip collections that fail to load,
+// e.g. because of corrupt metadata or data, instead of failing the whole
+// database. Skipped collections are reported by LoadErrors and
+// Database.UnavailableCollections, and WAL entries for them are not replayed.
+func WithTolerantLoad() StorageOption {
+	return func(sm *StorageManager) {
+		sm.tolerantLoad = true
+	}
+}
+
+// WithWALOnly makes the WAL the only thing written as data changes: dirty
+// data is saved and the WAL checkpointed only by an explicit
+// Flush(true) (or DB.Flush), not periodically, on Close or after replay.
+// Restarts rebuild the state from the last saved data plus the WAL, which
+// keeps growing until the next checkpoint. Suits append-heavy workloads
+// where rewriting collections is the main cost.
+func WithWALOnly() StorageOption {
+	return func(sm *StorageManager) {
+		sm.walOnly = true
+	}
+}
+
+// WithSyncInterval sets how often the background syncer started by
+// StartBackgroundSync saves dirty data and checkpoints the WAL. The default is
+// StorageSyncInterval; 0 disables periodic syncing, leaving it to Flush and
+// Close.
+func WithSyncInterval(interval time.Duration) StorageOption {
+	return func(sm *StorageManager) {
+		sm.syncInterval = interval
+	}
+}
+
+// WithMaxWALSize checkpoints the WAL as soon as its files hold more than
+// size bytes, rather than waiting for the next sync interval, and then
+// removes the files the checkpoint covers. This bounds the WAL, and so the
+// recovery time, under bursts of writes. The checkpoint is run by the
+// background syncer started by StartBackgroundSync, so it never overlaps
+// another. 0 disables the limit, which is the default; it can't be combined
+// with WithWALOnly, where only Flush checkpoints.
+func WithMaxWALSize(size int64) StorageOption {
+	return func(sm *StorageManager) {
+		sm.maxWALSize = size
+	}
+}
+
+// WithReplayProgress sets a function called after each entry replayed from
+// the WAL when databases are loaded, e.g. to report recovery progress
+func WithReplayProgress(fn func(ReplayProgress)) StorageOption {
+	return func(sm *StorageManager) {
+		sm.replayProgress = fn
+	}
+}
+
 // DirtyEntry tracks a dirty database/collection that needs to be saved
 type DirtyEntry struct {
 	Database   string
@@ -30,59 +217,238 @@ type DirtyEntry struct {
 
 // StorageManager handles persistence
 type StorageManager struct {
-	RootDir    string
-	WAL        *WALManager
-	Format     StorageFormat // Default format for new data
-	dbManager  *DatabaseManager
-	dirty      map[string]*DirtyEntry // key: "db" or "db/collection"
-	dirtyMu    sync.Mutex
-	syncTicker *time.Ticker
-	stopChan   chan struct{}
-	wg         sync.WaitGroup
+	RootDir          string
+	WAL              *WALManager
+	walDir           string        // WAL file directory, RootDir if empty
+	Format           StorageFormat // Default format for new data
+	perms            FilePermissions
+	compressionLevel int
+	checksum         ChecksumAlgorithm
+	prettyJSON       bool
+	timeFormat       string // layout of stored time.Time values, RFC 3339 if empty
+	encryptionKey    []byte
+	fieldCipher      cipher.AEAD // nil without an encryption key
+	readOnly         bool
+	writableLoads    bool // loaded databases take writes in memory despite readOnly (see RecoverTo)
+	lazyLoad         bool
+	tolerantLoad     bool
+	checksumRecovery bool
+	recovered        []RecoveredDocument
+	recoveredMu      sync.Mutex
+	walOnly          bool
+	memory           bool
+	loadErrors       []*LoadError
+	loadErrorsMu     sync.Mutex
+	loadConcurrency  int
+	indexConcurrency int
+	maxOpenFiles     int           // data files loads and saves keep open at once, 0 for no limit
+	fileSlots        chan struct{} // one value per open data file under maxOpenFiles, nil without a limit
+	openFiles        atomic.Int64  // data files open now
+	peakOpenFiles    atomic.Int64  // most data files open at once so far
+	replayProgress   func(ReplayProgress)
+	clock            Clock
+	lastReplay       *ReplaySummary
+	dbManager        *DatabaseManager
+	dirty            map[string]*DirtyEntry // key: "db" or "db/collection"
+	dirtyMu          sync.Mutex
+	syncMu           sync.Mutex // serializes saving dirty data with checkpointing
+	syncInterval     time.Duration
+	syncTicker       *time.Ticker
+	syncNow          chan struct{} // requests a background sync before the next tick
+	syncRunning      atomic.Bool   // the background syncer is running
+	syncErr          error         // error of the last background sync
+	syncErrMu        sync.Mutex
+	maxPending       int
+	maxWALSize       int64 // bytes of WAL that trigger a checkpoint, 0 for no limit
+	backpressure     BackpressureMode
+	checkpointed     chan struct{} // closed and replaced on every checkpoint
+	checkpointedMu   sync.Mutex
+	stopChan         chan struct{}
+	wg               sync.WaitGroup
 }
 
 // NewStorageManager creates a new storage manager
-func NewStorageManager(rootDir string) (*StorageManager, error) {
-	if err := os.MkdirAll(rootDir, 0755); err != nil {
+func NewStorageManager(rootDir string, opts ...StorageOption) (*StorageManager, error) {
+	sm := &StorageManager{
+		RootDir:          rootDir,
+		Format:           FormatBinary, // Use binary format by default
+		perms:            DefaultFilePermissions,
+		compressionLevel: gzip.DefaultCompression,
+		checksum:         ChecksumCRC32,
+		syncInterval:     StorageSyncInterval,
+		dirty:            make(map[string]*DirtyEntry),
+		memory:           rootDir == MemoryRootDir,
+		syncNow:          make(chan struct{}, 1),
+		backpressure:     BackpressureBlock,
+		checkpointed:     make(chan struct{}),
+		clock:            SystemClock,
+	}
+
+	for _, opt := range opts {
+		opt(sm)
+	}
+
+	if sm.Format != FormatJSON && sm.Format != FormatBinary {
+		return nil, fmt.Errorf("invalid storage format '%s'", sm.Format)
+	}
+	if sm.compressionLevel < gzip.HuffmanOnly || sm.compressionLevel > gzip.BestCompression {
+		return nil, fmt.Errorf("invalid compression level %d, must be between %d and %d",
+			sm.compressionLevel, gzip.HuffmanOnly, gzip.BestCompression)
+	}
+	if err := sm.checksum.Validate(); err != nil {
+		return nil, err
+	}
+	if len(sm.encryptionKey) > 0 {
+		fieldCipher, err := newFieldCipher(sm.encryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		sm.fieldCipher = fieldCipher
+	}
+
+	if sm.clock == nil {
+		return nil, fmt.Errorf("clock must not be nil")
+	}
+	if sm.syncInterval < 0 {
+		return nil, fmt.Errorf("invalid sync interval %s", sm.syncInterval)
+	}
+	if err := sm.validateBackpressure(); err != nil {
+		return nil, err
+	}
+	if err := sm.validateMaxOpenFiles(); err != nil {
+		return nil, err
+	}
+	if sm.maxWALSize < 0 {
+		return nil, fmt.Errorf("invalid max WAL size %d", sm.maxWALSize)
+	}
+	if sm.maxWALSize > 0 && sm.walOnly {
+		return nil, fmt.Errorf("a max WAL size needs background checkpoints, which WAL-only mode disables")
+	}
+
+	if sm.syncInterval > 0 && !sm.walOnly && !sm.memory {
+		sm.syncTicker = time.NewTicker(sm.syncInterval)
+	}
+	sm.stopChan = make(chan struct{})
+
+	if sm.memory {
+		return sm, nil
+	}
+
+	if sm.readOnly {
+		if _, err := os.Stat(rootDir); err != nil {
+			return nil, fmt.Errorf("failed to open root directory: %w", err)
+		}
+		return sm, nil
+	}
+
+	if err := os.MkdirAll(rootDir, sm.perms.DirMode); err != nil {
 		return nil, fmt.Errorf("failed to create root directory: %w", err)
 	}
 
-	wal, err := NewWALManager(rootDir)
+	wal, err := NewWALManager(sm.WALDir(), sm.perms)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create WAL manager: %w", err)
 	}
+	wal.clock = sm.clock
 
-	sm := &StorageManager{
-		RootDir:    rootDir,
-		WAL:        wal,
-		Format:     FormatBinary, // Use binary format by default
-		dirty:      make(map[string]*DirtyEntry),
-		syncTicker: time.NewTicker(StorageSyncInterval),
-		stopChan:   make(chan struct{}),
-	}
+	sm.WAL = wal
 
 	return sm, nil
 }
 
+// WALDir returns the directory of the WAL files
+func (sm *StorageManager) WALDir() string {
+	if sm.walDir == "" {
+		return sm.RootDir
+	}
+	return sm.walDir
+}
+
+// WALOnly reports whether data is only saved on explicit checkpoints (see WithWALOnly)
+func (sm *StorageManager) WALOnly() bool {
+	return sm.walOnly
+}
+
+// InMemory reports whether the storage manager was created with
+// MemoryRootDir. It has no WAL and no files: logging and saving do nothing,
+// loading finds no databases, and everything is lost when it is closed.
+func (sm *StorageManager) InMemory() bool {
+	return sm.memory
+}
+
+// ReadOnly reports whether the storage manager was opened in read-only mode
+func (sm *StorageManager) ReadOnly() bool {
+	return sm.readOnly
+}
+
+// LoadError records a collection that failed to load with WithTolerantLoad
+type LoadError struct {
+	Database   string
+	Collection string
+	Err        error
+}
+
+func (e *LoadError) Error() string {
+	return fmt.Sprintf("failed to load collection '%s/%s': %v", e.Database, e.Collection, e.Err)
+}
+
+func (e *LoadError) Unwrap() error {
+	return e.Err
+}
+
+// LoadErrors returns the collections skipped by LoadDatabase because they
+// failed to load, when WithTolerantLoad is set
+func (sm *StorageManager) LoadErrors() []*LoadError {
+	sm.loadErrorsMu.Lock()
+	defer sm.loadErrorsMu.Unlock()
+	return append([]*LoadError(nil), sm.loadErrors...)
+}
+
+// RecoveredDocuments returns the documents recovered from another copy while
+// loading, when WithChecksumRecovery is set
+func (sm *StorageManager) RecoveredDocuments() []RecoveredDocument {
+	sm.recoveredMu.Lock()
+	defer sm.recoveredMu.Unlock()
+	return append([]RecoveredDocument(nil), sm.recovered...)
+}
+
+// LastReplay returns the summary of the WAL replay done by LoadAllDatabases,
+// or nil if it hasn't run (or storage is read-only)
+func (sm *StorageManager) LastReplay() *ReplaySummary {
+	return sm.lastReplay
+}
+
 // StartBackgroundSync starts the background storage syncer
 // Must be called after LoadAllDatabases sets dbManager
 func (sm *StorageManager) StartBackgroundSync(dbManager *DatabaseManager) {
 	sm.dbManager = dbManager
 	sm.wg.Add(1)
+	sm.syncRunning.Store(true)
 	go sm.backgroundStorageSyncer()
 }
 
 // backgroundStorageSyncer periodically saves dirty data to storage
 func (sm *StorageManager) backgroundStorageSyncer() {
 	defer sm.wg.Done()
+	defer sm.syncRunning.Store(false)
+
+	// A nil channel never fires, so without a ticker only the final sync runs
+	var tick <-chan time.Time
+	if sm.syncTicker != nil {
+		tick = sm.syncTicker.C
+	}
 
 	for {
 		select {
 		case <-sm.stopChan:
 			// Final sync before shutdown
-			sm.syncDirtyToStorage()
+			if !sm.walOnly {
+				sm.syncDirtyToStorage()
+			}
 			return
-		case <-sm.syncTicker.C:
+		case <-tick:
+			sm.syncDirtyToStorage()
+		case <-sm.syncNow:
 			sm.syncDirtyToStorage()
 		}
 	}
@@ -90,10 +456,68 @@ func (sm *StorageManager) backgroundStorageSyncer() {
 
 // syncDirtyToStorage saves all dirty entries to storage and checkpoints
 func (sm *StorageManager) syncDirtyToStorage() {
+	sm.syncMu.Lock()
+	defer sm.syncMu.Unlock()
+
+	err := sm.saveAndCheckpointLocked()
+	if err != nil {
+		fmt.Printf("Failed to sync to storage: %v\n", err)
+	}
+
+	sm.syncErrMu.Lock()
+	sm.syncErr = err
+	sm.syncErrMu.Unlock()
+}
+
+// saveAndCheckpointLocked saves all dirty entries and checkpoints the WAL up
+// to the offset reached before saving began (caller must hold syncMu).
+// Writes mark their collection dirty before appending to the WAL, so the
+// change of every entry below that offset is in the data saved here; entries
+// appended while saving stay after the checkpoint and are replayed.
+func (sm *StorageManager) saveAndCheckpointLocked() error {
+	if sm.memory {
+		return nil
+	}
+
+	offset := sm.WAL.Offset()
+
+	if err := sm.saveDirtyLocked(); err != nil {
+		return fmt.Errorf("failed to save dirty data: %w", err)
+	}
+
+	if err := sm.WAL.Checkpoint(offset); err != nil {
+		return fmt.Errorf("failed to checkpoint: %w", err)
+	}
+	sm.notifyCheckpointed()
+
+	if sm.walOverSize() {
+		if err := sm.WAL.Truncate(); err != nil {
+			return fmt.Errorf("failed to truncate WAL: %w", err)
+		}
+	}
+	return nil
+}
+
+// walOverSize reports whether the WAL holds more than the WithMaxWALSize limit
+func (sm *StorageManager) walOverSize() bool {
+	return sm.maxWALSize > 0 && sm.WAL.Size() > sm.maxWALSize
+}
+
+// checkWALSize requests a checkpoint once the WAL outgrows the
+// WithMaxWALSize limit
+func (sm *StorageManager) checkWALSize() {
+	if sm.walOverSize() {
+		sm.requestSync()
+	}
+}
+
+// saveDirtyLocked saves all dirty entries to storage (caller must hold syncMu).
+// Entries that fail to save stay dirty and their errors are returned joined.
+func (sm *StorageManager) saveDirtyLocked() error {
 	sm.dirtyMu.Lock()
 	if len(sm.dirty) == 0 {
 		sm.dirtyMu.Unlock()
-		return
+		return nil
 	}
 
 	// Copy dirty entries
@@ -105,10 +529,11 @@ func (sm *StorageManager) syncDirtyToStorage() {
 	sm.dirtyMu.Unlock()
 
 	if sm.dbManager == nil {
-		return
+		return nil
 	}
 
 	// Save each dirty entry
+	var errs []error
 	for key, entry := range toSync {
 		var err error
 		if entry.Collection == "" {
@@ -132,14 +557,34 @@ func (sm *StorageManager) syncDirtyToStorage() {
 			sm.dirtyMu.Lock()
 			sm.dirty[key] = entry
 			sm.dirtyMu.Unlock()
-			fmt.Printf("Failed to sync %s to storage: %v\n", key, err)
+			errs = append(errs, fmt.Errorf("failed to sync %s: %w", key, err))
 		}
 	}
 
-	// Checkpoint after successful sync
-	if err := sm.Checkpoint(); err != nil {
-		fmt.Printf("Failed to checkpoint after storage sync: %v\n", err)
+	return errors.Join(errs...)
+}
+
+// Flush makes all logged operations durable: pending WAL entries are written
+// and fsynced. With checkpoint set, dirty data is also saved to storage and
+// the WAL is checkpointed, so a restart does not need to replay anything.
+// Use it before taking a backup or on controlled shutdown.
+func (sm *StorageManager) Flush(checkpoint bool) error {
+	if sm.readOnly || sm.memory {
+		return nil
+	}
+
+	if err := sm.WAL.Sync(); err != nil {
+		return err
+	}
+
+	if !checkpoint {
+		return nil
 	}
+
+	sm.syncMu.Lock()
+	defer sm.syncMu.Unlock()
+
+	return sm.saveAndCheckpointLocked()
 }
 
 // MarkDirty marks a database or collection as needing to be saved
@@ -155,7 +600,7 @@ func (sm *StorageManager) MarkDirty(dbName, collName string) {
 	sm.dirty[key] = &DirtyEntry{
 		Database:   dbName,
 		Collection: collName,
-		Timestamp:  time.Now(),
+		Timestamp:  sm.clock.Now(),
 	}
 }
 
@@ -179,8 +624,15 @@ func (sm *StorageManager) Close() error {
 
 // SaveDatabase saves the entire database to disk
 func (sm *StorageManager) SaveDatabase(db *Database) error {
+	if sm.readOnly {
+		return ErrReadOnly
+	}
+	if sm.memory {
+		return nil
+	}
+
 	dbDir := filepath.Join(sm.RootDir, db.Name)
-	if err := os.MkdirAll(dbDir, 0755); err != nil {
+	if err := os.MkdirAll(dbDir, sm.perms.DirMode); err != nil {
 		return fmt.Errorf("failed to create database directory: %w", err)
 	}
 
@@ -209,87 +661,202 @@ func (sm *StorageManager) SaveDatabase(db *Database) error {
 
 // SaveCollection saves a collection to disk
 func (sm *StorageManager) SaveCollection(dbName string, coll *Collection) error {
+	if sm.readOnly {
+		return ErrReadOnly
+	}
+	if sm.memory {
+		return nil
+	}
+
+	// Saves can come from the background syncer, Flush and eviction at once.
+	// Writers only need mu, so they are not held up by a save in progress.
+	coll.saveMu.Lock()
+	defer coll.saveMu.Unlock()
+
 	collDir := filepath.Join(sm.RootDir, dbName, coll.Name)
-	if err := os.MkdirAll(collDir, 0755); err != nil {
+	if err := os.MkdirAll(collDir, sm.perms.DirMode); err != nil {
 		return fmt.Errorf("failed to create collection directory: %w", err)
 	}
 
+	// Capture a consistent snapshot under the read lock and do all I/O after
+	// releasing it, so writers are only blocked for the in-memory copy.
+	// Documents are shared, which is safe because stored documents are never
+	// modified in place.
 	coll.mu.RLock()
-	defer coll.mu.RUnlock()
+
+	// Unloaded collections haven't changed since they were last saved, and
+	// neither have collections whose changes have all been saved here
+	if coll.unloaded || (coll.savedDir == collDir && coll.savedCount == coll.modCount) {
+		coll.mu.RUnlock()
+		return nil
+	}
+	modCount := coll.modCount
+	shards := coll.Shards
+
+	// Collections without an explicit format use the storage default
+	format := coll.Format
+	if format == "" {
+		format = sm.Format
+	}
 
 	// Save collection metadata (schema and index definitions)
 	metaPath := filepath.Join(collDir, "collection.meta.json")
-	meta := struct {
-		Name    string            `json:"name"`
-		Schema  *Schema           `json:"schema,omitempty"`
-		Indexes map[string]string `json:"indexes"` // index name -> field name
-		Format  StorageFormat     `json:"format"`  // Storage format
-	}{
-		Name:    coll.Name,
-		Schema:  coll.Schema,
-		Indexes: make(map[string]string),
-		Format:  sm.Format,
+	meta := collectionMeta{
+		Name:      coll.Name,
+		Schema:    coll.Schema,
+		Indexes:   make(map[string]string),
+		Format:    format,
+		IDKey:     coll.IDKey,
+		Shards:    coll.Shards,
+		Collation: coll.Collation,
+	}
+	order := coll.insertionOrderIDsLocked()
+	meta.InsertionOrder = order != nil
+	if coll.Limits != (QueryLimits{}) {
+		limits := coll.Limits
+		meta.Limits = &limits
 	}
 
+	// Index files would hold encrypted fields in plaintext, so those indexes
+	// are only rebuilt on load
+	encrypted := coll.Schema.encryptedFields()
+	indexes := make([]*IndexData, 0, len(coll.Indexes))
 	for name, idx := range coll.Indexes {
 		meta.Indexes[name] = idx.FieldName
+		if coll.Schema.isEncrypted(idx.FieldName) {
+			continue
+		}
+		data, err := idx.Serialize()
+		if err != nil {
+			coll.mu.RUnlock()
+			return fmt.Errorf("failed to serialize index %s: %w", name, err)
+		}
+		indexes = append(indexes, data)
 	}
 
-	if err := sm.writeJSON(metaPath, meta); err != nil {
-		return fmt.Errorf("failed to save collection metadata: %w", err)
+	docs := make([]*Document, 0, len(coll.Documents))
+	for _, doc := range coll.Documents {
+		docs = append(docs, doc)
 	}
+	coll.sortByInsertionLocked(docs)
+	prevSealed := coll.sealed
 
-	// Save based on format
-	if sm.Format == FormatBinary {
-		// Save to binary format with compression
-		writer, err := NewBinaryCollectionWriter(sm.RootDir, dbName, coll.Name)
-		if err != nil {
-			return fmt.Errorf("failed to create binary writer: %w", err)
-		}
-		defer writer.Close(sm.RootDir, dbName, coll.Name)
+	coll.mu.RUnlock()
 
-		for _, doc := range coll.Documents {
-			if err := writer.WriteDocument(doc); err != nil {
-				return fmt.Errorf("failed to write document: %w", err)
+	var sealed *sealedCopies
+	if len(encrypted) > 0 {
+		sealed = &sealedCopies{dir: collDir, fields: encrypted, docs: make(map[string]sealedDocument)}
+	}
+	if len(encrypted) > 0 || sm.timeFormat != "" {
+		for i, doc := range docs {
+			stored, unchanged := prevSealed.lookup(collDir, encrypted, doc)
+			if !unchanged {
+				var err error
+				if stored, err = sm.storedDocument(doc, encrypted); err != nil {
+					return err
+				}
+			}
+			if sealed != nil && stored != doc {
+				sealed.docs[doc.ID] = sealedDocument{doc: doc, stored: stored}
 			}
+			docs[i] = stored
 		}
+	}
+
+	if err := sm.writeJSON(metaPath, meta); err != nil {
+		return fmt.Errorf("failed to save collection metadata: %w", err)
+	}
+	if err := sm.saveInsertionOrder(collDir, order); err != nil {
+		return err
+	}
 
-		if err := writer.Flush(sm.RootDir, dbName, coll.Name); err != nil {
-			return fmt.Errorf("failed to flush writer: %w", err)
+	// Save based on format
+	if format == FormatBinary {
+		// Save to binary format with compression, one data file per shard
+		if shards > 1 {
+			if err := sm.writeShards(dbName, coll.Name, shards, docs); err != nil {
+				return err
+			}
+		} else if err := sm.writeBinaryDocuments(dbName, coll.Name, docs); err != nil {
+			return err
 		}
 
 		// Save indexes to disk
-		for _, idx := range coll.Indexes {
-			if err := idx.SaveToDisk(sm.RootDir, dbName, coll.Name); err != nil {
-				return fmt.Errorf("failed to save index %s: %w", idx.Name, err)
+		for _, data := range indexes {
+			if err := saveIndexData(data, sm.RootDir, dbName, coll.Name, sm.perms, sm.prettyJSON); err != nil {
+				return fmt.Errorf("failed to save index %s: %w", data.Name, err)
 			}
 		}
+		if err := removeStaleIndexFiles(indexes, sm.RootDir, dbName, coll.Name); err != nil {
+			return err
+		}
 	} else {
 		// Save to JSON format (legacy)
 		docsPath := filepath.Join(collDir, "documents.json")
-		docs := make([]*Document, 0, len(coll.Documents))
-		for _, doc := range coll.Documents {
-			docs = append(docs, doc)
-		}
-
 		if err := sm.writeJSON(docsPath, docs); err != nil {
 			return fmt.Errorf("failed to save documents: %w", err)
 		}
 	}
 
+	coll.markSaved(collDir, modCount, sealed)
+	return nil
+}
+
+// writeBinaryDocuments writes docs, all the documents of a collection or
+// shard, to the binary data file in dir (a collection name or shard path)
+func (sm *StorageManager) writeBinaryDocuments(dbName, dir string, docs []*Document) error {
+	sm.acquireFile()
+	defer sm.releaseFile()
+
+	writer, err := newBinaryCollectionWriter(sm.RootDir, dbName, dir, sm.perms, sm.checksum)
+	if err != nil {
+		return fmt.Errorf("failed to create binary writer: %w", err)
+	}
+	defer writer.Close(sm.RootDir, dbName, dir)
+	writer.compressionLevel = sm.compressionLevel
+
+	written := make(map[string]bool, len(docs))
+	for _, doc := range docs {
+		if err := writer.WriteDocument(doc); err != nil {
+			return fmt.Errorf("failed to write document: %w", err)
+		}
+		written[doc.ID] = true
+	}
+
+	// The writer starts from the saved index, which still lists the
+	// documents deleted since; only the documents just written are live
+	writer.retain(written)
+
+	if err := writer.Flush(sm.RootDir, dbName, dir); err != nil {
+		return fmt.Errorf("failed to flush writer: %w", err)
+	}
 	return nil
 }
 
+// markSaved records that the collection as of modCount was saved to dir,
+// with the stored copies of its documents with encrypted fields in sealed.
+// An older snapshot finishing after a newer one doesn't move it back.
+func (c *Collection) markSaved(dir string, modCount uint64, sealed *sealedCopies) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.savedDir != dir || modCount > c.savedCount {
+		c.savedDir, c.savedCount = dir, modCount
+		c.sealed = sealed
+	}
+}
+
 // LoadDatabase loads a database from disk
 func (sm *StorageManager) LoadDatabase(dbName string) (*Database, error) {
 	dbDir := filepath.Join(sm.RootDir, dbName)
 
 	// Check if database exists
-	if _, err := os.Stat(dbDir); os.IsNotExist(err) {
-		return nil, fmt.Errorf("database '%s' does not exist", dbName)
+	if _, err := os.Stat(dbDir); sm.memory || os.IsNotExist(err) {
+		return nil, fmt.Errorf("database '%s' %w", dbName, ErrNotFound)
 	}
 
 	db := NewDatabase(dbName)
+	db.readOnly = sm.readOnly && !sm.writableLoads
 
 	// Load database metadata if it exists
 	metaPath := filepath.Join(dbDir, "db.meta.json")
@@ -300,6 +867,8 @@ func (sm *StorageManager) LoadDatabase(dbName string) (*Database, error) {
 		}
 		if err := sm.readJSON(metaPath, &meta); err == nil {
 			db.SchemaVersion = meta.SchemaVersion
+		} else if errors.Is(err, ErrChecksumMismatch) {
+			return nil, fmt.Errorf("failed to load database metadata: %w", err)
 		}
 	}
 
@@ -326,82 +895,172 @@ func (sm *StorageManager) LoadDatabase(dbName string) (*Database, error) {
 		return nil, fmt.Errorf("failed to read database directory: %w", err)
 	}
 
+	var collNames []string
 	for _, entry := range entries {
 		if entry.IsDir() {
-			coll, err := sm.LoadCollection(dbName, entry.Name())
-			if err != nil {
-				return nil, fmt.Errorf("failed to load collection '%s': %w", entry.Name(), err)
+			collNames = append(collNames, entry.Name())
+		}
+	}
+
+	// Load collections in parallel; each worker only touches its own slot
+	colls := make([]*Collection, len(collNames))
+	collErrs := make([]error, len(collNames))
+	err = sm.loadParallel(len(collNames), func(i int) error {
+		if sm.lazyLoad {
+			colls[i], collErrs[i] = sm.openCollectionLazily(dbName, collNames[i])
+		} else {
+			colls[i], collErrs[i] = sm.LoadCollection(dbName, collNames[i])
+		}
+		if collErrs[i] != nil && !sm.tolerantLoad {
+			return fmt.Errorf("failed to load collection '%s': %w", collNames[i], collErrs[i])
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, coll := range colls {
+		if collErrs[i] != nil {
+			if db.unavailable == nil {
+				db.unavailable = make(map[string]error)
 			}
-			db.Collections[coll.Name] = coll
+			db.unavailable[collNames[i]] = collErrs[i]
+
+			sm.loadErrorsMu.Lock()
+			sm.loadErrors = append(sm.loadErrors, &LoadError{Database: dbName, Collection: collNames[i], Err: collErrs[i]})
+			sm.loadErrorsMu.Unlock()
+			continue
 		}
+		db.Collections[coll.Name] = coll
 	}
 
 	return db, nil
 }
 
+// loadParallel calls load for 0..n-1 using at most loadConcurrency workers
+// and returns all errors joined
+func (sm *StorageManager) loadParallel(n int, load func(i int) error) error {
+	return runParallel(n, workerCount(sm.loadConcurrency), load)
+}
+
+// workerCount returns the number of workers for a concurrency setting, where
+// values below 1 mean one per CPU
+func workerCount(concurrency int) int {
+	if concurrency < 1 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return concurrency
+}
+
+// runParallel calls fn for 0..n-1 using at most workers goroutines and
+// returns all errors joined
+func runParallel(n, workers int, fn func(i int) error) error {
+	workers = min(workers, n)
+
+	errs := make([]error, n)
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				errs[i] = fn(i)
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
 // LoadCollection loads a collection from disk
 func (sm *StorageManager) LoadCollection(dbName, collName string) (*Collection, error) {
+	if sm.memory {
+		return nil, fmt.Errorf("collection '%s/%s' %w", dbName, collName, ErrNotFound)
+	}
 	collDir := filepath.Join(sm.RootDir, dbName, collName)
 
 	// Load metadata
-	metaPath := filepath.Join(collDir, "collection.meta.json")
-	var meta struct {
-		Name    string            `json:"name"`
-		Schema  *Schema           `json:"schema,omitempty"`
-		Indexes map[string]string `json:"indexes"`
-		Format  StorageFormat     `json:"format"`
-	}
-
-	if err := sm.readJSON(metaPath, &meta); err != nil {
-		return nil, fmt.Errorf("failed to load collection metadata: %w", err)
+	meta, err := sm.loadCollectionMeta(dbName, collName)
+	if err != nil {
+		return nil, err
 	}
 
-	// Default to JSON if not specified (for backward compatibility)
-	if meta.Format == "" {
-		meta.Format = FormatJSON
+	coll := NewCollection(meta.Name, meta.Schema)
+	coll.Format = meta.Format
+	if meta.Limits != nil {
+		coll.Limits = *meta.Limits
 	}
+	coll.IDKey = meta.IDKey
+	coll.Shards = meta.Shards
+	coll.Collation = meta.Collation
+	coll.readOnly = sm.readOnly && !sm.writableLoads
 
-	coll := NewCollection(meta.Name, meta.Schema)
+	rebuilt := false
+	recovered := false
+	sealed := make(map[string]sealedDocument)
 
 	// Load based on format
 	if meta.Format == FormatBinary {
-		// Load from binary format
-		reader, err := NewBinaryCollectionReader(sm.RootDir, dbName, collName)
+		// Load from binary format, reading the shards in parallel
+		var docs []*Document
+		if meta.Shards > 1 {
+			docs, recovered, err = sm.readShards(dbName, collName, meta.Shards)
+		} else {
+			docs, recovered, err = sm.readBinaryDocuments(dbName, collName, collName)
+		}
 		if err != nil {
-			// If binary file doesn't exist yet, it's ok (empty collection)
-			if !os.IsNotExist(err) {
-				return nil, fmt.Errorf("failed to create binary reader: %w", err)
+			return nil, err
+		}
+		for _, doc := range docs {
+			if err := sm.decryptLoaded(doc, meta.Schema, sealed); err != nil {
+				return nil, err
 			}
-		} else {
-			defer reader.Close()
+			coll.Documents[doc.ID] = doc
+		}
 
-			docs, err := reader.ReadAllDocuments()
+		// Load indexes from disk. Recovered documents may differ from what
+		// the saved indexes describe, so they are all rebuilt instead.
+		indexes := make(map[string]*Index)
+		if !recovered {
+			indexes, err = LoadAllIndexes(sm.RootDir, dbName, collName)
 			if err != nil {
-				return nil, fmt.Errorf("failed to read documents: %w", err)
-			}
-
-			for _, doc := range docs {
-				coll.Documents[doc.ID] = doc
+				return nil, fmt.Errorf("failed to load indexes: %w", err)
 			}
 		}
 
-		// Load indexes from disk
-		indexes, err := LoadAllIndexes(sm.RootDir, dbName, collName)
-		if err != nil {
-			return nil, fmt.Errorf("failed to load indexes: %w", err)
-		}
-
 		// Replace default _id index if it was loaded
 		for name, idx := range indexes {
 			coll.Indexes[name] = idx
 		}
 
-		// If _id index wasn't loaded, rebuild it
+		// Rebuild indexes whose files are missing or in the legacy format,
+		// and leave the collection unsaved so their files get written
+		var build []*Index
 		if _, exists := indexes["_id"]; !exists {
-			for _, doc := range coll.Documents {
-				coll.Indexes["_id"].AddToIndex(doc)
+			build = append(build, coll.Indexes["_id"])
+			rebuilt = true
+		}
+		for indexName, fieldName := range meta.Indexes {
+			if _, exists := coll.Indexes[indexName]; exists {
+				continue
+			}
+			idx := NewIndex(indexName, fieldName)
+			build = append(build, idx)
+			coll.Indexes[indexName] = idx
+			// Indexes on encrypted fields are never saved
+			if !meta.Schema.isEncrypted(fieldName) {
+				rebuilt = true
 			}
 		}
+		buildIndexes(coll.Documents, build, workerCount(sm.indexConcurrency))
 	} else {
 		// Load from JSON format (legacy)
 		docsPath := filepath.Join(collDir, "documents.json")
@@ -415,31 +1074,149 @@ func (sm *StorageManager) LoadCollection(dbName, collName string) (*Collection,
 
 		// Restore documents
 		for _, doc := range docs {
+			if err := sm.decryptLoaded(doc, meta.Schema, sealed); err != nil {
+				return nil, err
+			}
 			coll.Documents[doc.ID] = doc
 		}
 
-		// Recreate indexes (except _id which already exists)
+		// Recreate indexes (_id already exists)
+		var build []*Index
 		for indexName, fieldName := range meta.Indexes {
 			if indexName != "_id" {
-				idx := NewIndex(indexName, fieldName)
-				for _, doc := range coll.Documents {
-					idx.AddToIndex(doc)
-				}
-				coll.Indexes[indexName] = idx
-			} else {
-				// Rebuild _id index
-				for _, doc := range coll.Documents {
-					coll.Indexes["_id"].AddToIndex(doc)
-				}
+				coll.Indexes[indexName] = NewIndex(indexName, fieldName)
 			}
+			build = append(build, coll.Indexes[indexName])
+		}
+		buildIndexes(coll.Documents, build, workerCount(sm.indexConcurrency))
+	}
+
+	if meta.InsertionOrder {
+		order, err := sm.loadInsertionOrder(collDir)
+		if err != nil {
+			return nil, err
 		}
+		coll.setOrderLocked(order)
 	}
 
+	coll.recomputeMemSizeLocked()
+	if !rebuilt {
+		coll.savedDir, coll.savedCount = collDir, coll.modCount
+	}
+	if len(sealed) > 0 {
+		coll.sealed = &sealedCopies{dir: collDir, fields: meta.Schema.encryptedFields(), docs: sealed}
+	}
+	return coll, nil
+}
+
+// readBinaryDocuments reads the documents of the binary data file in dir (a
+// collection name or shard path) of collection collName. A missing data file
+// is an empty collection. It reports whether any corrupt entry was replaced
+// by a recovered copy, recording those in sm.recovered.
+func (sm *StorageManager) readBinaryDocuments(dbName, collName, dir string) ([]*Document, bool, error) {
+	if !sm.readOnly {
+		if err := finishCompaction(filepath.Join(sm.RootDir, dbName, dir)); err != nil {
+			return nil, false, err
+		}
+	}
+
+	sm.acquireFile()
+	defer sm.releaseFile()
+
+	reader, err := NewBinaryCollectionReader(sm.RootDir, dbName, dir)
+	if err != nil {
+		// If binary file doesn't exist yet, it's ok (empty collection)
+		if os.IsNotExist(err) {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("failed to create binary reader: %w", err)
+	}
+	defer reader.Close()
+	reader.SetRecovery(sm.checksumRecovery)
+	reader.collName = collName // recovered documents name the collection, not the shard
+
+	docs, err := reader.ReadAllDocuments()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read documents: %w", err)
+	}
+
+	recovered := reader.Recovered()
+	if len(recovered) > 0 {
+		sm.recoveredMu.Lock()
+		sm.recovered = append(sm.recovered, recovered...)
+		sm.recoveredMu.Unlock()
+	}
+	return docs, len(recovered) > 0, nil
+}
+
+// collectionMeta is the content of collection.meta.json
+type collectionMeta struct {
+	Name      string            `json:"name"`
+	Schema    *Schema           `json:"schema,omitempty"`
+	Indexes   map[string]string `json:"indexes"` // index name -> field name
+	Format    StorageFormat     `json:"format"`  // Storage format
+	Limits    *QueryLimits      `json:"limits,omitempty"`
+	IDKey     *IDKey            `json:"id_key,omitempty"`
+	Shards    int               `json:"shards,omitempty"` // binary data files; 0 or 1 means one
+	Collation Collation         `json:"collation,omitempty"`
+
+	InsertionOrder bool `json:"insertion_order,omitempty"` // document IDs in insertion order are in order.json
+}
+
+// loadCollectionMeta reads a collection's metadata file
+func (sm *StorageManager) loadCollectionMeta(dbName, collName string) (*collectionMeta, error) {
+	metaPath := filepath.Join(sm.RootDir, dbName, collName, "collection.meta.json")
+
+	var meta collectionMeta
+	if err := sm.readJSON(metaPath, &meta); err != nil {
+		return nil, fmt.Errorf("failed to load collection metadata: %w", err)
+	}
+
+	// Default to JSON if not specified (for backward compatibility)
+	if meta.Format == "" {
+		meta.Format = FormatJSON
+	}
+
+	return &meta, nil
+}
+
+// openCollectionLazily creates a collection from its metadata alone; its
+// documents are loaded on first use
+func (sm *StorageManager) openCollectionLazily(dbName, collName string) (*Collection, error) {
+	meta, err := sm.loadCollectionMeta(dbName, collName)
+	if err != nil {
+		return nil, err
+	}
+
+	coll := NewCollection(meta.Name, meta.Schema)
+	coll.Format = meta.Format
+	if meta.Limits != nil {
+		coll.Limits = *meta.Limits
+	}
+	coll.IDKey = meta.IDKey
+	coll.Shards = meta.Shards
+	coll.Collation = meta.Collation
+	if meta.InsertionOrder {
+		coll.order = make(map[string]uint64) // filled in when loaded
+	}
+	coll.readOnly = sm.readOnly && !sm.writableLoads
+	for indexName, fieldName := range meta.Indexes {
+		coll.Indexes[indexName] = NewIndex(indexName, fieldName)
+	}
+	coll.savedDir = filepath.Join(sm.RootDir, dbName, collName)
+
+	coll.unloadLocked(func() (*Collection, error) {
+		return sm.LoadCollection(dbName, collName)
+	})
+
 	return coll, nil
 }
 
 // DatabaseExists checks if a database exists on disk
 func (sm *StorageManager) DatabaseExists(dbName string) bool {
+	if sm.memory {
+		return false
+	}
 	dbDir := filepath.Join(sm.RootDir, dbName)
 	_, err := os.Stat(dbDir)
 	return err == nil
@@ -447,54 +1224,109 @@ func (sm *StorageManager) DatabaseExists(dbName string) bool {
 
 // DeleteDatabase deletes a database from disk
 func (sm *StorageManager) DeleteDatabase(dbName string) error {
+	if sm.readOnly {
+		return ErrReadOnly
+	}
+	if sm.memory {
+		return nil
+	}
+
 	dbDir := filepath.Join(sm.RootDir, dbName)
 	return os.RemoveAll(dbDir)
 }
 
-// LoadAllDatabases loads all databases from disk into a DatabaseManager
-func (sm *StorageManager) LoadAllDatabases() (*DatabaseManager, error) {
-	dm := NewDatabaseManager()
-
-	// Create root dir if it doesn't exist
-	if err := os.MkdirAll(sm.RootDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create root directory: %w", err)
+// isWALDir reports whether path is the WAL directory
+func (sm *StorageManager) isWALDir(path string) bool {
+	if sm.walDir == "" {
+		return false
+	}
+	walDir, err := filepath.Abs(sm.walDir)
+	if err != nil {
+		return false
 	}
+	path, err = filepath.Abs(path)
+	return err == nil && path == walDir
+}
 
-	// Read all subdirectories (each is a database)
+// loadSavedDatabases loads every database saved in the root directory into dm
+func (sm *StorageManager) loadSavedDatabases(dm *DatabaseManager) error {
 	entries, err := os.ReadDir(sm.RootDir)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read root directory: %w", err)
+		return fmt.Errorf("failed to read root directory: %w", err)
 	}
 
 	for _, entry := range entries {
-		// Skip WAL files (wal-*.bin and wal.checkpoint)
-		if strings.HasPrefix(entry.Name(), WALFilePrefix) || entry.Name() == WALCheckpointFile {
+		// Every directory is a database, except the WAL directory if it was
+		// put inside the root
+		if !entry.IsDir() || sm.isWALDir(filepath.Join(sm.RootDir, entry.Name())) {
 			continue
 		}
 
-		if entry.IsDir() {
-			db, err := sm.LoadDatabase(entry.Name())
-			if err != nil {
-				return nil, fmt.Errorf("failed to load database '%s': %w", entry.Name(), err)
-			}
-			dm.Databases[db.Name] = db
+		db, err := sm.LoadDatabase(entry.Name())
+		if err != nil {
+			return fmt.Errorf("failed to load database '%s': %w", entry.Name(), err)
+		}
+		if err := dm.AddDatabase(db); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadAllDatabases loads all databases from disk into a DatabaseManager
+func (sm *StorageManager) LoadAllDatabases() (*DatabaseManager, error) {
+	dm := NewDatabaseManager()
+
+	// Nothing is ever saved in memory
+	if sm.memory {
+		sm.dbManager = dm
+		return dm, nil
+	}
+
+	// Create root dir if it doesn't exist
+	if !sm.readOnly {
+		if err := os.MkdirAll(sm.RootDir, sm.perms.DirMode); err != nil {
+			return nil, fmt.Errorf("failed to create root directory: %w", err)
 		}
 	}
 
+	if err := sm.loadSavedDatabases(dm); err != nil {
+		return nil, err
+	}
+
+	// Read-only storage has no WAL and only sees the last saved state
+	if sm.readOnly {
+		return dm, nil
+	}
+
 	// Replay WAL to restore any operations not yet persisted
-	if err := sm.WAL.Replay(dm, sm); err != nil {
+	sm.dbManager = dm
+	summary, err := sm.WAL.Replay(dm, sm, sm.replayProgress)
+	if err != nil {
 		return nil, fmt.Errorf("failed to replay WAL: %w", err)
 	}
+	sm.lastReplay = summary
+
+	// Save the replayed changes once, unless data is only saved on request
+	if !sm.walOnly && summary.Replayed+summary.Dropped+summary.Incomplete > 0 {
+		sm.syncMu.Lock()
+		err := sm.saveAndCheckpointLocked()
+		sm.syncMu.Unlock()
+		if err != nil {
+			return nil, fmt.Errorf("failed to save replayed changes: %w", err)
+		}
+	}
 
 	return dm, nil
 }
 
 // SaveAllDatabases saves all databases from a DatabaseManager
 func (sm *StorageManager) SaveAllDatabases(dm *DatabaseManager) error {
-	dm.mu.RLock()
-	defer dm.mu.RUnlock()
+	if sm.readOnly {
+		return ErrReadOnly
+	}
 
-	for _, db := range dm.Databases {
+	for _, db := range dm.AllDatabases() {
 		if err := sm.SaveDatabase(db); err != nil {
 			return fmt.Errorf("failed to save database '%s': %w", db.Name, err)
 		}
@@ -507,48 +1339,59 @@ func (sm *StorageManager) SaveAllDatabases(dm *DatabaseManager) error {
 
 // LogInsert logs an insert operation to WAL (sync) and marks collection dirty
 func (sm *StorageManager) LogInsert(dbName, collName string, doc *Document) error {
-	docData, err := json.Marshal(doc)
+	entry, err := sm.documentEntry(WALOpInsert, dbName, collName, doc)
 	if err != nil {
-		return fmt.Errorf("failed to marshal document: %w", err)
+		return err
 	}
 
-	entry := &WALEntry{
-		Database:   dbName,
-		Collection: collName,
-		Operation:  WALOpInsert,
-		DocumentID: doc.ID,
-		Data:       docData,
-	}
+	return sm.appendWALDirty(entry, dbName, collName)
+}
 
-	if err := sm.WAL.AppendEntrySync(entry); err != nil {
+// LogUpdate logs an update operation to WAL (sync) and marks collection dirty
+func (sm *StorageManager) LogUpdate(dbName, collName string, doc *Document) error {
+	entry, err := sm.documentEntry(WALOpUpdate, dbName, collName, doc)
+	if err != nil {
 		return err
 	}
 
-	sm.MarkDirty(dbName, collName)
-	return nil
+	return sm.appendWALDirty(entry, dbName, collName)
 }
 
-// LogUpdate logs an update operation to WAL (sync) and marks collection dirty
-func (sm *StorageManager) LogUpdate(dbName, collName string, doc *Document) error {
-	docData, err := json.Marshal(doc)
+// storedDocument returns doc as it is stored: its times formatted with the
+// time format and the fields in encrypted encrypted. The document is not
+// modified.
+func (sm *StorageManager) storedDocument(doc *Document, encrypted []string) (*Document, error) {
+	stored := formatTimes(doc, sm.timeFormat)
+	if len(encrypted) == 0 {
+		return stored, nil
+	}
+	stored, err := sm.encryptDocument(stored, encrypted)
 	if err != nil {
-		return fmt.Errorf("failed to marshal document: %w", err)
+		return nil, fmt.Errorf("failed to encrypt document '%s': %w", doc.ID, err)
 	}
+	return stored, nil
+}
 
-	entry := &WALEntry{
-		Database:   dbName,
-		Collection: collName,
-		Operation:  WALOpUpdate,
-		DocumentID: doc.ID,
-		Data:       docData,
+// documentEntry builds the WAL entry of an insert or update, encrypting the
+// fields the collection's schema marks encrypted
+func (sm *StorageManager) documentEntry(op, dbName, collName string, doc *Document) (*WALEntry, error) {
+	doc, err := sm.storedDocument(doc, sm.collectionSchema(dbName, collName).encryptedFields())
+	if err != nil {
+		return nil, err
 	}
 
-	if err := sm.WAL.AppendEntrySync(entry); err != nil {
-		return err
+	docData, err := marshalJSON(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal document: %w", err)
 	}
 
-	sm.MarkDirty(dbName, collName)
-	return nil
+	return &WALEntry{
+		Database:   dbName,
+		Collection: collName,
+		Operation:  op,
+		DocumentID: doc.ID,
+		Data:       docData,
+	}, nil
 }
 
 // LogDelete logs a delete operation to WAL (sync) and marks collection dirty
@@ -560,11 +1403,53 @@ func (sm *StorageManager) LogDelete(dbName, collName, docID string) error {
 		DocumentID: docID,
 	}
 
-	if err := sm.WAL.AppendEntrySync(entry); err != nil {
-		return err
+	return sm.appendWALDirty(entry, dbName, collName)
+}
+
+// LogBatch logs the results of an applied batch to the WAL as one batch,
+// synced once, and marks the collections dirty. Replay applies all of it or,
+// after a crash during the write, none of it.
+func (sm *StorageManager) LogBatch(dbName string, results []BatchResult) error {
+	if sm.readOnly {
+		return ErrReadOnly
+	}
+	if sm.memory {
+		return nil
 	}
 
-	sm.MarkDirty(dbName, collName)
+	entries := make([]*WALEntry, 0, len(results))
+	for _, result := range results {
+		var entry *WALEntry
+		var err error
+		switch result.Op {
+		case BatchInsert:
+			entry, err = sm.documentEntry(WALOpInsert, dbName, result.Collection, result.Document)
+		case BatchUpdate:
+			entry, err = sm.documentEntry(WALOpUpdate, dbName, result.Collection, result.Document)
+		case BatchDelete:
+			entry = &WALEntry{
+				Database:   dbName,
+				Collection: result.Collection,
+				Operation:  WALOpDelete,
+				DocumentID: result.ID,
+			}
+		default:
+			err = fmt.Errorf("unknown batch operation '%s'", result.Op)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to log %s of '%s': %w", result.Op, result.ID, err)
+		}
+		entries = append(entries, entry)
+	}
+
+	// Mark before appending, as appendWALDirty does
+	for _, entry := range entries {
+		sm.MarkDirty(dbName, entry.Collection)
+	}
+	if err := sm.WAL.AppendBatch(entries); err != nil {
+		return fmt.Errorf("failed to log batch: %w", err)
+	}
+	sm.checkWALSize()
 	return nil
 }
 
@@ -575,12 +1460,7 @@ func (sm *StorageManager) LogCreateDatabase(dbName string) error {
 		Operation: WALOpCreateDatabase,
 	}
 
-	if err := sm.WAL.AppendEntrySync(entry); err != nil {
-		return err
-	}
-
-	sm.MarkDirty(dbName, "")
-	return nil
+	return sm.appendWALDirty(entry, dbName, "")
 }
 
 // LogDeleteDatabase logs a delete database operation to WAL (sync)
@@ -590,15 +1470,79 @@ func (sm *StorageManager) LogDeleteDatabase(dbName string) error {
 		Operation: WALOpDeleteDatabase,
 	}
 
-	return sm.WAL.AppendEntrySync(entry)
+	return sm.appendWAL(entry)
+}
+
+// LogCreateCollection logs a create collection operation to WAL (sync) and marks database dirty.
+// An empty format means the storage manager default, and 0 shards one data file.
+func (sm *StorageManager) LogCreateCollection(dbName, collName string, schema *Schema, format StorageFormat, shards int) error {
+	collData := map[string]any{
+		"name":   collName,
+		"schema": schema,
+		"format": format,
+	}
+	if shards > 0 {
+		collData["shards"] = shards
+	}
+	data, err := marshalJSON(collData)
+	if err != nil {
+		return fmt.Errorf("failed to marshal collection data: %w", err)
+	}
+
+	entry := &WALEntry{
+		Database:   dbName,
+		Collection: collName,
+		Operation:  WALOpCreateCollection,
+		Data:       data,
+	}
+
+	return sm.appendWALDirty(entry, dbName, "")
 }
 
-// LogCreateCollection logs a create collection operation to WAL (sync) and marks database dirty
-func (sm *StorageManager) LogCreateCollection(dbName, collName string, schema *Schema) error {
+// LogCreateIndex logs a create index operation to WAL (sync) and marks collection dirty
+func (sm *StorageManager) LogCreateIndex(dbName, collName, indexName, fieldName string) error {
+	indexData := map[string]string{
+		"index_name": indexName,
+		"field_name": fieldName,
+	}
+	data, err := marshalJSON(indexData)
+	if err != nil {
+		return fmt.Errorf("failed to marshal index data: %w", err)
+	}
+
+	entry := &WALEntry{
+		Database:   dbName,
+		Collection: collName,
+		Operation:  WALOpCreateIndex,
+		Data:       data,
+	}
+
+	return sm.appendWALDirty(entry, dbName, collName)
+}
+
+// LogDropIndex logs a drop index operation to WAL (sync) and marks collection dirty
+func (sm *StorageManager) LogDropIndex(dbName, collName, indexName string) error {
+	data, err := marshalJSON(map[string]string{"index_name": indexName})
+	if err != nil {
+		return fmt.Errorf("failed to marshal index data: %w", err)
+	}
+
+	entry := &WALEntry{
+		Database:   dbName,
+		Collection: collName,
+		Operation:  WALOpDropIndex,
+		Data:       data,
+	}
+
+	return sm.appendWALDirty(entry, dbName, collName)
+}
+
+// LogSetSchema logs a schema change operation to WAL (sync) and marks collection dirty
+func (sm *StorageManager) LogSetSchema(dbName, collName string, schema *Schema) error {
 	var schemaData []byte
 	var err error
 	if schema != nil {
-		schemaData, err = json.Marshal(schema)
+		schemaData, err = marshalJSON(schema)
 		if err != nil {
 			return fmt.Errorf("failed to marshal schema: %w", err)
 		}
@@ -607,73 +1551,256 @@ func (sm *StorageManager) LogCreateCollection(dbName, collName string, schema *S
 	entry := &WALEntry{
 		Database:   dbName,
 		Collection: collName,
-		Operation:  WALOpCreateCollection,
+		Operation:  WALOpSetSchema,
 		Data:       schemaData,
 	}
 
-	if err := sm.WAL.AppendEntrySync(entry); err != nil {
-		return err
+	return sm.appendWALDirty(entry, dbName, collName)
+}
+
+// LogSetQueryLimits logs a query limits change to WAL (sync) and marks collection dirty
+func (sm *StorageManager) LogSetQueryLimits(dbName, collName string, limits QueryLimits) error {
+	data, err := marshalJSON(limits)
+	if err != nil {
+		return fmt.Errorf("failed to marshal query limits: %w", err)
 	}
 
-	sm.MarkDirty(dbName, "")
-	return nil
+	entry := &WALEntry{
+		Database:   dbName,
+		Collection: collName,
+		Operation:  WALOpSetQueryLimits,
+		Data:       data,
+	}
+
+	return sm.appendWALDirty(entry, dbName, collName)
 }
 
-// LogCreateIndex logs a create index operation to WAL (sync) and marks collection dirty
-func (sm *StorageManager) LogCreateIndex(dbName, collName, indexName, fieldName string) error {
-	indexData := map[string]string{
-		"index_name": indexName,
-		"field_name": fieldName,
+// LogSetIDKey logs an ID key change to WAL (sync) and marks collection dirty.
+// A nil key is logged as null.
+func (sm *StorageManager) LogSetIDKey(dbName, collName string, key *IDKey) error {
+	data, err := marshalJSON(key)
+	if err != nil {
+		return fmt.Errorf("failed to marshal ID key: %w", err)
 	}
-	data, err := json.Marshal(indexData)
+
+	entry := &WALEntry{
+		Database:   dbName,
+		Collection: collName,
+		Operation:  WALOpSetIDKey,
+		Data:       data,
+	}
+
+	return sm.appendWALDirty(entry, dbName, collName)
+}
+
+// LogSetInsertionOrder logs turning insertion order tracking on or off to
+// WAL (sync) and marks collection dirty
+func (sm *StorageManager) LogSetInsertionOrder(dbName, collName string, enabled bool) error {
+	data, err := marshalJSON(enabled)
 	if err != nil {
-		return fmt.Errorf("failed to marshal index data: %w", err)
+		return fmt.Errorf("failed to marshal insertion order: %w", err)
 	}
 
 	entry := &WALEntry{
 		Database:   dbName,
 		Collection: collName,
-		Operation:  WALOpCreateIndex,
+		Operation:  WALOpSetInsertionOrder,
 		Data:       data,
 	}
 
-	if err := sm.WAL.AppendEntrySync(entry); err != nil {
-		return err
+	return sm.appendWALDirty(entry, dbName, collName)
+}
+
+// LogSetCollation logs a collation change to WAL (sync) and marks collection dirty
+func (sm *StorageManager) LogSetCollation(dbName, collName string, collation Collation) error {
+	entry := &WALEntry{
+		Database:   dbName,
+		Collection: collName,
+		Operation:  WALOpSetCollation,
+		Data:       []byte(collation),
 	}
 
+	return sm.appendWALDirty(entry, dbName, collName)
+}
+
+// appendWALDirty marks a database or collection dirty and appends an entry
+// to the WAL synchronously. Marking first guarantees that a checkpoint
+// covering the entry also covers saving its change.
+func (sm *StorageManager) appendWALDirty(entry *WALEntry, dbName, collName string) error {
+	if sm.readOnly {
+		return ErrReadOnly
+	}
+	if sm.memory {
+		return nil
+	}
 	sm.MarkDirty(dbName, collName)
+	return sm.appendWAL(entry)
+}
+
+// appendWAL appends an entry to the WAL synchronously
+func (sm *StorageManager) appendWAL(entry *WALEntry) error {
+	if sm.readOnly {
+		return ErrReadOnly
+	}
+	if sm.memory {
+		return nil
+	}
+	if err := sm.WAL.AppendEntrySync(entry); err != nil {
+		return err
+	}
+	sm.checkWALSize()
 	return nil
 }
 
 // Checkpoint creates a checkpoint in the WAL at the current offset
 func (sm *StorageManager) Checkpoint() error {
-	sm.WAL.mu.RLock()
-	currentOffset := sm.WAL.currentOffset
-	sm.WAL.mu.RUnlock()
+	if sm.readOnly {
+		return ErrReadOnly
+	}
+	if sm.memory {
+		return nil
+	}
 
-	return sm.WAL.Checkpoint(currentOffset)
+	if err := sm.WAL.Checkpoint(sm.WAL.Offset()); err != nil {
+		return err
+	}
+	sm.notifyCheckpointed()
+	return nil
 }
 
 // Helper functions
+
+// JSONChecksumExt is the extension of the sidecar file holding a JSON file's
+// CRC32, one per line: the current contents' first, then possibly those of
+// the contents being replaced
+const JSONChecksumExt = ".crc"
+
+// writeJSON writes data as JSON, indented if pretty, along with a sidecar
+// checksum file. Both are replaced atomically, the sidecar first, listing
+// the checksums of the new contents and of the old ones, so a crash between
+// the two leaves a pair that still verifies.
 func (sm *StorageManager) writeJSON(path string, data any) error {
-	file, err := os.Create(path)
+	encoded, err := encodeJSON(data, sm.prettyJSON)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ")
-	return encoder.Encode(data)
+	sums := fmt.Sprintf("%08x\n", crc32.ChecksumIEEE(encoded))
+	if old, err := os.ReadFile(path + JSONChecksumExt); err == nil {
+		if fields := strings.Fields(string(old)); len(fields) > 0 {
+			sums += fields[0] + "\n"
+		}
+	}
+	err = writeFileAtomic(path+JSONChecksumExt, sm.perms.FileMode, func(w io.Writer) error {
+		_, err := io.WriteString(w, sums)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, sm.perms.FileMode, func(w io.Writer) error {
+		_, err := w.Write(encoded)
+		return err
+	})
 }
 
+// writeFileAtomic replaces the file at path with what write writes, through
+// a temporary file in the same directory that is synced and renamed over it.
+// A crash or failed write leaves either the old file or the new one, never a
+// partial file; at worst a stray temporary file (see isTempFile). The
+// temporary file is created with perm, or the mode of the file it replaces,
+// so the result has that mode as far as the umask allows.
+func writeFileAtomic(path string, perm os.FileMode, write func(w io.Writer) error) error {
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	dir := filepath.Dir(path)
+	f, err := createTempFile(path, perm)
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+	defer os.Remove(tmpPath) // fails harmlessly once renamed
+
+	err = write(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// tempFileExt ends the names of the temporary files writeFileAtomic writes
+const tempFileExt = ".tmp"
+
+// createTempFile creates a new file named prefix, a random number and
+// tempFileExt, with mode perm
+func createTempFile(prefix string, perm os.FileMode) (*os.File, error) {
+	for range 100 {
+		name := prefix + "." + strconv.FormatUint(uint64(rand.Uint32()), 10) + tempFileExt
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+		if !os.IsExist(err) {
+			return f, err
+		}
+	}
+	return nil, fmt.Errorf("failed to create a temporary file for %s", prefix)
+}
+
+// isTempFile reports whether a file name is one of writeFileAtomic's
+// temporary files, left behind by a crash
+func isTempFile(name string) bool {
+	return filepath.Ext(name) == tempFileExt
+}
+
+// syncDir syncs a directory, making renames in it durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// readJSON reads a JSON file, verifying it against its sidecar checksum when
+// one exists (files written before checksums were added have none)
 func (sm *StorageManager) readJSON(path string, target any) error {
-	file, err := os.Open(path)
+	data, err := os.ReadFile(path)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	decoder := json.NewDecoder(file)
-	return decoder.Decode(target)
+	if sums, err := os.ReadFile(path + JSONChecksumExt); err == nil {
+		fields := strings.Fields(string(sums))
+		if len(fields) == 0 {
+			return fmt.Errorf("%w: unreadable checksum file for %s", ErrChecksumMismatch, path)
+		}
+		actual := crc32.ChecksumIEEE(data)
+		matched := false
+		for _, field := range fields {
+			expected, err := strconv.ParseUint(field, 16, 32)
+			if err != nil {
+				return fmt.Errorf("%w: unreadable checksum file for %s", ErrChecksumMismatch, path)
+			}
+			matched = matched || uint32(expected) == actual
+		}
+		if !matched {
+			return fmt.Errorf("%w: %s", ErrChecksumMismatch, path)
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read checksum file: %w", err)
+	}
+
+	return json.Unmarshal(data, target)
 }
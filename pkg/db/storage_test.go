@@ -0,0 +1,242 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestDeletedDocumentStaysDeletedAfterSave(t *testing.T) {
+	for _, shards := range []int{1, 3} {
+		t.Run(fmt.Sprintf("shards=%d", shards), func(t *testing.T) {
+			dir := t.TempDir()
+			sm, err := NewStorageManager(dir, WithSyncInterval(0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			db := NewDatabase("app")
+			if err := db.CreateCollection("items", nil); err != nil {
+				t.Fatal(err)
+			}
+			coll, _ := db.GetCollection("items")
+			if err := coll.SetShards(shards); err != nil {
+				t.Fatal(err)
+			}
+			for _, id := range []string{"a", "b", "c"} {
+				if err := coll.Insert(&Document{ID: id, Data: map[string]any{"id": id}}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := sm.SaveDatabase(db); err != nil {
+				t.Fatal(err)
+			}
+
+			// Not logged, so only the saved data can keep b deleted
+			if err := coll.Delete("b"); err != nil {
+				t.Fatal(err)
+			}
+			if err := sm.SaveDatabase(db); err != nil {
+				t.Fatal(err)
+			}
+			if err := sm.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			reader, err := NewStorageManager(dir, WithReadOnly())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reader.Close()
+			loaded, err := reader.LoadDatabase("app")
+			if err != nil {
+				t.Fatal(err)
+			}
+			coll, err = loaded.GetCollection("items")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := coll.Count(); n != 2 {
+				t.Errorf("Count = %d, want 2", n)
+			}
+			if _, err := coll.FindByID("b"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("FindByID(b) error = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func TestJSONChecksum(t *testing.T) {
+	dir := t.TempDir()
+	sm, err := NewStorageManager(dir, WithSyncInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sm.Close()
+	path := filepath.Join(dir, "meta.json")
+	read := func() (map[string]any, error) {
+		var v map[string]any
+		err := sm.readJSON(path, &v)
+		return v, err
+	}
+
+	if err := sm.writeJSON(path, map[string]any{"v": 1}); err != nil {
+		t.Fatal(err)
+	}
+	previous, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.writeJSON(path, map[string]any{"v": 2}); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := read(); err != nil || v["v"] != 2.0 {
+		t.Fatalf("read = %v, %v, want v 2", v, err)
+	}
+
+	// A crash after the sidecar was replaced but before the data file was
+	// leaves the previous contents, which the sidecar still covers
+	if err := os.WriteFile(path, previous, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := read(); err != nil || v["v"] != 1.0 {
+		t.Fatalf("read after torn write = %v, %v, want v 1", v, err)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"v":3}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := read(); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("read of corrupt file error = %v, want ErrChecksumMismatch", err)
+	}
+
+	// Sidecars written before the previous checksum was listed hold one line
+	if err := sm.writeJSON(path, map[string]any{"v": 4}); err != nil {
+		t.Fatal(err)
+	}
+	sums, err := os.ReadFile(path + JSONChecksumExt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path+JSONChecksumExt, sums[:9], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := read(); err != nil || v["v"] != 4.0 {
+		t.Errorf("read with a single checksum = %v, %v, want v 4", v, err)
+	}
+}
+
+func TestJSONCollectionCorruptionDetected(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDB(t, dir, WithFormat(FormatJSON))
+	if _, err := d.CreateCollection("items", nil); err != nil {
+		t.Fatal(err)
+	}
+	mustInsert(t, d, "items", "a", map[string]any{"n": 1})
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "app", "items", "documents.json")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data[:len(data)/2], 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	sm, err := NewStorageManager(dir, WithReadOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sm.Close()
+	if _, err := sm.LoadDatabase("app"); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("LoadDatabase error = %v, want ErrChecksumMismatch", err)
+	}
+}
+
+// TestSaveCollectionConcurrentWithWrites is meant for -race: saves run while
+// documents are inserted and updated, and every write must survive a reload
+func TestSaveCollectionConcurrentWithWrites(t *testing.T) {
+	const writers, perWriter = 4, 50
+	dir := t.TempDir()
+	d := openTestDB(t, dir)
+	if _, err := d.CreateCollection("items", &Schema{Fields: map[string]Field{"n": {Type: TypeNumber, Index: true}}}); err != nil {
+		t.Fatal(err)
+	}
+	coll, err := d.Collection("items")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	saveErrs := make(chan error, 1)
+	go func() {
+		defer close(saveErrs)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if err := d.Storage().SaveCollection("app", coll); err != nil {
+				saveErrs <- err
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for w := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range perWriter {
+				id := fmt.Sprintf("w%d-%d", w, i)
+				if _, err := d.Insert("items", &Document{ID: id, Data: map[string]any{"n": i}}); err != nil {
+					t.Error(err)
+					return
+				}
+				if err := d.Update("items", id, map[string]any{"n": i + 1}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(done)
+	if err := <-saveErrs; err != nil {
+		t.Fatalf("SaveCollection: %v", err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := NewStorageManager(dir, WithReadOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	loaded, err := reader.LoadDatabase("app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	coll, err = loaded.GetCollection("items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := coll.Count(); n != writers*perWriter {
+		t.Fatalf("Count = %d, want %d", n, writers*perWriter)
+	}
+	docs, err := coll.Find(Where("n").Eq(perWriter).Build())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != writers {
+		t.Errorf("%d document(s) with the last update, want %d", len(docs), writers)
+	}
+}
@@ -2,7 +2,11 @@ package db
 
 import (
 	"encoding/json"
+	"fmt"
+	"maps"
+	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -27,8 +31,11 @@ const (
 
 // Field represents a field definition in a schema
 type Field struct {
-	Type     FieldType `json:"type"`
-	Required bool      `json:"required"`
+	Type      FieldType `json:"type"`
+	Required  bool      `json:"required"`
+	Encrypted bool      `json:"encrypted,omitempty"` // stored encrypted on disk (see WithEncryptionKey)
+	Index     bool      `json:"index,omitempty"`     // indexed when the collection is created
+	Unique    bool      `json:"unique,omitempty"`    // indexed like Index, and no two documents share a value
 }
 
 // Schema represents a collection schema
@@ -38,9 +45,9 @@ type Schema struct {
 
 // Index represents an index on a collection
 type Index struct {
-	Name      string            `json:"name"`
-	FieldName string            `json:"field_name"`
-	Data      map[string]string `json:"-"` // maps field value to document ID
+	Name      string              `json:"name"`
+	FieldName string              `json:"field_name"`
+	Data      map[string][]string `json:"-"` // maps field value to the IDs of the documents with it, sorted
 	mu        sync.RWMutex
 }
 
@@ -50,7 +57,25 @@ type Collection struct {
 	Schema    *Schema              `json:"schema,omitempty"`
 	Documents map[string]*Document `json:"-"` // maps document ID to document
 	Indexes   map[string]*Index    `json:"indexes"`
+	Format    StorageFormat        `json:"format,omitempty"`    // empty means the storage manager default
+	Limits    QueryLimits          `json:"limits"`              // default and maximum Find limit
+	IDKey     *IDKey               `json:"id_key,omitempty"`    // derives IDs of inserted documents; nil means random UUIDs
+	Shards    int                  `json:"shards,omitempty"`    // binary data files documents are spread across; 0 or 1 means one
+	Collation Collation            `json:"collation,omitempty"` // how strings are ordered; empty means byte-wise
+	readOnly  bool                 // set when loaded from read-only storage
+	order     map[string]uint64    // position of each document in insertion order; nil unless tracked
+	orderNext uint64               // position of the next inserted document
 	mu        sync.RWMutex
+	saveMu    sync.Mutex // serializes saves, whose writers append to the same files
+
+	memSize    int64                       // approximate memory used by documents
+	modCount   uint64                      // incremented by every change, to detect writes during eviction
+	savedCount uint64                      // modCount when last saved to or loaded from savedDir
+	savedDir   string                      // directory the collection was last saved to or loaded from
+	sealed     *sealedCopies               // stored copies of documents with encrypted fields; replaced, never modified
+	unloaded   bool                        // documents are not in memory (evicted or lazily opened)
+	loader     func() (*Collection, error) // loads an unloaded collection
+	lastAccess atomic.Uint64               // database access clock value of the last GetCollection
 }
 
 // Database represents the database
@@ -58,7 +83,14 @@ type Database struct {
 	Name          string                 `json:"name"`
 	SchemaVersion int                    `json:"schema_version"` // Schema version for migrations
 	Collections   map[string]*Collection `json:"collections"`
+	readOnly      bool                   // set when loaded from read-only storage
+	unavailable   map[string]error       // collections that failed to load, by name
 	mu            sync.RWMutex
+
+	memoryBudget int64           // 0 means unlimited
+	store        CollectionStore // saves and reloads evicted collections
+	accessClock  atomic.Uint64
+	evictMu      sync.Mutex
 }
 
 // DatabaseManager manages multiple databases
@@ -70,31 +102,35 @@ type DatabaseManager struct {
 // QueryFilter represents a query filter
 type QueryFilter struct {
 	Field    string `json:"field"`
-	Operator string `json:"operator"` // "eq", "ne", "gt", "lt", "gte", "lte", "in"
+	Operator string `json:"operator"` // "eq", "ne", "gt", "lt", "gte", "lte", "in", "startsWith", "endsWith", "exists", "mod"
 	Value    any    `json:"value"`
 }
 
 // Query represents a query
 type Query struct {
 	Filters []QueryFilter `json:"filters"`
+	Where   *Condition    `json:"where,omitempty"` // matched as well as Filters; only Filters use indexes
+	Sort    []SortField   `json:"sort,omitempty"`  // applied before skip and limit
 	Limit   int           `json:"limit"`
 	Skip    int           `json:"skip"`
 }
 
-// MarshalJSON customizes JSON marshaling for Document
+// MarshalJSON customizes JSON marshaling for Document. Strings are not
+// HTML-escaped, though json.Marshal escapes the result again.
 func (d *Document) MarshalJSON() ([]byte, error) {
 	combined := make(map[string]any)
 	combined["_id"] = d.ID
 	for k, v := range d.Data {
 		combined[k] = v
 	}
-	return json.Marshal(combined)
+	return marshalJSON(combined)
 }
 
-// UnmarshalJSON customizes JSON unmarshaling for Document
+// UnmarshalJSON customizes JSON unmarshaling for Document.
+// Integer values decode as int64 rather than float64 (see DecodeJSON).
 func (d *Document) UnmarshalJSON(data []byte) error {
 	var raw map[string]any
-	if err := json.Unmarshal(data, &raw); err != nil {
+	if err := DecodeJSON(data, &raw); err != nil {
 		return err
 	}
 
@@ -107,12 +143,83 @@ func (d *Document) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// NewDocument builds a document from data the way the MCP tools do: a string
+// "_id" becomes the document ID and is left out of the document's data;
+// without one the ID is assigned on insert. Any other "_id" fails with
+// ErrReservedField. The document's data is a copy of data, so setting or
+// removing keys of data afterwards doesn't change the document.
+func NewDocument(data map[string]any) (*Document, error) {
+	doc := &Document{Data: maps.Clone(data)}
+	if doc.Data == nil {
+		doc.Data = make(map[string]any)
+	}
+	value, ok := doc.Data["_id"]
+	if !ok {
+		return doc, nil
+	}
+
+	id, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("%w '_id' must be a string, got %T", ErrReservedField, value)
+	}
+	doc.ID = id
+	delete(doc.Data, "_id")
+	return doc, nil
+}
+
+// NewDocumentFrom builds a document from a struct (or map) using its JSON
+// encoding, so json tags name the fields. A string field tagged "_id" sets
+// the document ID.
+func NewDocumentFrom(v any) (*Document, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode value: %w", err)
+	}
+
+	doc := &Document{}
+	if err := doc.UnmarshalJSON(data); err != nil {
+		return nil, fmt.Errorf("value is not a JSON object: %w", err)
+	}
+	if doc.Data == nil {
+		return nil, fmt.Errorf("value is not a JSON object")
+	}
+	return doc, nil
+}
+
+// DecodeInto decodes the document into v, typically a pointer to a struct,
+// through its JSON encoding. The ID is available as the "_id" field.
+func (d *Document) DecodeInto(v any) error {
+	data, err := d.MarshalJSON()
+	if err != nil {
+		return fmt.Errorf("failed to encode document: %w", err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to decode document '%s': %w", d.ID, err)
+	}
+	return nil
+}
+
+// reservedFields are the document fields managed by the database. Writes
+// can't set them in document data or updates; the ID is set through Document.ID.
+var reservedFields = []string{"_id"}
+
+// checkReservedFields returns an error wrapping ErrReservedField if data
+// sets any reserved field
+func checkReservedFields(data map[string]any) error {
+	for _, field := range reservedFields {
+		if _, ok := data[field]; ok {
+			return fmt.Errorf("cannot set %w '%s'", ErrReservedField, field)
+		}
+	}
+	return nil
+}
+
 // NewIndex creates a new index
 func NewIndex(name, fieldName string) *Index {
 	return &Index{
 		Name:      name,
 		FieldName: fieldName,
-		Data:      make(map[string]string),
+		Data:      make(map[string][]string),
 	}
 }
 
@@ -167,6 +274,31 @@ func (dm *DatabaseManager) CreateDatabase(name string) *Database {
 	return db
 }
 
+// AddDatabase registers an existing database with the manager
+func (dm *DatabaseManager) AddDatabase(db *Database) error {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	if _, exists := dm.Databases[db.Name]; exists {
+		return fmt.Errorf("database '%s' %w", db.Name, ErrAlreadyExists)
+	}
+
+	dm.Databases[db.Name] = db
+	return nil
+}
+
+// AllDatabases returns a snapshot of all registered databases
+func (dm *DatabaseManager) AllDatabases() []*Database {
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	dbs := make([]*Database, 0, len(dm.Databases))
+	for _, db := range dm.Databases {
+		dbs = append(dbs, db)
+	}
+	return dbs
+}
+
 // ListDatabases returns a list of all database names
 func (dm *DatabaseManager) ListDatabases() []string {
 	dm.mu.RLock()
@@ -179,8 +311,8 @@ func (dm *DatabaseManager) ListDatabases() []string {
 	return names
 }
 
-// DeleteDatabase removes a database
-func (dm *DatabaseManager) DeleteDatabase(name string) bool {
+// RemoveDatabase removes a database, reporting whether it existed
+func (dm *DatabaseManager) RemoveDatabase(name string) bool {
 	dm.mu.Lock()
 	defer dm.mu.Unlock()
 
@@ -191,6 +323,13 @@ func (dm *DatabaseManager) DeleteDatabase(name string) bool {
 	return false
 }
 
+// DeleteDatabase removes a database
+//
+// Deprecated: use RemoveDatabase.
+func (dm *DatabaseManager) DeleteDatabase(name string) bool {
+	return dm.RemoveDatabase(name)
+}
+
 // GetValue safely extracts a value from a document by field name
 func (d *Document) GetValue(fieldName string) (any, bool) {
 	if fieldName == "_id" {
@@ -200,6 +339,68 @@ func (d *Document) GetValue(fieldName string) (any, bool) {
 	return val, ok
 }
 
+// GetPath gets a field value by dot-separated path, e.g. "address.city".
+// A top-level field whose name contains dots is matched first.
+func (d *Document) GetPath(path string) (any, bool) {
+	if value, ok := d.GetValue(path); ok {
+		return value, true
+	}
+	return lookupPath(d.Data, path)
+}
+
+// GetString gets a string field by path
+func (d *Document) GetString(path string) (string, bool) {
+	value, _ := d.GetPath(path)
+	s, ok := value.(string)
+	return s, ok
+}
+
+// GetNumber gets a numeric field by path as a float64
+func (d *Document) GetNumber(path string) (float64, bool) {
+	value, _ := d.GetPath(path)
+	return toFloat(value)
+}
+
+// GetBool gets a boolean field by path
+func (d *Document) GetBool(path string) (bool, bool) {
+	value, _ := d.GetPath(path)
+	b, ok := value.(bool)
+	return b, ok
+}
+
+// GetTime gets a time field by path. Besides time.Time values, strings in
+// RFC 3339 format (as time.Time marshals to JSON) are parsed.
+func (d *Document) GetTime(path string) (time.Time, bool) {
+	value, _ := d.GetPath(path)
+	switch v := value.(type) {
+	case time.Time:
+		return v, true
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		return t, err == nil
+	}
+	return time.Time{}, false
+}
+
+// GetArray gets an array field by path. Typed slices such as []string are
+// converted to []any.
+func (d *Document) GetArray(path string) ([]any, bool) {
+	value, _ := d.GetPath(path)
+	if arr, ok := value.([]any); ok {
+		return arr, true
+	}
+
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, false
+	}
+	arr := make([]any, rv.Len())
+	for i := range arr {
+		arr[i] = rv.Index(i).Interface()
+	}
+	return arr, true
+}
+
 // Clone creates a deep copy of the document
 func (d *Document) Clone() *Document {
 	clone := &Document{
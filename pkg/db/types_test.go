@@ -0,0 +1,43 @@
+package db
+
+import (
+	"errors"
+	"maps"
+	"testing"
+)
+
+func TestNewDocument(t *testing.T) {
+	for _, tc := range []struct {
+		data     map[string]any
+		wantID   string
+		wantData map[string]any
+	}{
+		{map[string]any{"_id": "alice", "name": "Alice"}, "alice", map[string]any{"name": "Alice"}},
+		{map[string]any{"name": "Bob"}, "", map[string]any{"name": "Bob"}},
+		{nil, "", map[string]any{}},
+	} {
+		input := maps.Clone(tc.data)
+		doc, err := NewDocument(input)
+		if err != nil {
+			t.Fatalf("NewDocument(%v): %v", tc.data, err)
+		}
+		if doc.ID != tc.wantID || !maps.Equal(doc.Data, tc.wantData) {
+			t.Errorf("NewDocument(%v) = %q, %v, want %q, %v", tc.data, doc.ID, doc.Data, tc.wantID, tc.wantData)
+		}
+		if !maps.Equal(input, tc.data) {
+			t.Errorf("NewDocument changed its input to %v", input)
+		}
+		if input != nil {
+			input["added"] = true
+			if _, ok := doc.Data["added"]; ok {
+				t.Errorf("document of %v shares its data with the input", tc.data)
+			}
+		}
+	}
+
+	for _, id := range []any{42, nil, []any{"a"}} {
+		if _, err := NewDocument(map[string]any{"_id": id}); !errors.Is(err, ErrReservedField) {
+			t.Errorf("NewDocument with _id %#v: err = %v, want ErrReservedField", id, err)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package db
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Update operators
+const (
+	UpdateSet      = "$set"      // sets dot paths, creating missing objects on the way
+	UpdateUnset    = "$unset"    // removes dot paths; the values are ignored
+	UpdatePush     = "$push"     // appends a value to an array, creating it if missing
+	UpdateAddToSet = "$addToSet" // appends a value to an array unless an equal one is in it
+	UpdatePull     = "$pull"     // removes the elements equal to a value from an array
+)
+
+// updateOperators lists the update operators in the order they are applied
+var updateOperators = []string{UpdateSet, UpdateUnset, UpdatePush, UpdateAddToSet, UpdatePull}
+
+// applyUpdates applies an update to a document's data. Without operators,
+// the update's keys replace top-level fields as they are, dots included.
+// With operators ($set, $unset, $push, $addToSet, $pull), every key must be
+// one, and each maps dot paths to values: "address.city" changes only that
+// field of address.
+//
+// Nested objects and arrays on a changed path are copied rather than
+// modified, since data is a shallow copy of a stored document.
+func applyUpdates(data, updates map[string]any) error {
+	if !hasUpdateOperators(updates) {
+		if err := checkReservedFields(updates); err != nil {
+			return err
+		}
+		for key, value := range updates {
+			data[key] = value
+		}
+		return nil
+	}
+
+	for op, arg := range updates {
+		if !slices.Contains(updateOperators, op) {
+			if !strings.HasPrefix(op, "$") {
+				return fmt.Errorf("%w: field '%s' mixed with update operators", ErrInvalidUpdate, op)
+			}
+			return fmt.Errorf("%w: unknown update operator '%s'", ErrInvalidUpdate, op)
+		}
+		paths, ok := arg.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%w: %s takes an object of paths, got %T", ErrInvalidUpdate, op, arg)
+		}
+		for path := range paths {
+			if err := checkUpdatePath(path); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Operators apply in a fixed order, and paths in sorted order (a parent
+	// before its fields), whatever the order of the keys
+	for _, op := range updateOperators {
+		paths, ok := updates[op].(map[string]any)
+		if !ok {
+			continue
+		}
+		for _, path := range sortedKeys(paths) {
+			if err := applyUpdate(data, op, path, paths[path]); err != nil {
+				return fmt.Errorf("%w: %s '%s': %w", ErrInvalidUpdate, op, path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// applyUpdate applies one operator to one path
+func applyUpdate(data map[string]any, op, path string, value any) error {
+	segments := strings.Split(path, ".")
+	if op == UpdateSet {
+		return setPath(data, segments, value)
+	}
+	if op == UpdateUnset {
+		unsetPath(data, segments)
+		return nil
+	}
+
+	current, exists := lookupPath(data, path)
+	var arr []any
+	if exists {
+		var ok bool
+		if arr, ok = current.([]any); !ok {
+			return fmt.Errorf("field is not an array")
+		}
+	}
+
+	// Build a new array, leaving the stored one alone
+	var updated []any
+	switch op {
+	case UpdatePush:
+		updated = append(slices.Clone(arr), value)
+	case UpdateAddToSet:
+		if slices.ContainsFunc(arr, func(elem any) bool { return valuesEqual(elem, value) }) {
+			return nil
+		}
+		updated = append(slices.Clone(arr), value)
+	case UpdatePull:
+		if !exists {
+			return nil
+		}
+		updated = slices.DeleteFunc(slices.Clone(arr), func(elem any) bool { return valuesEqual(elem, value) })
+	}
+	return setPath(data, segments, updated)
+}
+
+// hasUpdateOperators reports whether an update uses operators
+func hasUpdateOperators(updates map[string]any) bool {
+	for key := range updates {
+		if strings.HasPrefix(key, "$") {
+			return true
+		}
+	}
+	return false
+}
+
+// checkUpdatePath checks that a path has no empty segments and doesn't start
+// at a reserved field
+func checkUpdatePath(path string) error {
+	segments := strings.Split(path, ".")
+	for _, segment := range segments {
+		if segment == "" {
+			return fmt.Errorf("%w: invalid path '%s'", ErrInvalidUpdate, path)
+		}
+	}
+	return checkReservedFields(map[string]any{segments[0]: nil})
+}
+
+// setPath sets the value at a path, copying the objects along it and
+// creating those that are missing
+func setPath(obj map[string]any, path []string, value any) error {
+	key := path[0]
+	if len(path) == 1 {
+		obj[key] = value
+		return nil
+	}
+
+	var child map[string]any
+	switch existing := obj[key].(type) {
+	case nil:
+		child = make(map[string]any)
+	case map[string]any:
+		child = make(map[string]any, len(existing)+1)
+		for k, v := range existing {
+			child[k] = v
+		}
+	default:
+		return fmt.Errorf("'%s' is not an object", key)
+	}
+
+	if err := setPath(child, path[1:], value); err != nil {
+		return err
+	}
+	obj[key] = child
+	return nil
+}
+
+// unsetPath removes the value at a path, copying the objects along it. A
+// path that doesn't exist is left alone.
+func unsetPath(obj map[string]any, path []string) {
+	key := path[0]
+	if len(path) == 1 {
+		delete(obj, key)
+		return
+	}
+
+	existing, ok := obj[key].(map[string]any)
+	if !ok {
+		return
+	}
+	if _, ok := lookupPath(existing, strings.Join(path[1:], ".")); !ok {
+		return
+	}
+
+	child := make(map[string]any, len(existing))
+	for k, v := range existing {
+		child[k] = v
+	}
+	unsetPath(child, path[1:])
+	obj[key] = child
+}
+
+// sortedKeys returns the keys of a map in sorted order
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
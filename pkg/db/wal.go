@@ -4,12 +4,14 @@ import (
 	"bufio"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"hash/crc32"
 	"io"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -29,26 +31,63 @@ const (
 
 // WALOperation types
 const (
-	WALOpInsert           = "insert"
-	WALOpUpdate           = "update"
-	WALOpDelete           = "delete"
-	WALOpCreateDatabase   = "create_database"
-	WALOpDeleteDatabase   = "delete_database"
-	WALOpCreateCollection = "create_collection"
-	WALOpDeleteCollection = "delete_collection"
-	WALOpCreateIndex      = "create_index"
+	WALOpInsert            = "insert"
+	WALOpUpdate            = "update"
+	WALOpDelete            = "delete"
+	WALOpCreateDatabase    = "create_database"
+	WALOpDeleteDatabase    = "delete_database"
+	WALOpCreateCollection  = "create_collection"
+	WALOpDeleteCollection  = "delete_collection"
+	WALOpCreateIndex       = "create_index"
+	WALOpDropIndex         = "drop_index"
+	WALOpSetSchema         = "set_schema"
+	WALOpSetQueryLimits    = "set_query_limits"
+	WALOpSetIDKey          = "set_id_key"
+	WALOpSetCollation      = "set_collation"
+	WALOpSetInsertionOrder = "set_insertion_order"
 )
 
 // WALEntry represents a single write-ahead log entry
 type WALEntry struct {
-	Offset     uint64    `json:"offset"`
-	Timestamp  time.Time `json:"timestamp"`
+	Offset     uint64    `json:"offset"`    // Sequence number, increasing with each entry; may skip one after a restart (see loadCheckpoint)
+	Timestamp  time.Time `json:"timestamp"` // When the entry was appended; entries of a batch share it
 	Database   string    `json:"database"`
 	Collection string    `json:"collection,omitempty"`
 	Operation  string    `json:"operation"`
 	DocumentID string    `json:"document_id,omitempty"`
 	Data       []byte    `json:"data"`
-	Checksum   uint32    `json:"-"` // Computed, not serialized
+	Batch      uint64    `json:"batch,omitempty"`      // Offset of the first entry of the batch (see AppendBatch)
+	BatchSize  int       `json:"batch_size,omitempty"` // Number of entries in the batch, 0 if not batched
+	Checksum   uint32    `json:"-"`                    // Computed, not serialized
+
+	size int64 // Encoded size in the WAL file, set when read back
+}
+
+// ReplayProgress reports how far a WAL replay has got
+type ReplayProgress struct {
+	Entries      int   // Entries replayed so far
+	TotalEntries int   // Entries to replay
+	Bytes        int64 // WAL bytes of the entries replayed so far
+	TotalBytes   int64 // WAL bytes of all entries to replay
+
+	Offset    uint64    // Offset of the entry just replayed
+	Timestamp time.Time // When the entry just replayed was appended
+}
+
+// ReplaySummary describes a completed WAL replay
+type ReplaySummary struct {
+	Replayed   int            `json:"replayed"`
+	Operations map[string]int `json:"operations"`  // Entries replayed per operation type
+	Skipped    int            `json:"skipped"`     // Entries already covered by the checkpoint
+	Dropped    int            `json:"dropped"`     // Entries for collections that failed to load (see WithTolerantLoad)
+	Incomplete int            `json:"incomplete"`  // Entries of batches cut short by a crash, not applied
+	Discarded  int            `json:"discarded"`   // Entries after the recovery point of RecoverTo, not applied
+	Filtered   int            `json:"filtered"`    // Entries for other databases or collections (see ReplayDatabase)
+	LastOffset uint64         `json:"last_offset"` // Offset of the last replayed entry, or the checkpoint offset if none
+
+	// LastTimestamp is when the last applied entry was appended, zero if none
+	LastTimestamp time.Time     `json:"last_timestamp"`
+	Duration      time.Duration `json:"duration"`
 }
 
 // WALCheckpoint tracks the last successfully synced offset
@@ -59,31 +98,35 @@ type WALCheckpoint struct {
 
 // WALManager manages write-ahead logging
 type WALManager struct {
-	rootDir       string
+	dir           string
 	currentFile   *os.File
 	currentOffset uint64
 	currentSize   int64
+	totalSize     int64 // bytes in all WAL files
 	writer        *bufio.Writer
 	batch         []*WALEntry
 	batchMu       sync.Mutex
 	checkpoint    *WALCheckpoint
+	perms         FilePermissions
 	mu            sync.RWMutex
 	flushTicker   *time.Ticker
 	stopChan      chan struct{}
+	clock         Clock // timestamps entries and checkpoints
 }
 
-// NewWALManager creates a new WAL manager
-func NewWALManager(rootDir string) (*WALManager, error) {
-	// WAL files are stored directly in rootDir (no separate wal subdirectory)
-	if err := os.MkdirAll(rootDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create data directory: %w", err)
+// NewWALManager creates a new WAL manager storing its files directly in dir
+func NewWALManager(dir string, perms FilePermissions) (*WALManager, error) {
+	if err := os.MkdirAll(dir, perms.DirMode); err != nil {
+		return nil, fmt.Errorf("failed to create WAL directory: %w", err)
 	}
 
 	wm := &WALManager{
-		rootDir:     rootDir,
+		dir:         dir,
+		perms:       perms,
 		batch:       make([]*WALEntry, 0, WALBatchSize),
 		stopChan:    make(chan struct{}),
 		flushTicker: time.NewTicker(WALFlushInterval),
+		clock:       SystemClock,
 	}
 
 	// Load checkpoint
@@ -91,10 +134,18 @@ func NewWALManager(rootDir string) (*WALManager, error) {
 		return nil, err
 	}
 
+	// Continue after the last entry already written
+	if err := wm.resumeOffset(); err != nil {
+		return nil, err
+	}
+
 	// Open or create current WAL file
 	if err := wm.openCurrentWAL(); err != nil {
 		return nil, err
 	}
+	if err := wm.measureLocked(); err != nil {
+		return nil, err
+	}
 
 	// Start background flusher
 	go wm.backgroundFlusher()
@@ -113,7 +164,7 @@ func (wm *WALManager) AppendEntry(entry *WALEntry) error {
 	wm.currentOffset++
 	wm.mu.Unlock()
 
-	entry.Timestamp = time.Now()
+	entry.Timestamp = wm.clock.Now()
 
 	// Add to batch
 	wm.batch = append(wm.batch, entry)
@@ -138,7 +189,7 @@ func (wm *WALManager) AppendEntrySync(entry *WALEntry) error {
 	wm.currentOffset++
 	wm.mu.Unlock()
 
-	entry.Timestamp = time.Now()
+	entry.Timestamp = wm.clock.Now()
 
 	// Add to batch
 	wm.batch = append(wm.batch, entry)
@@ -160,6 +211,47 @@ func (wm *WALManager) AppendEntrySync(entry *WALEntry) error {
 	return nil
 }
 
+// AppendBatch appends entries to the WAL as one batch and syncs once. The
+// entries get consecutive offsets and are written to the same file; replay
+// applies them all or, if the batch was only partly written, none of them.
+func (wm *WALManager) AppendBatch(entries []*WALEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	wm.batchMu.Lock()
+	defer wm.batchMu.Unlock()
+
+	// Assign offsets
+	now := wm.clock.Now()
+	wm.mu.Lock()
+	first := wm.currentOffset
+	for _, entry := range entries {
+		entry.Offset = wm.currentOffset
+		entry.Timestamp = now
+		entry.Batch = first
+		entry.BatchSize = len(entries)
+		wm.currentOffset++
+	}
+	wm.mu.Unlock()
+
+	// Rotation only happens after a flush, so the batch stays in one file
+	wm.batch = append(wm.batch, entries...)
+	if err := wm.flushBatchLocked(); err != nil {
+		return err
+	}
+
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+	if wm.currentFile != nil {
+		if err := wm.currentFile.Sync(); err != nil {
+			return fmt.Errorf("failed to sync WAL to disk: %w", err)
+		}
+	}
+
+	return nil
+}
+
 // Flush forces a flush of pending entries
 func (wm *WALManager) Flush() error {
 	wm.batchMu.Lock()
@@ -167,6 +259,26 @@ func (wm *WALManager) Flush() error {
 	return wm.flushBatchLocked()
 }
 
+// Sync flushes pending entries and fsyncs the current WAL file
+func (wm *WALManager) Sync() error {
+	wm.batchMu.Lock()
+	defer wm.batchMu.Unlock()
+
+	if err := wm.flushBatchLocked(); err != nil {
+		return err
+	}
+
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+	if wm.currentFile != nil {
+		if err := wm.currentFile.Sync(); err != nil {
+			return fmt.Errorf("failed to sync WAL to disk: %w", err)
+		}
+	}
+
+	return nil
+}
+
 // flushBatchLocked flushes the current batch (caller must hold batchMu)
 func (wm *WALManager) flushBatchLocked() error {
 	if len(wm.batch) == 0 {
@@ -203,7 +315,7 @@ func (wm *WALManager) flushBatchLocked() error {
 // writeEntryLocked writes a single entry (caller must hold mu)
 func (wm *WALManager) writeEntryLocked(entry *WALEntry) error {
 	// Serialize entry
-	data, err := json.Marshal(entry)
+	data, err := marshalJSON(entry)
 	if err != nil {
 		return fmt.Errorf("failed to marshal WAL entry: %w", err)
 	}
@@ -224,6 +336,7 @@ func (wm *WALManager) writeEntryLocked(entry *WALEntry) error {
 	}
 
 	wm.currentSize += int64(8 + len(data)) // 4+4+N
+	wm.totalSize += int64(8 + len(data))
 	return nil
 }
 
@@ -244,76 +357,108 @@ func (wm *WALManager) ReadFrom(startOffset uint64) ([]*WALEntry, error) {
 	wm.mu.RLock()
 	defer wm.mu.RUnlock()
 
+	entries, _, err := wm.readFromLocked(startOffset)
+	return entries, err
+}
+
+// readFromLocked reads the entries at or after startOffset from all WAL
+// files, also returning how many earlier entries were skipped (caller must hold mu)
+func (wm *WALManager) readFromLocked(startOffset uint64) ([]*WALEntry, int, error) {
 	files, err := wm.getWALFilesLocked()
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	var entries []*WALEntry
+	var skipped int
 
 	for _, filename := range files {
-		path := filepath.Join(wm.rootDir, filename)
-		fileEntries, err := wm.readWALFile(path, startOffset)
+		path := filepath.Join(wm.dir, filename)
+		fileEntries, fileSkipped, err := wm.readWALFile(path, startOffset)
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 		entries = append(entries, fileEntries...)
+		skipped += fileSkipped
+	}
+
+	return entries, skipped, nil
+}
+
+// readWALFile reads entries from a specific WAL file, returning the entries
+// at or after startOffset and the number of earlier entries skipped
+func (wm *WALManager) readWALFile(path string, startOffset uint64) ([]*WALEntry, int, error) {
+	var entries []*WALEntry
+	var skipped int
+
+	_, err := scanWALFile(path, func(entry *WALEntry) {
+		// Filter by offset
+		if entry.Offset >= startOffset {
+			entries = append(entries, entry)
+		} else {
+			skipped++
+		}
+	})
+	if err != nil {
+		return nil, 0, err
 	}
 
-	return entries, nil
+	return entries, skipped, nil
 }
 
-// readWALFile reads entries from a specific WAL file
-func (wm *WALManager) readWALFile(path string, startOffset uint64) ([]*WALEntry, error) {
+// scanWALFile reads the entries of a WAL file in order, calling visit for
+// each, and returns the size of the complete entries. A record cut short at
+// the end of the file, as left by a crash during a write, ends the scan.
+func scanWALFile(path string, visit func(*WALEntry)) (int64, error) {
 	file, err := os.Open(path)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 	defer file.Close()
 
-	var entries []*WALEntry
 	reader := bufio.NewReader(file)
+	var size int64
 
 	for {
-		// Read length
-		var length uint32
-		if err := binary.Read(reader, binary.LittleEndian, &length); err != nil {
-			if err == io.EOF {
+		// Read length and checksum
+		var header [8]byte
+		if _, err := io.ReadFull(reader, header[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
 				break
 			}
-			return nil, err
-		}
-
-		// Read checksum
-		var checksum uint32
-		if err := binary.Read(reader, binary.LittleEndian, &checksum); err != nil {
-			return nil, err
+			return 0, err
 		}
+		length := binary.LittleEndian.Uint32(header[0:4])
+		checksum := binary.LittleEndian.Uint32(header[4:8])
 
 		// Read data
 		data := make([]byte, length)
 		if _, err := io.ReadFull(reader, data); err != nil {
-			return nil, err
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return 0, err
 		}
 
 		// Verify checksum
 		if crc32.ChecksumIEEE(data) != checksum {
-			return nil, fmt.Errorf("WAL entry checksum mismatch")
+			return 0, fmt.Errorf("WAL entry checksum mismatch")
 		}
 
 		// Deserialize entry
 		var entry WALEntry
 		if err := json.Unmarshal(data, &entry); err != nil {
-			return nil, err
+			return 0, err
 		}
+		entry.size = 8 + int64(length) // length and checksum headers + data
+		size += entry.size
 
-		// Filter by offset
-		if entry.Offset >= startOffset {
-			entries = append(entries, &entry)
+		if visit != nil {
+			visit(&entry)
 		}
 	}
 
-	return entries, nil
+	return size, nil
 }
 
 // Checkpoint marks the given offset as successfully synced
@@ -323,10 +468,37 @@ func (wm *WALManager) Checkpoint(offset uint64) error {
 
 	wm.checkpoint = &WALCheckpoint{
 		Offset:    offset,
-		Timestamp: time.Now(),
+		Timestamp: wm.clock.Now(),
+	}
+
+	if err := wm.saveCheckpointLocked(); err != nil {
+		return err
 	}
 
-	return wm.saveCheckpointLocked()
+	// Files held back by an older checkpoint may be removable now
+	return wm.cleanupOldWALsLocked()
+}
+
+// Offset returns the offset the next appended entry will get
+func (wm *WALManager) Offset() uint64 {
+	wm.mu.RLock()
+	defer wm.mu.RUnlock()
+	return wm.currentOffset
+}
+
+// Pending returns how many entries were appended after the checkpoint
+func (wm *WALManager) Pending() uint64 {
+	wm.mu.RLock()
+	defer wm.mu.RUnlock()
+
+	var checkpoint uint64
+	if wm.checkpoint != nil {
+		checkpoint = wm.checkpoint.Offset
+	}
+	if wm.currentOffset <= checkpoint {
+		return 0
+	}
+	return wm.currentOffset - checkpoint
 }
 
 // GetCheckpoint returns the current checkpoint
@@ -363,9 +535,9 @@ func (wm *WALManager) rotateLocked() error {
 func (wm *WALManager) openCurrentWAL() error {
 	timestamp := time.Now().Unix()
 	filename := fmt.Sprintf("%s%d-%06d.log", WALFilePrefix, timestamp, wm.currentOffset)
-	path := filepath.Join(wm.rootDir, filename)
+	path := filepath.Join(wm.dir, filename)
 
-	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, wm.perms.FileMode)
 	if err != nil {
 		return fmt.Errorf("failed to open WAL file: %w", err)
 	}
@@ -376,8 +548,26 @@ func (wm *WALManager) openCurrentWAL() error {
 		return err
 	}
 
+	// Appending after a record cut short by a crash would misframe the new
+	// entries, so drop it first
+	size := stat.Size()
+	if size > 0 {
+		valid, err := scanWALFile(path, nil)
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("failed to check WAL file: %w", err)
+		}
+		if valid < size {
+			if err := file.Truncate(valid); err != nil {
+				file.Close()
+				return fmt.Errorf("failed to truncate incomplete WAL entry: %w", err)
+			}
+			size = valid
+		}
+	}
+
 	wm.currentFile = file
-	wm.currentSize = stat.Size()
+	wm.currentSize = size
 	wm.writer = bufio.NewWriter(file)
 
 	return nil
@@ -385,7 +575,7 @@ func (wm *WALManager) openCurrentWAL() error {
 
 // getWALFilesLocked returns sorted list of WAL files (caller must hold mu)
 func (wm *WALManager) getWALFilesLocked() ([]string, error) {
-	entries, err := os.ReadDir(wm.rootDir)
+	entries, err := os.ReadDir(wm.dir)
 	if err != nil {
 		return nil, err
 	}
@@ -401,61 +591,175 @@ func (wm *WALManager) getWALFilesLocked() ([]string, error) {
 	return files, nil
 }
 
-// cleanupOldWALsLocked removes old WAL files beyond retention (caller must hold mu)
+// cleanupOldWALsLocked removes old WAL files beyond retention (caller must hold mu).
+// A file is only removed once the checkpoint covers all its entries, i.e.
+// the next file starts at or before the checkpoint offset.
 func (wm *WALManager) cleanupOldWALsLocked() error {
+	return wm.removeCheckpointedLocked(WALRetentionCount)
+}
+
+// removeCheckpointedLocked removes the oldest WAL files the checkpoint
+// covers, keeping at least keep files (caller must hold mu)
+func (wm *WALManager) removeCheckpointedLocked(keep int) error {
 	files, err := wm.getWALFilesLocked()
 	if err != nil {
 		return err
 	}
 
-	if len(files) <= WALRetentionCount {
+	if len(files) <= keep {
 		return nil
 	}
 
+	var checkpoint uint64
+	if wm.checkpoint != nil {
+		checkpoint = wm.checkpoint.Offset
+	}
+
 	// Remove oldest files
-	toRemove := files[:len(files)-WALRetentionCount]
-	for _, filename := range toRemove {
-		path := filepath.Join(wm.rootDir, filename)
+	toRemove := files[:len(files)-keep]
+	for i, filename := range toRemove {
+		if next, ok := walFileStartOffset(files[i+1]); !ok || next > checkpoint {
+			break
+		}
+		path := filepath.Join(wm.dir, filename)
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("failed to remove old WAL file: %w", err)
+		}
 		if err := os.Remove(path); err != nil {
 			return fmt.Errorf("failed to remove old WAL file: %w", err)
 		}
+		wm.totalSize -= info.Size()
 	}
 
 	return nil
 }
 
-// loadCheckpoint loads the checkpoint from disk
-func (wm *WALManager) loadCheckpoint() error {
-	path := filepath.Join(wm.rootDir, WALCheckpointFile)
-	data, err := os.ReadFile(path)
+// Truncate removes every WAL file whose entries the checkpoint covers,
+// ignoring the retention count. It first starts a new file, so the current
+// one goes too when no entry was appended after the checkpoint.
+func (wm *WALManager) Truncate() error {
+	wm.batchMu.Lock()
+	defer wm.batchMu.Unlock()
+
+	if err := wm.flushBatchLocked(); err != nil {
+		return err
+	}
+
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	if wm.currentSize > 0 {
+		if err := wm.writer.Flush(); err != nil {
+			return fmt.Errorf("failed to flush WAL: %w", err)
+		}
+		wm.currentFile.Close()
+		if err := wm.openCurrentWAL(); err != nil {
+			return err
+		}
+	}
+	return wm.removeCheckpointedLocked(1)
+}
+
+// Size returns the bytes held by all WAL files
+func (wm *WALManager) Size() int64 {
+	wm.mu.RLock()
+	defer wm.mu.RUnlock()
+	return wm.totalSize
+}
+
+// measureLocked sets totalSize from the WAL files on disk (caller must hold mu)
+func (wm *WALManager) measureLocked() error {
+	files, err := wm.getWALFilesLocked()
 	if err != nil {
-		if os.IsNotExist(err) {
-			wm.checkpoint = &WALCheckpoint{Offset: 0}
-			return nil
+		return err
+	}
+
+	wm.totalSize = 0
+	for _, filename := range files {
+		info, err := os.Stat(filepath.Join(wm.dir, filename))
+		if err != nil {
+			return fmt.Errorf("failed to read WAL: %w", err)
 		}
+		wm.totalSize += info.Size()
+	}
+	return nil
+}
+
+// walFileStartOffset returns the offset of the first entry of a WAL file,
+// which is part of its name
+func walFileStartOffset(filename string) (uint64, bool) {
+	name := strings.TrimSuffix(filename, ".log")
+	offset, err := strconv.ParseUint(name[strings.LastIndex(name, "-")+1:], 10, 64)
+	return offset, err == nil
+}
+
+// resumeOffset moves the next offset past the entries already written, which
+// may be ahead of the checkpoint. Only the newest WAL file with entries is read.
+func (wm *WALManager) resumeOffset() error {
+	files, err := wm.getWALFilesLocked()
+	if err != nil {
 		return err
 	}
 
-	var cp WALCheckpoint
-	if err := json.Unmarshal(data, &cp); err != nil {
+	for i := len(files) - 1; i >= 0; i-- {
+		size, err := scanWALFile(filepath.Join(wm.dir, files[i]), func(entry *WALEntry) {
+			wm.currentOffset = max(wm.currentOffset, entry.Offset+1)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to read WAL: %w", err)
+		}
+		if size > 0 {
+			break
+		}
+	}
+	return nil
+}
+
+// loadCheckpoint loads the checkpoint from disk
+func (wm *WALManager) loadCheckpoint() error {
+	cp, err := readWALCheckpoint(wm.dir)
+	if err != nil {
 		return err
 	}
+	if cp == nil {
+		wm.checkpoint = &WALCheckpoint{Offset: 0}
+		return nil
+	}
 
-	wm.checkpoint = &cp
+	wm.checkpoint = cp
 	wm.currentOffset = cp.Offset + 1
 
 	return nil
 }
 
+// readWALCheckpoint reads the checkpoint of the WAL in dir, or returns nil if
+// there is none
+func readWALCheckpoint(dir string) (*WALCheckpoint, error) {
+	data, err := os.ReadFile(filepath.Join(dir, WALCheckpointFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var cp WALCheckpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
 // saveCheckpointLocked saves the checkpoint to disk (caller must hold mu)
 func (wm *WALManager) saveCheckpointLocked() error {
-	path := filepath.Join(wm.rootDir, WALCheckpointFile)
-	data, err := json.Marshal(wm.checkpoint)
+	path := filepath.Join(wm.dir, WALCheckpointFile)
+	data, err := marshalJSON(wm.checkpoint)
 	if err != nil {
 		return err
 	}
 
-	return os.WriteFile(path, data, 0644)
+	return os.WriteFile(path, data, wm.perms.FileMode)
 }
 
 // Close closes the WAL manager
@@ -481,47 +785,175 @@ func (wm *WALManager) Close() error {
 	return nil
 }
 
-// Replay replays WAL entries to restore database state
-func (wm *WALManager) Replay(dm *DatabaseManager, storage *StorageManager) error {
+// Replay replays WAL entries to restore database state. Changes are applied
+// in memory and marked dirty in storage; saving them and checkpointing is up
+// to the caller. Entries already reflected in saved data are applied again
+// harmlessly. If progress is not nil, it is called after each replayed entry.
+func (wm *WALManager) Replay(dm *DatabaseManager, storage *StorageManager, progress func(ReplayProgress)) (*ReplaySummary, error) {
+	start := time.Now()
 	checkpoint := wm.GetCheckpoint()
 
 	// Read entries after checkpoint
-	entries, err := wm.ReadFrom(checkpoint.Offset)
+	wm.mu.RLock()
+	entries, skipped, err := wm.readFromLocked(checkpoint.Offset)
+	wm.mu.RUnlock()
 	if err != nil {
-		return fmt.Errorf("failed to read WAL for replay: %w", err)
+		return nil, fmt.Errorf("failed to read WAL for replay: %w", err)
 	}
 
+	summary, err := wm.replayEntries(entries, checkpoint.Offset, dm, storage, progress, stopAfterTime(time.Time{}), nil)
+	if err != nil {
+		return nil, err
+	}
+	summary.Skipped = skipped
+	wm.advancePast(entries)
+
+	summary.Duration = time.Since(start)
+	return summary, nil
+}
+
+// ReplayDatabase is like Replay but only applies the entries of the database
+// dbName and, if collName is not empty, of that collection; the others are
+// counted as filtered. The checkpoint covers all databases, so the caller
+// must not checkpoint afterwards or the filtered entries would be lost.
+func (wm *WALManager) ReplayDatabase(dm *DatabaseManager, storage *StorageManager, progress func(ReplayProgress), dbName, collName string) (*ReplaySummary, error) {
+	start := time.Now()
+	checkpoint := wm.GetCheckpoint()
+
+	wm.mu.RLock()
+	entries, skipped, err := wm.readFromLocked(checkpoint.Offset)
+	wm.mu.RUnlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read WAL for replay: %w", err)
+	}
+
+	include := func(entry *WALEntry) bool {
+		return entry.Database == dbName && (collName == "" || entry.Collection == collName)
+	}
+	summary, err := wm.replayEntries(entries, checkpoint.Offset, dm, storage, progress, stopAfterTime(time.Time{}), include)
+	if err != nil {
+		return nil, err
+	}
+	summary.Skipped = skipped
+	wm.advancePast(entries)
+
+	summary.Duration = time.Since(start)
+	return summary, nil
+}
+
+// advancePast makes new entries come after all replayed ones, which can be
+// past the offset restored from the checkpoint
+func (wm *WALManager) advancePast(entries []*WALEntry) {
 	if len(entries) == 0 {
-		return nil // Nothing to replay
+		return
 	}
+	wm.mu.Lock()
+	wm.currentOffset = max(wm.currentOffset, entries[len(entries)-1].Offset+1)
+	wm.mu.Unlock()
+}
 
-	// Replay each entry
+// replayEntries applies entries in order until stop reports one that must not
+// be applied, leaving out those include rejects if it is not nil; from is the
+// offset replay started at
+func (wm *WALManager) replayEntries(entries []*WALEntry, from uint64, dm *DatabaseManager, storage *StorageManager, progress func(ReplayProgress), stop, include func(*WALEntry) bool) (*ReplaySummary, error) {
+	summary := &ReplaySummary{
+		Operations: make(map[string]int),
+		LastOffset: from,
+	}
+
+	state := ReplayProgress{TotalEntries: len(entries)}
 	for _, entry := range entries {
-		if err := wm.replayEntry(entry, dm, storage); err != nil {
-			return fmt.Errorf("failed to replay entry at offset %d: %w", entry.Offset, err)
+		state.TotalBytes += entry.size
+	}
+
+	// Batches are applied all or nothing
+	incomplete := incompleteBatches(entries)
+
+	// Replay each entry
+	for i, entry := range entries {
+		if stop(entry) {
+			summary.Discarded = len(entries) - i
+			break
+		}
+
+		if include != nil && !include(entry) {
+			summary.Filtered++
+		} else if entry.BatchSize > 0 && incomplete[entry.Batch] {
+			summary.Incomplete++
+		} else if db := dm.GetDatabase(entry.Database); db != nil && entry.Collection != "" && db.IsUnavailable(entry.Collection) {
+			summary.Dropped++
+		} else {
+			if err := wm.replayEntry(entry, dm, storage); err != nil {
+				return nil, fmt.Errorf("failed to replay entry at offset %d: %w", entry.Offset, err)
+			}
+			summary.Replayed++
+			summary.Operations[entry.Operation]++
+			summary.LastTimestamp = entry.Timestamp
+		}
+		summary.LastOffset = entry.Offset
+
+		state.Entries++
+		state.Bytes += entry.size
+		state.Offset, state.Timestamp = entry.Offset, entry.Timestamp
+		if progress != nil {
+			progress(state)
+		}
+	}
+
+	return summary, nil
+}
+
+// stopAfterTime stops replay at the first entry appended after until, or
+// never for a zero until. Entries of a batch share a timestamp, so batches
+// are never split.
+func stopAfterTime(until time.Time) func(*WALEntry) bool {
+	return func(entry *WALEntry) bool {
+		return !until.IsZero() && entry.Timestamp.After(until)
+	}
+}
+
+// stopAfterOffset stops replay at the first entry after offset, or at the
+// start of a batch that doesn't end by then
+func stopAfterOffset(offset uint64) func(*WALEntry) bool {
+	return func(entry *WALEntry) bool {
+		if entry.BatchSize > 0 {
+			return entry.Batch+uint64(entry.BatchSize)-1 > offset
 		}
+		return entry.Offset > offset
 	}
+}
 
-	// Update checkpoint to latest offset
-	if len(entries) > 0 {
-		lastOffset := entries[len(entries)-1].Offset
-		if err := wm.Checkpoint(lastOffset); err != nil {
-			return fmt.Errorf("failed to checkpoint after replay: %w", err)
+// incompleteBatches returns the batches (by first offset) missing some of
+// their entries, i.e. cut short by a crash while being written
+func incompleteBatches(entries []*WALEntry) map[uint64]bool {
+	counts := make(map[uint64]int)
+	sizes := make(map[uint64]int)
+	for _, entry := range entries {
+		if entry.BatchSize > 0 {
+			counts[entry.Batch]++
+			sizes[entry.Batch] = entry.BatchSize
 		}
 	}
 
-	return nil
+	incomplete := make(map[uint64]bool)
+	for batch, count := range counts {
+		if count != sizes[batch] {
+			incomplete[batch] = true
+		}
+	}
+	return incomplete
 }
 
 // replayEntry replays a single WAL entry
 func (wm *WALManager) replayEntry(entry *WALEntry, dm *DatabaseManager, storage *StorageManager) error {
 	switch entry.Operation {
 	case WALOpCreateDatabase:
-		db := dm.CreateDatabase(entry.Database)
-		return storage.SaveDatabase(db)
+		dm.CreateDatabase(entry.Database)
+		storage.MarkDirty(entry.Database, "")
+		return nil
 
 	case WALOpDeleteDatabase:
-		dm.DeleteDatabase(entry.Database)
+		dm.RemoveDatabase(entry.Database)
 		return storage.DeleteDatabase(entry.Database)
 
 	case WALOpCreateCollection:
@@ -532,17 +964,48 @@ func (wm *WALManager) replayEntry(entry *WALEntry, dm *DatabaseManager, storage
 
 		// Deserialize collection data
 		var collData struct {
-			Name   string  `json:"name"`
-			Schema *Schema `json:"schema"`
+			Name   string        `json:"name"`
+			Schema *Schema       `json:"schema"`
+			Format StorageFormat `json:"format"`
+			Shards int           `json:"shards"`
 		}
-		if err := json.Unmarshal(entry.Data, &collData); err != nil {
-			return err
+		if len(entry.Data) > 0 {
+			if err := json.Unmarshal(entry.Data, &collData); err != nil {
+				return err
+			}
 		}
 
-		if err := db.CreateCollection(collData.Name, collData.Schema); err != nil {
+		// Older entries carry only the schema
+		if collData.Name == "" {
+			collData.Name = entry.Collection
+			if len(entry.Data) > 0 {
+				if err := json.Unmarshal(entry.Data, &collData.Schema); err != nil {
+					return err
+				}
+			}
+		}
+
+		// The collection may be in a snapshot saved after the entry was logged
+		if err := db.CreateCollection(collData.Name, collData.Schema); err != nil && !errors.Is(err, ErrAlreadyExists) {
 			return err
 		}
-		return storage.SaveDatabase(db)
+
+		if collData.Format != "" || collData.Shards > 0 {
+			coll, err := db.GetCollection(collData.Name)
+			if err != nil {
+				return err
+			}
+			if collData.Format != "" {
+				if err := coll.SetFormat(collData.Format); err != nil {
+					return err
+				}
+			}
+			if err := coll.SetShards(collData.Shards); err != nil {
+				return err
+			}
+		}
+		storage.MarkDirty(entry.Database, "")
+		return nil
 
 	case WALOpInsert:
 		db := dm.GetDatabase(entry.Database)
@@ -560,11 +1023,13 @@ func (wm *WALManager) replayEntry(entry *WALEntry, dm *DatabaseManager, storage
 		if err := json.Unmarshal(entry.Data, &doc); err != nil {
 			return err
 		}
+		if err := storage.decryptDocument(&doc); err != nil {
+			return err
+		}
 
-		if err := coll.Insert(&doc); err != nil {
+		if err := coll.restore(&doc); err != nil {
 			return err
 		}
-		return storage.SaveCollection(entry.Database, coll)
 
 	case WALOpUpdate:
 		db := dm.GetDatabase(entry.Database)
@@ -577,16 +1042,19 @@ func (wm *WALManager) replayEntry(entry *WALEntry, dm *DatabaseManager, storage
 			return err
 		}
 
-		// Deserialize updates
-		var updates map[string]any
-		if err := json.Unmarshal(entry.Data, &updates); err != nil {
+		// The entry holds the whole updated document
+		var doc Document
+		if err := json.Unmarshal(entry.Data, &doc); err != nil {
+			return err
+		}
+		doc.ID = entry.DocumentID
+		if err := storage.decryptDocument(&doc); err != nil {
 			return err
 		}
 
-		if err := coll.Update(entry.DocumentID, updates); err != nil {
+		if err := coll.restore(&doc); err != nil {
 			return err
 		}
-		return storage.SaveCollection(entry.Database, coll)
 
 	case WALOpDelete:
 		db := dm.GetDatabase(entry.Database)
@@ -599,10 +1067,9 @@ func (wm *WALManager) replayEntry(entry *WALEntry, dm *DatabaseManager, storage
 			return err
 		}
 
-		if err := coll.Delete(entry.DocumentID); err != nil {
+		if err := coll.Delete(entry.DocumentID); err != nil && !errors.Is(err, ErrNotFound) {
 			return err
 		}
-		return storage.SaveCollection(entry.Database, coll)
 
 	case WALOpCreateIndex:
 		db := dm.GetDatabase(entry.Database)
@@ -624,12 +1091,135 @@ func (wm *WALManager) replayEntry(entry *WALEntry, dm *DatabaseManager, storage
 			return err
 		}
 
-		if err := coll.CreateIndex(indexData.IndexName, indexData.FieldName); err != nil {
+		if err := coll.CreateIndex(indexData.IndexName, indexData.FieldName); err != nil && !errors.Is(err, ErrAlreadyExists) {
+			return err
+		}
+
+	case WALOpDropIndex:
+		db := dm.GetDatabase(entry.Database)
+		if db == nil {
+			return fmt.Errorf("database %s not found during replay", entry.Database)
+		}
+
+		coll, err := db.GetCollection(entry.Collection)
+		if err != nil {
+			return err
+		}
+
+		var indexData struct {
+			IndexName string `json:"index_name"`
+		}
+		if err := json.Unmarshal(entry.Data, &indexData); err != nil {
+			return err
+		}
+
+		if err := coll.DropIndex(indexData.IndexName); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+
+	case WALOpSetSchema:
+		db := dm.GetDatabase(entry.Database)
+		if db == nil {
+			return fmt.Errorf("database %s not found during replay", entry.Database)
+		}
+
+		coll, err := db.GetCollection(entry.Collection)
+		if err != nil {
+			return err
+		}
+
+		// Deserialize schema (empty data means the schema was removed)
+		var schema *Schema
+		if len(entry.Data) > 0 {
+			if err := json.Unmarshal(entry.Data, &schema); err != nil {
+				return err
+			}
+		}
+
+		// Documents were validated when the change was first applied
+		if _, err := coll.setSchema(schema, false, false); err != nil {
+			return err
+		}
+
+	case WALOpSetQueryLimits:
+		db := dm.GetDatabase(entry.Database)
+		if db == nil {
+			return fmt.Errorf("database %s not found during replay", entry.Database)
+		}
+
+		coll, err := db.GetCollection(entry.Collection)
+		if err != nil {
+			return err
+		}
+
+		var limits QueryLimits
+		if err := json.Unmarshal(entry.Data, &limits); err != nil {
+			return err
+		}
+
+		if err := coll.SetQueryLimits(limits); err != nil {
+			return err
+		}
+
+	case WALOpSetIDKey:
+		db := dm.GetDatabase(entry.Database)
+		if db == nil {
+			return fmt.Errorf("database %s not found during replay", entry.Database)
+		}
+
+		coll, err := db.GetCollection(entry.Collection)
+		if err != nil {
+			return err
+		}
+
+		var key *IDKey
+		if err := json.Unmarshal(entry.Data, &key); err != nil {
+			return err
+		}
+
+		if err := coll.SetIDKey(key); err != nil {
+			return err
+		}
+
+	case WALOpSetCollation:
+		db := dm.GetDatabase(entry.Database)
+		if db == nil {
+			return fmt.Errorf("database %s not found during replay", entry.Database)
+		}
+
+		coll, err := db.GetCollection(entry.Collection)
+		if err != nil {
+			return err
+		}
+
+		if err := coll.SetCollation(Collation(entry.Data)); err != nil {
+			return err
+		}
+
+	case WALOpSetInsertionOrder:
+		db := dm.GetDatabase(entry.Database)
+		if db == nil {
+			return fmt.Errorf("database %s not found during replay", entry.Database)
+		}
+
+		coll, err := db.GetCollection(entry.Collection)
+		if err != nil {
+			return err
+		}
+
+		var enabled bool
+		if err := json.Unmarshal(entry.Data, &enabled); err != nil {
+			return err
+		}
+
+		if err := coll.SetInsertionOrder(enabled); err != nil {
 			return err
 		}
-		return storage.SaveCollection(entry.Database, coll)
 
 	default:
 		return fmt.Errorf("unknown WAL operation: %s", entry.Operation)
 	}
+
+	storage.MarkDirty(entry.Database, entry.Collection)
+	return nil
 }
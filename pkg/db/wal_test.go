@@ -0,0 +1,177 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestReplayUniqueValueTakenOverBySavedDocument(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDB(t, dir)
+	schema := &Schema{Fields: map[string]Field{"email": {Type: TypeString, Unique: true}}}
+	if _, err := d.CreateCollection("users", schema); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	mustInsert(t, d, "users", "a", map[string]any{"email": "x"})
+	if err := d.Delete("users", "a"); err != nil {
+		t.Fatal(err)
+	}
+	mustInsert(t, d, "users", "b", map[string]any{"email": "x"})
+
+	// Save without checkpointing, as a crash between the two leaves it: the
+	// saved data holds b, and the WAL still starts with the insert of a
+	coll, err := d.Collection("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Storage().SaveCollection("app", coll); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := openTestDB(t, dir)
+	defer reopened.Close()
+
+	coll, err = reopened.Collection("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := coll.Count(); n != 1 {
+		t.Fatalf("Count = %d, want 1", n)
+	}
+	if _, err := coll.FindByID("b"); err != nil {
+		t.Fatalf("FindByID(b): %v", err)
+	}
+	if _, err := reopened.Insert("users", &Document{ID: "c", Data: map[string]any{"email": "x"}}); err == nil {
+		t.Fatal("duplicate email accepted after replay")
+	}
+}
+
+func TestWALEntryOffsetsAndTimes(t *testing.T) {
+	dir := t.TempDir()
+	t0 := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	clock := NewFakeClock(t0)
+	d := openTestDB(t, dir, WithClock(clock))
+	if _, err := d.CreateCollection("items", nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		clock.Advance(time.Minute)
+		mustInsert(t, d, "items", id, map[string]any{"n": 1})
+	}
+	if err := d.Storage().WAL.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := d.Storage().WAL.ReadFrom(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// create_database and create_collection, then the inserts
+	wantTimes := []time.Time{t0, t0, t0.Add(time.Minute), t0.Add(2 * time.Minute), t0.Add(3 * time.Minute)}
+	if len(entries) != len(wantTimes) {
+		t.Fatalf("got %d entries, want %d", len(entries), len(wantTimes))
+	}
+	for i, entry := range entries {
+		if i > 0 && entry.Offset <= entries[i-1].Offset {
+			t.Errorf("entry %d has offset %d, not after %d", i, entry.Offset, entries[i-1].Offset)
+		}
+		if want := wantTimes[i]; !entry.Timestamp.Equal(want) {
+			t.Errorf("entry %d has timestamp %v, want %v", i, entry.Timestamp, want)
+		}
+	}
+
+	// Reopen without closing, as after a crash, so the entries are replayed
+	var progress []ReplayProgress
+	reopened := openTestDB(t, dir, WithReplayProgress(func(p ReplayProgress) {
+		progress = append(progress, p)
+	}))
+	defer reopened.Close()
+
+	summary := reopened.Storage().LastReplay()
+	if summary == nil || summary.Replayed != len(entries) {
+		t.Fatalf("LastReplay = %+v, want %d entries replayed", summary, len(entries))
+	}
+	last := entries[len(entries)-1]
+	if summary.LastOffset != last.Offset || !summary.LastTimestamp.Equal(last.Timestamp) {
+		t.Errorf("LastReplay ends at %d, %v, want %d, %v", summary.LastOffset, summary.LastTimestamp, last.Offset, last.Timestamp)
+	}
+	if len(progress) != len(entries) {
+		t.Fatalf("got %d progress reports, want %d", len(progress), len(entries))
+	}
+	for i, p := range progress {
+		if p.Offset != entries[i].Offset || !p.Timestamp.Equal(entries[i].Timestamp) || p.Entries != i+1 {
+			t.Errorf("progress %d = %+v, want entry at offset %d", i, p, entries[i].Offset)
+		}
+	}
+}
+
+func TestReplaySkipsBatchCutShort(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDB(t, dir)
+	if _, err := d.CreateCollection("items", nil); err != nil {
+		t.Fatal(err)
+	}
+	docs := func(ids ...string) []*Document {
+		var docs []*Document
+		for _, id := range ids {
+			docs = append(docs, &Document{ID: id, Data: map[string]any{"n": 1}})
+		}
+		return docs
+	}
+	if _, err := d.InsertMany("items", docs("a", "b")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.InsertMany("items", docs("c", "d", "e")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Cut the last entry in half, as a crash while writing the batch would
+	matches, err := filepath.Glob(filepath.Join(dir, WALFilePrefix+"*"))
+	if err != nil || len(matches) != 1 {
+		t.Fatalf("WAL files = %v, %v, want one", matches, err)
+	}
+	var sizes []int64
+	size, err := scanWALFile(matches[0], func(entry *WALEntry) {
+		sizes = append(sizes, entry.size)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(matches[0], size-sizes[len(sizes)-1]/2); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := openTestDB(t, dir)
+	coll, err := reopened.Collection("items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := findIDs(t, coll, NewQuery().Build()); !slices.Equal(got, []string{"a", "b"}) {
+		t.Errorf("after replay: %v, want [a b]", got)
+	}
+	if summary := reopened.Storage().LastReplay(); summary == nil || summary.Incomplete != 2 {
+		t.Errorf("LastReplay = %+v, want 2 incomplete entries", summary)
+	}
+
+	// The entries of the lost batch are overwritten by new writes
+	mustInsert(t, reopened, "items", "c", map[string]any{"n": 2})
+	if err := reopened.Close(); err != nil {
+		t.Fatal(err)
+	}
+	again := openTestDB(t, dir)
+	defer again.Close()
+	coll, err = again.Collection("items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := findIDs(t, coll, NewQuery().Build()); !slices.Equal(got, []string{"a", "b", "c"}) {
+		t.Errorf("after reopening: %v, want [a b c]", got)
+	}
+}